@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+	"go-data-tool/internal/api"
+	"net/http"
+
+	"github.com/spf13/cobra"
+)
+
+// authFlags are the authentication settings of a command making HTTP requests
+type authFlags struct {
+	authType string   // none, bearer, api-key, basic, hmac or oauth2
+	secret   string   // env:NAME or file:PATH with the token, key, password or client secret
+	user     string   // user of basic auth or client ID of OAuth2
+	header   string   // header of the API key or the HMAC signature
+	tokenURL string   // OAuth2 token endpoint
+	scopes   []string // OAuth2 scopes
+}
+
+// addAuthFlags registers the authentication flags of a command, each command keeps its own values
+func addAuthFlags(cmd *cobra.Command, flags *authFlags) {
+	cmd.Flags().StringVar(&flags.authType, "auth", "none", `authentication of the requests:
+none
+bearer - "Authorization: Bearer <secret>"
+api-key - the secret in the auth-header header
+basic - HTTP basic auth of auth-user with the secret as password
+hmac - HMAC-SHA256 signature of method, path, timestamp and body in the auth-header header, timestamp in X-Timestamp
+oauth2 - OAuth2 client credentials of auth-user with the secret, tokens from token-url are renewed automatically`)
+	cmd.Flags().StringVar(&flags.secret, "auth-secret", "", `source of the secret: env:NAME for an environment variable or file:PATH
+secrets are never passed directly, so that they don't appear in the shell history`)
+	cmd.Flags().StringVar(&flags.user, "auth-user", "", "user of basic auth or client ID of oauth2")
+	cmd.Flags().StringVar(&flags.header, "auth-header", "", "header of the api-key (X-API-Key by default) or the hmac signature (X-Signature by default)")
+	cmd.Flags().StringVar(&flags.tokenURL, "token-url", "", "token endpoint of oauth2")
+	cmd.Flags().StringSliceVar(&flags.scopes, "scope", []string{}, "set of scopes requested by oauth2")
+}
+
+// newAuthenticator creates the authenticator chosen by the flags, nil for none
+func newAuthenticator(flags authFlags, client *http.Client) (api.Authenticator, error) {
+	if flags.authType == "none" {
+		return nil, nil
+	}
+	switch flags.authType {
+	case "bearer", "api-key", "basic", "hmac", "oauth2":
+	default:
+		return nil, fmt.Errorf("unknown authentication '%s'", flags.authType)
+	}
+	if flags.secret == "" {
+		return nil, fmt.Errorf("authentication '%s' requires the 'auth-secret' flag", flags.authType)
+	}
+	secret, err := api.ReadSecret(flags.secret)
+	if err != nil {
+		return nil, err
+	}
+
+	header := func(fallback string) string {
+		if flags.header == "" {
+			return fallback
+		}
+		return flags.header
+	}
+	switch flags.authType {
+	case "bearer":
+		return &api.HeaderAuth{Name: "Authorization", Value: "Bearer " + secret}, nil
+	case "api-key":
+		return &api.HeaderAuth{Name: header("X-API-Key"), Value: secret}, nil
+	case "basic":
+		return &api.BasicAuth{Username: flags.user, Password: secret}, nil
+	case "hmac":
+		return &api.HMACAuth{Key: []byte(secret), Header: header("X-Signature"), TimestampHeader: "X-Timestamp"}, nil
+	}
+
+	if flags.user == "" || flags.tokenURL == "" {
+		return nil, fmt.Errorf("authentication 'oauth2' requires the 'auth-user' and 'token-url' flags")
+	}
+	return &api.ClientCredentials{
+		TokenURL:     flags.tokenURL,
+		ClientID:     flags.user,
+		ClientSecret: secret,
+		Scopes:       flags.scopes,
+		Client:       client,
+	}, nil
+}
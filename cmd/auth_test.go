@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewAuthenticatorHeaders(t *testing.T) {
+	t.Setenv("TEST_API_SECRET", "s3cret")
+
+	tests := []struct {
+		auth, user, header string
+		name, want         string
+	}{
+		{"bearer", "", "", "Authorization", "Bearer s3cret"},
+		{"api-key", "", "", "X-API-Key", "s3cret"},
+		{"api-key", "", "X-Token", "X-Token", "s3cret"},
+		{"basic", "alice", "", "Authorization", "Basic YWxpY2U6czNjcmV0"},
+	}
+	for _, test := range tests {
+		flags := authFlags{authType: test.auth, secret: "env:TEST_API_SECRET", user: test.user, header: test.header}
+		auth, err := newAuthenticator(flags, http.DefaultClient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+		if err := auth.Authenticate(req); err != nil {
+			t.Fatal(err)
+		}
+		if got := req.Header.Get(test.name); got != test.want {
+			t.Errorf("%s: %s = %q, want %q", test.auth, test.name, got, test.want)
+		}
+	}
+
+	failures := []struct {
+		flags authFlags
+		want  string
+	}{
+		{authFlags{authType: "basic"}, "requires the 'auth-secret' flag"},
+		{authFlags{authType: "digest", secret: "env:TEST_API_SECRET"}, "unknown authentication"},
+		{authFlags{authType: "oauth2", secret: "env:TEST_API_SECRET", user: "client"}, "requires the 'auth-user' and 'token-url' flags"},
+	}
+	for _, test := range failures {
+		if _, err := newAuthenticator(test.flags, http.DefaultClient); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%+v: error %v, want %q", test.flags, err, test.want)
+		}
+	}
+	if auth, err := newAuthenticator(authFlags{authType: "none"}, http.DefaultClient); auth != nil || err != nil {
+		t.Errorf("no authentication = %v, %v", auth, err)
+	}
+}
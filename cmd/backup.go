@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"go-data-tool/internal/api"
+	"go-data-tool/internal/backup"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	backupDest        string // destination directory of the snapshots
+	backupCompression string // none or gzip
+	backupSnapshot    string // snapshot to verify, all if empty
+	// Retention policy of prune
+	backupRetention backup.Retention
+	backupDryRun    bool
+	// Encryption
+	backupPassphrase string // env:NAME or file:PATH with the passphrase
+	backupKeyFile    string // key file
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup <paths...>",
+	Short: "Creating backup copies of files",
+	Long: `The backup command copies files and directories to a new timestamped snapshot in the destination directory.
+Every snapshot has a manifest with the SHA-256 checksums of the files, which the verify subcommand checks the copies against.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		start := time.Now()
+		compression, err := backup.ParseCompression(backupCompression)
+		if err != nil {
+			return err
+		}
+
+		repo, err := openRepository(backupDest, true)
+		if err != nil {
+			return err
+		}
+		recordInputs(args...)
+		slog.Info("Backing up", "paths", args, "dest", backupDest, "encrypted", repo.Encrypted())
+		manifest, err := repo.Create(args, compression, time.Now())
+		if err != nil {
+			return fmt.Errorf("creating backup: %w", err)
+		}
+		recordOutputs(filepath.Join(backupDest, manifest.ID))
+		recordCount("files", int64(len(manifest.Files)))
+		recordCount("bytes", manifest.Size())
+		recordCount("bytes_added", manifest.Added)
+		slog.Info("Snapshot created",
+			"snapshot", manifest.ID,
+			"files", len(manifest.Files),
+			"bytes", manifest.Size(),
+			"bytes_added", manifest.Added,
+			"duration", time.Since(start),
+		)
+		return nil
+	},
+}
+
+var backupVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Checking backup copies against their checksums",
+	Long: `The verify subcommand re-hashes the copies of the snapshots in the destination directory
+and reports the missing and corrupted ones. It exits with status 1 if any copy doesn't match.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		repo, err := openRepository(backupDest, false)
+		if err != nil {
+			return err
+		}
+		var manifests []backup.Manifest
+		if backupSnapshot != "" {
+			manifest, err := repo.FindSnapshot(backupSnapshot)
+			if err != nil {
+				return err
+			}
+			manifests = append(manifests, manifest)
+		} else {
+			manifests, err = repo.Snapshots()
+			if err != nil {
+				return fmt.Errorf("reading snapshots: %w", err)
+			}
+			if len(manifests) == 0 {
+				return fmt.Errorf("no snapshots in '%s'", backupDest)
+			}
+		}
+
+		recordInputs(backupDest)
+		corrupted := 0
+		for _, manifest := range manifests {
+			slog.Info("Verifying snapshot", "snapshot", manifest.ID, "files", len(manifest.Files))
+			problems := repo.Verify(manifest)
+			for _, problem := range problems {
+				slog.Error("Copy is missing or corrupted", "snapshot", problem.Snapshot, "path", problem.Path, "error", problem.Err)
+			}
+			corrupted += len(problems)
+		}
+
+		recordCount("snapshots", int64(len(manifests)))
+		recordCount("corrupted", int64(corrupted))
+		if corrupted != 0 {
+			return fmt.Errorf("%d copies are missing or corrupted", corrupted)
+		}
+		slog.Info("Snapshots verified", "snapshots", len(manifests))
+		return nil
+	},
+}
+
+var backupPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Removing backups by retention rules",
+	Long: `The prune subcommand removes the snapshots in the destination directory that no retention rule keeps.
+Snapshots are dated by the creation time in their manifests, periods are calendar days, ISO weeks, months and years in local time.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		r := backupRetention
+		if r.Last < 0 || r.Daily < 0 || r.Weekly < 0 || r.Monthly < 0 || r.Yearly < 0 {
+			return errors.New("retention counts must not be negative")
+		}
+
+		repo, err := openRepository(backupDest, false)
+		if err != nil {
+			return err
+		}
+		decisions, gc, err := repo.Prune(r, time.Local, backupDryRun)
+		removed := 0
+		for _, decision := range decisions {
+			switch {
+			case decision.Keep:
+				slog.Info("Keeping snapshot", "snapshot", decision.Manifest.ID, "reasons", strings.Join(decision.Reasons, ", "))
+			case decision.Removed || backupDryRun:
+				removed++
+				slog.Info("Removing snapshot", "snapshot", decision.Manifest.ID, "dry_run", backupDryRun)
+			default:
+				// Pruning stopped at a snapshot that couldn't be removed
+				slog.Warn("Snapshot not removed", "snapshot", decision.Manifest.ID)
+			}
+		}
+		if err != nil {
+			recordCount("removed", int64(removed))
+			return fmt.Errorf("pruning snapshots: %w", err)
+		}
+
+		recordOutputs(backupDest)
+		recordCount("snapshots", int64(len(decisions)))
+		recordCount("removed", int64(removed))
+		recordCount("chunks_freed", int64(gc.Chunks))
+		recordCount("bytes_freed", gc.Bytes)
+		message := "Snapshots pruned"
+		if backupDryRun {
+			message = "Snapshots would be pruned"
+		}
+		slog.Info(message,
+			"snapshots", len(decisions),
+			"removed", removed,
+			"chunks_freed", gc.Chunks,
+			"bytes_freed", gc.Bytes,
+			"dry_run", backupDryRun,
+		)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(backupCmd)
+	backupCmd.AddCommand(backupVerifyCmd)
+	backupCmd.AddCommand(backupPruneCmd)
+
+	backupCmd.PersistentFlags().StringVarP(&backupDest, "dest", "d", "", "destination directory of the snapshots (required)")
+	backupCmd.MarkPersistentFlagRequired("dest")
+	addEncryptionFlags(backupCmd.PersistentFlags())
+	backupCmd.Flags().StringVar(&backupCompression, "compression", string(backup.NoCompression), "compression of the copies: none or gzip")
+
+	backupVerifyCmd.Flags().StringVar(&backupSnapshot, "snapshot", "", "ID of the snapshot to verify (all snapshots if empty)")
+
+	backupPruneCmd.Flags().IntVar(&backupRetention.Last, "keep-last", 0, "number of newest snapshots to keep")
+	backupPruneCmd.Flags().IntVar(&backupRetention.Daily, "keep-daily", 0, "number of last days to keep the newest snapshot of")
+	backupPruneCmd.Flags().IntVar(&backupRetention.Weekly, "keep-weekly", 0, "number of last weeks to keep the newest snapshot of")
+	backupPruneCmd.Flags().IntVar(&backupRetention.Monthly, "keep-monthly", 0, "number of last months to keep the newest snapshot of")
+	backupPruneCmd.Flags().IntVar(&backupRetention.Yearly, "keep-yearly", 0, "number of last years to keep the newest snapshot of")
+	backupPruneCmd.Flags().BoolVar(&backupDryRun, "dry-run", false, "only list the snapshots that would be removed")
+}
+
+// addEncryptionFlags registers the flags unlocking encrypted destinations
+func addEncryptionFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&backupPassphrase, "passphrase", "", `source of the passphrase of an encrypted destination: env:NAME or file:PATH
+the first backup with a passphrase or key file makes a new destination encrypted`)
+	flags.StringVar(&backupKeyFile, "key-file", "", "key file of an encrypted destination, at least 32 random bytes")
+}
+
+// openRepository opens the destination with the secret from the flags
+func openRepository(dest string, create bool) (*backup.Repository, error) {
+	var secret *backup.Secret
+	switch {
+	case backupPassphrase != "" && backupKeyFile != "":
+		return nil, errors.New("only one of the 'passphrase' and 'key-file' flags can be used")
+	case backupPassphrase != "":
+		passphrase, err := api.ReadSecret(backupPassphrase)
+		if err != nil {
+			return nil, fmt.Errorf("reading passphrase: %w", err)
+		}
+		secret = &backup.Secret{Passphrase: []byte(passphrase)}
+	case backupKeyFile != "":
+		key, err := os.ReadFile(backupKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading key file: %w", err)
+		}
+		secret = &backup.Secret{KeyFile: key}
+	}
+
+	repo, err := backup.OpenRepository(dest, secret, create)
+	if err != nil {
+		return nil, fmt.Errorf("opening '%s': %w", dest, err)
+	}
+	return repo, nil
+}
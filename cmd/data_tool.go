@@ -4,26 +4,125 @@ Copyright © 2025 ArtemDaemon artem.daemon.official@gmail.com
 package cmd
 
 import (
+	"errors"
+	"fmt"
+	"go-data-tool/internal/csv"
+	"go-data-tool/internal/logging"
+	"go-data-tool/internal/progress"
+	"io"
+	"log/slog"
 	"os"
 
 	"github.com/spf13/cobra"
 )
 
+var (
+	logLevel  string // debug, info, warn or error
+	logFormat string // text or json
+	logFile   string // log file, standard error if empty
+	// Rotation of the log file
+	logMaxSize  int64
+	logMaxFiles int
+)
+
+// logOutput is the open log file, closed when the program ends
+var logOutput io.Closer
+
+// stderr is shared by the log and progress lines
+var stderr = progress.NewLine(os.Stderr)
+
+// stderrIsTerminal tells whether progress can be shown on standard error, tests replace it
+var stderrIsTerminal = func() bool { return progress.IsTerminal(os.Stderr) }
+
+// exitStatus of a command that succeeded but reports a result by its status, like diff with exit-code
+var exitStatus int
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "go-data-tool",
 	Short: "Universal CLI utility for performing basic data operations",
 	Long:  `go-data-tool is a CLI utility for working with CSV files, integrating with external APIs, backup and logging`,
+	// Errors are logged by Execute
+	SilenceErrors: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// Flags are parsed, errors from here on are not usage errors
+		cmd.SilenceUsage = true
+		if err := setupLogging(); err != nil {
+			return err
+		}
+		startRun(cmd, args)
+		return nil
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	err := rootCmd.Execute()
+	cmd, err := rootCmd.ExecuteC()
 	if err != nil {
-		os.Exit(1)
+		exitStatus = 1
+		slog.Error("Command failed", errorAttrs(cmd, err)...)
+		// The log file is not watched by the user running the command
+		if logOutput != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+	}
+	finishRun(exitStatus, err)
+	if logOutput != nil {
+		logOutput.Close()
 	}
+	os.Exit(exitStatus)
+}
+
+// errorAttrs describes a failure with the location and value of a bad row when there is one
+func errorAttrs(cmd *cobra.Command, err error) []any {
+	attrs := []any{"error", err}
+	var rowErr *csv.RowError
+	if errors.As(err, &rowErr) {
+		attrs = append(attrs, "file", rowErr.File, "line", rowErr.Line)
+		if rowErr.Column != "" {
+			attrs = append(attrs, "column", rowErr.Column)
+		}
+	}
+	var valueErr *csv.ValueError
+	if errors.As(err, &valueErr) {
+		attrs = append(attrs, "value", valueErr.Value, "expected", valueErr.Type)
+	}
+	if rowErr != nil && cmd != nil && cmd.Flags().Lookup("on-error") != nil {
+		attrs = append(attrs, "hint", "use --on-error skip or --on-error reject to continue past bad rows")
+	}
+	return attrs
 }
 
 func init() {
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "minimum level of logged events: debug, info, warn or error")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", string(logging.TextFormat), "format of the log: text or json")
+	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "", "file the log is appended to (standard error if empty)")
+	rootCmd.PersistentFlags().Int64Var(&logMaxSize, "log-max-size", 10, "size in megabytes at which the log file is rotated (0 for no rotation)")
+	rootCmd.PersistentFlags().IntVar(&logMaxFiles, "log-max-files", 5, "number of rotated log files kept")
+	rootCmd.PersistentFlags().StringVar(&historyFile, "history-file", "", "file the runs are recorded in (go-data-tool/history.jsonl in the user config directory if empty)")
+	rootCmd.PersistentFlags().BoolVar(&noHistory, "no-history", false, "don't record the run in the history")
+}
+
+// setupLogging makes the logger chosen by the flags the default one
+func setupLogging() error {
+	level, err := logging.ParseLevel(logLevel)
+	if err != nil {
+		return err
+	}
+	var out io.Writer = stderr
+	if logFile != "" {
+		file, err := logging.OpenRotatingFile(logFile, logMaxSize*1024*1024, logMaxFiles)
+		if err != nil {
+			return fmt.Errorf("opening log file: %w", err)
+		}
+		logOutput = file
+		out = file
+	}
+	logger, err := logging.NewLogger(out, logging.Format(logFormat), level)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
 }
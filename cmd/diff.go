@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"go-data-tool/internal/csv"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffOld    string   // old snapshot
+	diffNew    string   // new snapshot
+	diffOutput string   // output file, standard output if empty
+	diffKey    []string // slice of key columns
+	diffIgnore []string // slice of columns excluded from comparison
+	diffFormat string   // summary, csv or json
+	// Numeric tolerances
+	diffAbsTolerance float64
+	diffRelTolerance float64
+	// Exit with status 1 when differences are found
+	diffExitCode bool
+	// Sorting on disk
+	diffSortBuffer int
+	diffTempDir    string
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Comparing two snapshots of a CSV file",
+	Long: `The diff command matches the rows of two CSV files by key columns and reports added, removed and changed rows.
+Values are compared according to the column types, both files are sorted by the key on disk if they don't fit in memory.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		start := time.Now()
+		// Check existance of files
+		for _, path := range []string{diffOld, diffNew} {
+			if _, err := os.Stat(path); err != nil && errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("input file '%s' not found", path)
+			}
+		}
+		if diffFormat != "summary" && diffFormat != "csv" && diffFormat != "json" {
+			return fmt.Errorf("unknown output format '%s'", diffFormat)
+		}
+
+		recordInputs(diffOld, diffNew)
+		slog.Info("Parsing files structure", "old", diffOld, "new", diffNew)
+		oldScheme, err := csv.ParseCSVStructure(diffOld)
+		if err != nil {
+			return fmt.Errorf("parsing old csv structure: %w", err)
+		}
+		newScheme, err := csv.ParseCSVStructure(diffNew)
+		if err != nil {
+			return fmt.Errorf("parsing new csv structure: %w", err)
+		}
+
+		diff, err := csv.ParseDiff(diffKey, diffIgnore, oldScheme, newScheme, diffAbsTolerance, diffRelTolerance)
+		if err != nil {
+			return fmt.Errorf("parsing diff: %w", err)
+		}
+
+		var out io.Writer = cmd.OutOrStdout()
+		if diffOutput != "" {
+			file, err := os.Create(diffOutput)
+			if err != nil {
+				return fmt.Errorf("creating output file: %w", err)
+			}
+			defer file.Close()
+			recordOutputs(diffOutput)
+			out = file
+		}
+
+		// Every difference is counted and passed on to the chosen format
+		summary := csv.NewDiffSummary()
+		emit := summary.Add
+		var csvWriter *csv.Writer
+		var patchWriter *csv.JSONPatchWriter
+		switch diffFormat {
+		case "csv":
+			csvWriter = csv.NewStreamWriter(out)
+			if err := csvWriter.Write(csv.DiffCSVHeaders(diff)); err != nil {
+				return fmt.Errorf("writing differences: %w", err)
+			}
+			emit = func(row csv.RowDiff) error {
+				summary.Add(row)
+				for _, record := range csv.DiffCSVRecords(diff, row) {
+					if err := csvWriter.Write(record); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+		case "json":
+			patchWriter, err = csv.NewJSONPatchWriter(out, diff)
+			if err != nil {
+				return fmt.Errorf("writing differences: %w", err)
+			}
+			emit = func(row csv.RowDiff) error {
+				summary.Add(row)
+				return patchWriter.Add(row)
+			}
+		}
+
+		slog.Info("Comparing files", "key", diffKey)
+		if err := diff.Run(diffOld, oldScheme, diffNew, newScheme, diffSortBuffer, diffTempDir, emit); err != nil {
+			return fmt.Errorf("comparing files: %w", err)
+		}
+
+		switch {
+		case csvWriter != nil:
+			err = csvWriter.Close()
+		case patchWriter != nil:
+			err = patchWriter.Close()
+		default:
+			err = summary.Write(out, diff)
+		}
+		if err != nil {
+			return fmt.Errorf("writing differences: %w", err)
+		}
+
+		recordCount("rows_added", int64(summary.Added))
+		recordCount("rows_removed", int64(summary.Removed))
+		recordCount("rows_changed", int64(summary.Changed))
+		slog.Info("Files compared",
+			"added", summary.Added,
+			"removed", summary.Removed,
+			"changed", summary.Changed,
+			"duration", time.Since(start),
+		)
+		if diffExitCode && !summary.Empty() {
+			exitStatus = 1
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().StringVar(&diffOld, "old", "", "old file address (required)")
+	diffCmd.MarkFlagRequired("old")
+	diffCmd.Flags().StringVar(&diffNew, "new", "", "new file address (required)")
+	diffCmd.MarkFlagRequired("new")
+
+	diffCmd.Flags().StringSliceVarP(&diffKey, "key", "k", []string{}, "set of key columns identifying rows (required)")
+	diffCmd.MarkFlagRequired("key")
+	diffCmd.Flags().StringSliceVar(&diffIgnore, "ignore", []string{}, "set of columns excluded from comparison")
+
+	diffCmd.Flags().StringVarP(&diffOutput, "output", "o", "", "output file address (standard output if empty)")
+	diffCmd.Flags().StringVar(&diffFormat, "format", "summary", `output format:
+summary - number of added, removed and changed rows and changes per column
+csv - one line per changed value: change, key columns, column, old value, new value
+json - JSON Patch (RFC 6902) document with rows addressed by key`)
+
+	diffCmd.Flags().Float64Var(&diffAbsTolerance, "abs-tolerance", 0, "numeric values differing by at most this amount are equal")
+	diffCmd.Flags().Float64Var(&diffRelTolerance, "rel-tolerance", 0, "numeric values differing by at most this fraction of the greater value are equal")
+	diffCmd.Flags().BoolVar(&diffExitCode, "exit-code", false, "exit with status 1 when differences are found")
+
+	diffCmd.Flags().IntVar(&diffSortBuffer, "sort-buffer", 100000, "maximum number of rows sorted in memory, larger files are sorted on disk")
+	diffCmd.Flags().StringVar(&diffTempDir, "temp-dir", "", "directory for temporary files (system default if empty)")
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiffExitCode(t *testing.T) {
+	old := writeFile(t, "old.csv", "id,price\n1,10\n2,20\n")
+	output := filepath.Join(t.TempDir(), "diff.txt")
+	defer func() { exitStatus = 0 }()
+
+	tests := []struct {
+		name, content string
+		args          []string
+		status        int
+	}{
+		{"equal", "id,price\n2,20.0\n1,10\n", []string{"--exit-code"}, 0},
+		{"changed", "id,price\n1,10\n2,21\n", []string{"--exit-code"}, 1},
+		{"within the tolerance", "id,price\n1,10\n2,21\n", []string{"--exit-code", "--abs-tolerance", "1"}, 0},
+		{"added", "id,price\n1,10\n2,20\n3,30\n", []string{"--exit-code"}, 1},
+		{"changed without the flag", "id,price\n1,10\n2,21\n", nil, 0},
+	}
+	for _, test := range tests {
+		exitStatus = 0
+		newPath := writeFile(t, "new.csv", test.content)
+		if err := execute(t, append([]string{"diff", "--old", old, "--new", newPath, "-k", "id", "-o", output}, test.args...)...); err != nil {
+			t.Fatal(err)
+		}
+		if exitStatus != test.status {
+			t.Errorf("%s: exit status %d, want %d", test.name, exitStatus, test.status)
+		}
+	}
+
+	// The last run still writes the summary
+	summary, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(summary), "Changed rows: 1\n  price: 1\n") {
+		t.Errorf("summary %q, want 1 changed price", summary)
+	}
+}
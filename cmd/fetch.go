@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"go-data-tool/internal/api"
+	"go-data-tool/internal/csv"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	fetchURL      string   // address of the first page
+	fetchOutput   string   // output file
+	fetchHeaders  []string // slice of headers in the format "Name: value"
+	fetchFilters  []string // slice of installed filters
+	fetchDataPath string   // path of the items in a page
+	// Pagination
+	fetchPaginate   string
+	fetchPageParam  string
+	fetchSizeParam  string
+	fetchPageSize   int
+	fetchPageStart  int
+	fetchCursorPath string
+	fetchMaxPages   int
+	// Requests and temporary files
+	fetchHTTP    httpFlags
+	fetchAuth    authFlags
+	fetchTempDir string
+)
+
+var fetchCmd = &cobra.Command{
+	Use:   "fetch",
+	Short: "Fetching data from a REST API into CSV",
+	Long: `The fetch command requests the pages of a JSON API, extracts the items by a JSON path, flattens them and saves them as CSV.
+The result gets column types like any CSV file and can be filtered like with the parse command.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		start := time.Now()
+		// Offsets start from zero unless told otherwise
+		if fetchPaginate == string(api.OffsetPagination) && !cmd.Flags().Changed("page-start") {
+			fetchPageStart = 0
+		}
+		pagination, err := api.ParsePagination(api.Pagination{
+			Type:       api.PaginationType(fetchPaginate),
+			Param:      fetchPageParam,
+			SizeParam:  fetchSizeParam,
+			Size:       fetchPageSize,
+			Start:      fetchPageStart,
+			CursorPath: fetchCursorPath,
+			MaxPages:   fetchMaxPages,
+		})
+		if err != nil {
+			return fmt.Errorf("pagination: %w", err)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		header, err := parseHeaders(fetchHeaders)
+		if err != nil {
+			return err
+		}
+		client := &http.Client{}
+		auth, err := newAuthenticator(fetchAuth, client)
+		if err != nil {
+			return fmt.Errorf("authentication: %w", err)
+		}
+		fetcher := api.NewFetcher(client, api.FetcherConfig{
+			Header:     header,
+			Auth:       auth,
+			Pagination: pagination,
+			DataPath:   fetchDataPath,
+			RPS:        fetchHTTP.rps,
+			Burst:      fetchHTTP.burst,
+			Timeout:    fetchHTTP.timeout,
+			Retries:    fetchHTTP.retries,
+			Backoff:    fetchHTTP.backoff,
+			MaxBackoff: fetchHTTP.maxBackoff,
+		})
+
+		// Columns are known only after the last page, so the items are collected first
+		dir, err := os.MkdirTemp(fetchTempDir, "fetch-*")
+		if err != nil {
+			return fmt.Errorf("creating temporary directory: %w", err)
+		}
+		defer os.RemoveAll(dir)
+		table, err := csv.NewRecordTable(dir)
+		if err != nil {
+			return fmt.Errorf("creating temporary file: %w", err)
+		}
+		defer table.Close()
+
+		slog.Info("Fetching data", "url", fetchURL, "paginate", fetchPaginate)
+		pages, err := fetcher.Fetch(ctx, fetchURL, table.Add)
+		if err != nil {
+			return fmt.Errorf("fetching data: %w", err)
+		}
+		slog.Info("Data was fetched", "items", table.Rows(), "pages", pages)
+		recordOutputs(fetchOutput)
+		recordCount("pages", int64(pages))
+		recordCount("items", int64(table.Rows()))
+
+		if table.Rows() == 0 {
+			if err := os.WriteFile(fetchOutput, nil, 0o644); err != nil {
+				return fmt.Errorf("saving csv file: %w", err)
+			}
+			return nil
+		}
+		raw := filepath.Join(dir, "fetched.csv")
+		if err := table.Save(raw); err != nil {
+			return fmt.Errorf("saving fetched data: %w", err)
+		}
+
+		slog.Debug("Parsing structure of fetched data", "columns", len(table.Headers()))
+		scheme, err := csv.ParseCSVStructure(raw)
+		if err != nil {
+			return fmt.Errorf("parsing csv structure: %w", err)
+		}
+		parsedFilters, err := parseFilters(fetchFilters, scheme)
+		if err != nil {
+			return err
+		}
+
+		writer, err := csv.NewWriter(fetchOutput)
+		if err != nil {
+			return fmt.Errorf("creating output file: %w", err)
+		}
+		rows := 0
+		err = writer.Write(scheme.Headers)
+		if err == nil {
+			err = csv.ReadCSV(raw, scheme, parsedFilters, func(record []string) error {
+				rows++
+				return writer.Write(record)
+			})
+		}
+		if closeErr := writer.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("saving csv file: %w", err)
+		}
+		recordCount("rows_written", int64(rows))
+		slog.Info("Fetched data was saved",
+			"output", fetchOutput,
+			"rows_written", rows,
+			"rows_filtered", table.Rows()-rows,
+			"duration", time.Since(start),
+		)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(fetchCmd)
+	fetchCmd.Flags().StringVar(&fetchURL, "url", "", "address of the first page (required)")
+	fetchCmd.MarkFlagRequired("url")
+	fetchCmd.Flags().StringVarP(&fetchOutput, "output", "o", "", "output file address (required)")
+	fetchCmd.MarkFlagRequired("output")
+	fetchCmd.Flags().StringArrayVarP(&fetchHeaders, "header", "H", []string{}, `request header in the format "Name: value", can be repeated`)
+	addAuthFlags(fetchCmd, &fetchAuth)
+
+	fetchCmd.Flags().StringVar(&fetchDataPath, "data-path", "", `path of the array of items in a page like "data.items" (the page itself if empty)
+objects are flattened with nested members named like "address.city", arrays are kept as JSON`)
+	fetchCmd.Flags().StringSliceVarP(&fetchFilters, "filter", "f", []string{}, `set of filters in the format "column operation value"
+can be passed in by separating them with commas or by reusing the flag
+possible operations: =, !=, >, >=, <, <=`)
+
+	fetchCmd.Flags().StringVar(&fetchPaginate, "paginate", string(api.NoPagination), `pagination of the API:
+none
+page - page number in the page-param parameter, starting from page-start
+offset - number of skipped items in the page-param parameter
+cursor - value at cursor-path in the response is sent in the page-param parameter, or followed if it is a URL
+link - next URL in the Link header`)
+	fetchCmd.Flags().StringVar(&fetchPageParam, "page-param", "", "query parameter of the page number, offset or cursor (page, offset or cursor by default)")
+	fetchCmd.Flags().StringVar(&fetchSizeParam, "size-param", "", "query parameter of the page size, not sent if empty")
+	fetchCmd.Flags().IntVar(&fetchPageSize, "page-size", 0, "number of items per page, a shorter page is the last one")
+	fetchCmd.Flags().IntVar(&fetchPageStart, "page-start", 1, "number of the first page or the first offset (0 for offsets by default)")
+	fetchCmd.Flags().StringVar(&fetchCursorPath, "cursor-path", "", `path of the next cursor in the response like "meta.next_cursor"`)
+	fetchCmd.Flags().IntVar(&fetchMaxPages, "max-pages", 0, "maximum number of requested pages (0 for no limit)")
+
+	addHTTPFlags(fetchCmd, &fetchHTTP)
+
+	fetchCmd.Flags().StringVar(&fetchTempDir, "temp-dir", "", "directory for temporary files (system default if empty)")
+}
@@ -0,0 +1,249 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-data-tool/internal/history"
+	"log/slog"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	historyFile string // history file, the default location if empty
+	noHistory   bool   // don't record the run
+	// Selection of the listed runs
+	historyLimit    int
+	historyCommand  string
+	historyProduced string
+	historyFailed   bool
+)
+
+// run is the record of the current run, nil when it isn't recorded
+var run *history.Record
+
+// Commands with this annotation are not recorded
+const skipHistory = "skip-history"
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Listing past runs",
+	Long: `The history command lists the recorded runs of the other commands, the newest first:
+when they ran, their arguments, the checksums of the files they read and wrote, row counts, exit status and duration.`,
+	Args:        cobra.NoArgs,
+	Annotations: map[string]string{skipHistory: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := historyStore()
+		if err != nil {
+			return err
+		}
+		records, corrupt, err := store.Records()
+		if err != nil {
+			return fmt.Errorf("reading history: %w", err)
+		}
+		if corrupt != 0 {
+			slog.Warn("Skipped corrupt history lines", "history", store.Path, "lines", corrupt)
+		}
+
+		var produced history.File
+		if historyProduced != "" {
+			produced = history.Describe(historyProduced)
+		}
+		var selected []history.Record
+		for _, record := range slices.Backward(records) {
+			if historyLimit > 0 && len(selected) == historyLimit {
+				break
+			}
+			if historyCommand != "" && record.Command != historyCommand && !strings.HasPrefix(record.Command, historyCommand+" ") {
+				continue
+			}
+			if historyFailed && record.Status == 0 {
+				continue
+			}
+			if historyProduced != "" && !record.Produced(produced) {
+				continue
+			}
+			selected = append(selected, record)
+		}
+
+		out := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		fmt.Fprintln(out, "ID\tSTARTED\tCOMMAND\tSTATUS\tDURATION\tOUTPUTS")
+		for _, record := range selected {
+			var outputs []string
+			for _, output := range record.Outputs {
+				outputs = append(outputs, output.Path)
+			}
+			fmt.Fprintf(out, "%s\t%s\t%s\t%d\t%s\t%s\n",
+				record.ID,
+				record.Started.Local().Format(time.DateTime),
+				record.Command,
+				record.Status,
+				record.Duration.Round(time.Millisecond),
+				strings.Join(outputs, ", "),
+			)
+		}
+		return out.Flush()
+	},
+}
+
+var historyShowCmd = &cobra.Command{
+	Use:         "show <id>",
+	Short:       "Showing a past run",
+	Long:        `The show subcommand prints the whole record of a run as JSON, the ID can be shortened to a unique prefix.`,
+	Args:        cobra.ExactArgs(1),
+	Annotations: map[string]string{skipHistory: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := historyStore()
+		if err != nil {
+			return err
+		}
+		record, err := store.Find(args[0])
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(cmd.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(record)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.AddCommand(historyShowCmd)
+
+	historyCmd.Flags().IntVarP(&historyLimit, "limit", "n", 20, "maximum number of listed runs (0 for no limit)")
+	historyCmd.Flags().StringVar(&historyCommand, "command", "", `list only runs of the command, like "parse" or "backup prune"`)
+	historyCmd.Flags().StringVar(&historyProduced, "produced", "", "list only runs that wrote this file, matched by its checksum")
+	historyCmd.Flags().BoolVar(&historyFailed, "failed", false, "list only failed runs")
+}
+
+// historyStore is the store chosen by the flags
+func historyStore() (history.Store, error) {
+	if historyFile != "" {
+		return history.Store{Path: historyFile}, nil
+	}
+	path, err := history.DefaultPath()
+	if err != nil {
+		return history.Store{}, fmt.Errorf("locating history file: %w", err)
+	}
+	return history.Store{Path: path}, nil
+}
+
+// startRun starts recording the run of the command with the flags set on the command line
+func startRun(cmd *cobra.Command, args []string) {
+	if noHistory || cmd.Annotations[skipHistory] != "" {
+		return
+	}
+	// The command path without the name of the program
+	_, command, _ := strings.Cut(cmd.CommandPath(), " ")
+	run = history.NewRecord(command, time.Now())
+	run.Args = args
+	cmd.Flags().Visit(func(flag *pflag.Flag) {
+		if run.Flags == nil {
+			run.Flags = make(map[string][]string)
+		}
+		run.Flags[flag.Name] = flagValues(flag)
+	})
+}
+
+/*
+flagValues returns the values of a flag with the parts that can hold credentials left out:
+header values, user info and query values of addresses, and inline body templates.
+*/
+func flagValues(flag *pflag.Flag) []string {
+	values := []string{flag.Value.String()}
+	if slice, ok := flag.Value.(pflag.SliceValue); ok {
+		values = slice.GetSlice()
+	}
+	redacted := make([]string, len(values))
+	for i, value := range values {
+		switch flag.Name {
+		case "header":
+			name, _, _ := strings.Cut(value, ":")
+			redacted[i] = name + ": ***"
+		case "url", "token-url":
+			redacted[i] = redactURL(value)
+		case "body-template":
+			// Templates in files are recorded by their path
+			redacted[i] = value
+			if !strings.HasPrefix(value, "@") {
+				redacted[i] = "***"
+			}
+		default:
+			redacted[i] = value
+		}
+	}
+	return redacted
+}
+
+// redactURL hides the user info, the query values and the fragment of an address, which may also be a template
+func redactURL(address string) string {
+	address, _, hasFragment := strings.Cut(address, "#")
+	address, query, hasQuery := strings.Cut(address, "?")
+	if scheme, rest, ok := strings.Cut(address, "://"); ok {
+		authority, path := rest, ""
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			authority, path = rest[:i], rest[i:]
+		}
+		if i := strings.LastIndexByte(authority, '@'); i >= 0 {
+			authority = "***" + authority[i:]
+		}
+		address = scheme + "://" + authority + path
+	}
+	if hasQuery {
+		params := strings.Split(query, "&")
+		for i, param := range params {
+			if name, _, ok := strings.Cut(param, "="); ok {
+				params[i] = name + "=***"
+			} else if param != "" {
+				params[i] = "***"
+			}
+		}
+		address += "?" + strings.Join(params, "&")
+	}
+	if hasFragment {
+		address += "#***"
+	}
+	return address
+}
+
+// finishRun saves the record of the run, failing to save it doesn't fail the run
+func finishRun(status int, err error) {
+	if run == nil {
+		return
+	}
+	run.Finish(status, err, time.Now())
+	store, storeErr := historyStore()
+	if storeErr == nil {
+		storeErr = store.Append(*run)
+	}
+	if storeErr != nil {
+		slog.Warn("Run was not recorded", "error", storeErr)
+	}
+}
+
+// recordInputs adds files read by the run to its record
+func recordInputs(paths ...string) {
+	if run != nil {
+		run.AddInputs(paths...)
+	}
+}
+
+// recordOutputs adds files written by the run to its record
+func recordOutputs(paths ...string) {
+	if run != nil {
+		run.AddOutputs(paths...)
+	}
+}
+
+// recordCount sets a counter of the run, like the number of written rows
+func recordCount(name string, n int64) {
+	if run != nil {
+		run.Count(name, n)
+	}
+}
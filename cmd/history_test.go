@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"go-data-tool/internal/history"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRedactURL(t *testing.T) {
+	tests := []struct {
+		url, want string
+	}{
+		{"https://example.com/users", "https://example.com/users"},
+		{"https://u:p@example.com/?key=s", "https://***@example.com/?key=***"},
+		{"https://token@example.com:8443/a/b?x=1&api_key=s&flag", "https://***@example.com:8443/a/b?x=***&api_key=***&***"},
+		{"https://example.com/users/{{path .id}}?token={{.token}}", "https://example.com/users/{{path .id}}?token=***"},
+		{"https://example.com/cb#access_token=s", "https://example.com/cb#***"},
+		// An @ in the path isn't user info
+		{"https://example.com/users/@me", "https://example.com/users/@me"},
+	}
+	for _, test := range tests {
+		if got := redactURL(test.url); got != test.want {
+			t.Errorf("redactURL(%q) = %q, want %q", test.url, got, test.want)
+		}
+	}
+}
+
+func TestHistoryRedactsCredentials(t *testing.T) {
+	server := httptest.NewServer(&rowServer{})
+	defer server.Close()
+	input := writeFile(t, "users.csv", sendInput)
+	historyPath := filepath.Join(t.TempDir(), "history.jsonl")
+
+	// The run is recorded like by Execute
+	resetFlags()
+	address := strings.Replace(server.URL, "://", "://user:pa55w0rd@", 1) + "/?key=s3cr3t"
+	rootCmd.SetArgs([]string{"send", "-i", input, "--url", address, "-H", "X-Token: t0ken",
+		"--body-template", `{"id":{{.id}},"password":"hunter2"}`, "--history-file", historyPath, "--log-level", "error"})
+	err := rootCmd.Execute()
+	finishRun(0, err)
+	run = nil
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, _, err := history.Store{Path: historyPath}.Records()
+	if err != nil || len(records) != 1 {
+		t.Fatalf("records %v, %v", records, err)
+	}
+	flags := records[0].Flags
+	for _, secret := range []string{"pa55w0rd", "s3cr3t", "t0ken", "hunter2"} {
+		for name, values := range flags {
+			if strings.Contains(strings.Join(values, " "), secret) {
+				t.Errorf("flag %s = %q holds %s", name, values, secret)
+			}
+		}
+	}
+	if want := []string{strings.Replace(server.URL, "://", "://***@", 1) + "/?key=***"}; !slices.Equal(flags["url"], want) {
+		t.Errorf("url %q, want %q", flags["url"], want)
+	}
+}
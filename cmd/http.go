@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// httpFlags are the rate limit, timeout and retry settings of a command making HTTP requests
+type httpFlags struct {
+	rps        float64
+	burst      int
+	timeout    time.Duration
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// addHTTPFlags registers the rate limit, timeout and retry flags of a command, each command keeps its own values
+func addHTTPFlags(cmd *cobra.Command, flags *httpFlags) {
+	cmd.Flags().Float64Var(&flags.rps, "rps", 0, "maximum number of requests per second (0 for no limit)")
+	cmd.Flags().IntVar(&flags.burst, "burst", 1, "number of requests allowed at once above the rps limit")
+	cmd.Flags().DurationVar(&flags.timeout, "timeout", 30*time.Second, "timeout of a single request (0 for no timeout)")
+	cmd.Flags().IntVar(&flags.retries, "retries", 3, "number of retries of requests failed with 429, 5xx or a network error")
+	cmd.Flags().DurationVar(&flags.backoff, "backoff", 500*time.Millisecond, `delay before the first retry, doubled with every retry and randomized
+the Retry-After header of the response takes precedence`)
+	cmd.Flags().DurationVar(&flags.maxBackoff, "max-backoff", 30*time.Second, "maximum delay between retries")
+}
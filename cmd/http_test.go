@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+// Commands sharing flag names must not share the variables behind them
+func TestFlagsAreOwnedByCommand(t *testing.T) {
+	tests := []struct {
+		name, value string
+		commands    []*cobra.Command
+	}{
+		{"retries", "7", []*cobra.Command{sendCmd, fetchCmd}},
+		{"rps", "7", []*cobra.Command{sendCmd, fetchCmd}},
+		{"max-backoff", "7s", []*cobra.Command{sendCmd, fetchCmd}},
+		{"auth", "bearer", []*cobra.Command{sendCmd, fetchCmd}},
+		{"auth-secret", "env:SECRET", []*cobra.Command{sendCmd, fetchCmd}},
+		{"auth-user", "client", []*cobra.Command{sendCmd, fetchCmd}},
+		{"auth-header", "X-Token", []*cobra.Command{sendCmd, fetchCmd}},
+		{"token-url", "https://example.com/token", []*cobra.Command{sendCmd, fetchCmd}},
+		{"scope", "read", []*cobra.Command{sendCmd, fetchCmd}},
+		{"sort-buffer", "7", []*cobra.Command{parseCmd, joinCmd, diffCmd}},
+		{"temp-dir", "/tmp/7", []*cobra.Command{parseCmd, joinCmd, diffCmd, fetchCmd}},
+	}
+	defer resetFlags()
+	for _, test := range tests {
+		for _, changed := range test.commands {
+			resetFlags()
+			if err := changed.Flags().Set(test.name, test.value); err != nil {
+				t.Fatal(err)
+			}
+			for _, other := range test.commands {
+				flag := other.Flags().Lookup(test.name)
+				if other != changed && flag.Value.String() != flag.DefValue {
+					t.Errorf("setting --%s of %s changed it for %s to %s", test.name, changed.Name(), other.Name(), flag.Value)
+				}
+			}
+		}
+	}
+}
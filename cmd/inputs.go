@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+/*
+expandInputs turns the input arguments into a list of files.
+An argument can be a file, a directory (all its .csv files) or a glob pattern like "data/2025-*.csv".
+Files are listed in lexical order within every argument, repeated files are read once.
+*/
+func expandInputs(args []string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		switch {
+		case err == nil && info.IsDir():
+			matches, err := filepath.Glob(filepath.Join(arg, "*.csv"))
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no CSV files in directory '%s'", arg)
+			}
+			slices.Sort(matches)
+			for _, match := range matches {
+				add(match)
+			}
+		case err == nil:
+			add(arg)
+		default:
+			matches, globErr := filepath.Glob(arg)
+			if globErr != nil {
+				return nil, fmt.Errorf("invalid pattern '%s': %w", arg, globErr)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("input file '%s' not found", arg)
+			}
+			slices.Sort(matches)
+			for _, match := range matches {
+				add(match)
+			}
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"go-data-tool/internal/csv"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	joinLeft        string   // left input file
+	joinRight       string   // right input file
+	joinOutput      string   // output file
+	joinOn          []string // slice of key columns
+	joinType        string   // inner, left, right, full, semi or anti
+	joinLeftPrefix  string   // prefix of conflicting left columns
+	joinRightPrefix string   // prefix of conflicting right columns
+	// Maximum size of the smaller file in megabytes for an in-memory hash join
+	joinMemory int64
+	// Sorting on disk
+	joinSortBuffer int
+	joinTempDir    string
+)
+
+var joinCmd = &cobra.Command{
+	Use:   "join",
+	Short: "Joining two CSV files",
+	Long: `The join command combines the rows of two CSV files with equal key columns.
+A hash join is used when the smaller file fits into the memory limit, otherwise both files are sorted on disk and merged.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		start := time.Now()
+		// Check existance of files
+		for _, path := range []string{joinLeft, joinRight} {
+			if _, err := os.Stat(path); err != nil && errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("input file '%s' not found", path)
+			}
+		}
+
+		recordInputs(joinLeft, joinRight)
+		slog.Info("Parsing files structure", "left", joinLeft, "right", joinRight)
+		leftScheme, err := csv.ParseCSVStructure(joinLeft)
+		if err != nil {
+			return fmt.Errorf("parsing left csv structure: %w", err)
+		}
+		rightScheme, err := csv.ParseCSVStructure(joinRight)
+		if err != nil {
+			return fmt.Errorf("parsing right csv structure: %w", err)
+		}
+
+		slog.Debug("Parsing join keys", "on", joinOn, "type", joinType)
+		join, err := csv.ParseJoin(joinOn, joinType, leftScheme, rightScheme, joinLeftPrefix, joinRightPrefix)
+		if err != nil {
+			return fmt.Errorf("parsing join: %w", err)
+		}
+
+		writer, err := csv.NewWriter(joinOutput)
+		if err != nil {
+			return fmt.Errorf("creating output file: %w", err)
+		}
+		recordOutputs(joinOutput)
+		defer writer.Close()
+		if err := writer.Write(join.Scheme().Headers); err != nil {
+			return fmt.Errorf("saving csv file: %w", err)
+		}
+		written := 0
+		emit := func(record []string) error {
+			written++
+			return writer.Write(record)
+		}
+
+		fits, err := csv.HashJoinFits(joinLeft, joinRight, joinMemory*1024*1024)
+		if err != nil {
+			return fmt.Errorf("joining files: %w", err)
+		}
+		if fits {
+			slog.Info("Joining files in memory")
+			err = csv.HashJoin(join, joinLeft, leftScheme, joinRight, rightScheme, emit)
+		} else {
+			slog.Info("Joining files on disk")
+			err = csv.SortMergeJoin(join, joinLeft, leftScheme, joinRight, rightScheme, joinSortBuffer, joinTempDir, emit)
+		}
+		if err != nil {
+			return fmt.Errorf("joining files: %w", err)
+		}
+
+		slog.Debug("Saving joined data", "output", joinOutput)
+		if err := writer.Close(); err != nil {
+			return fmt.Errorf("saving csv file: %w", err)
+		}
+
+		recordCount("rows_written", int64(written))
+		slog.Info("CSV files were joined", "output", joinOutput, "rows_written", written, "duration", time.Since(start))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(joinCmd)
+	joinCmd.Flags().StringVar(&joinLeft, "left", "", "left file address (required)")
+	joinCmd.MarkFlagRequired("left")
+	joinCmd.Flags().StringVar(&joinRight, "right", "", "right file address (required)")
+	joinCmd.MarkFlagRequired("right")
+
+	joinCmd.Flags().StringVarP(&joinOutput, "output", "o", "", "output file address (required)")
+	joinCmd.MarkFlagRequired("output")
+
+	joinCmd.Flags().StringSliceVar(&joinOn, "on", []string{}, `set of key columns (required)
+a column present in both files or a pair "left_column=right_column"`)
+	joinCmd.MarkFlagRequired("on")
+
+	joinCmd.Flags().StringVarP(&joinType, "type", "t", string(csv.JoinInner), "join type: inner, left, right, full, semi or anti")
+	joinCmd.Flags().StringVar(&joinLeftPrefix, "left-prefix", "left_", "prefix of left columns whose names occur in both files")
+	joinCmd.Flags().StringVar(&joinRightPrefix, "right-prefix", "right_", "prefix of right columns whose names occur in both files")
+
+	joinCmd.Flags().Int64Var(&joinMemory, "memory", 256, "maximum size of the smaller file in megabytes for an in-memory hash join")
+	joinCmd.Flags().IntVar(&joinSortBuffer, "sort-buffer", 100000, "maximum number of rows sorted in memory when joining on disk")
+	joinCmd.Flags().StringVar(&joinTempDir, "temp-dir", "", "directory for temporary files (system default if empty)")
+}
@@ -2,15 +2,22 @@ package cmd
 
 import (
 	"errors"
+	"fmt"
 	"go-data-tool/internal/csv"
-	"log"
+	"go-data-tool/internal/metrics"
+	"go-data-tool/internal/progress"
+	"log/slog"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	input   string   // input file
+	inputs  []string // input files, directories or glob patterns
 	output  string   // output file
 	filters []string // slice of installed filters
 	sum     []string // slice of columns for sum aggregation
@@ -20,129 +27,423 @@ var (
 	count   []string // slice of columns for count aggregation
 	countd  []string // slice of columns for cound distinct aggregation
 	group   []string // slice of columns for grouping
+	sortBy  []string // slice of ordering specifications
+	// Maximum number of rows sorted in memory before spilling to disk
+	sortBuffer int
+	limit      int      // maximum number of output rows
+	offset     int      // number of output rows to skip
+	top        int      // number of rows kept per group
+	topBy      string   // ranking of the top rows
+	topPer     []string // slice of columns forming top groups
+	distinct   bool     // drop duplicate rows
+	dedupeBy   []string // slice of columns identifying duplicate rows
+	keep       string   // which of the duplicate rows is kept
+	// Maximum number of unique rows kept in memory during deduplication
+	dedupeBuffer int
+	// Directory for temporary files
+	tempDir string
+	// Add the column with the address of the file each row comes from
+	sourceColumn bool
+	// Summary of the run
+	statsJSON     string
+	metricsFile   string
+	metricsLabels map[string]string
+	// Don't show the progress on a terminal
+	quiet bool
+	// Handling of bad rows
+	onError    string // fail, skip or reject
+	rejectFile string // file for rejected rows
+	maxErrors  int    // maximum number of skipped or rejected rows
+	// Inference of the column types
+	inferRows      int     // rows sampled from each file
+	inferTolerance float64 // tolerated share of outliers
+	inferOutliers  string  // null or reject
+	// Numbers written by the conventions of a locale
+	locale        string            // locale of all columns
+	numberFormats map[string]string // locales of single columns
+	numberOutput  string            // normalize or keep
 )
 
 var parseCmd = &cobra.Command{
 	Use:   "parse",
 	Short: "Processing CSV data",
 	Long:  `The parse command allows you to read CSV file data, perform filtering, column selection, grouping, and aggregation.`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
+		start := time.Now()
 		var parsedFilters []csv.Filter
 		var parsedAggregations []csv.Aggregator
 		var parsedGroups []string
 
-		// Check if input flag is not empty and check existance of file
-		if input != "" {
-			if _, err := os.Stat(input); err != nil && errors.Is(err, os.ErrNotExist) {
-				log.Fatal("Input file not found")
-			}
+		// Expand directories and patterns and check existance of files
+		inputFiles, err := expandInputs(inputs)
+		if err != nil {
+			return err
 		}
+		recordInputs(inputFiles...)
 		// TODO: Add the ability to parse data passed through the pipeline
+		if err := checkMetricsLabels(metricsLabels); err != nil {
+			return err
+		}
+		if err := checkErrorMode(onError, rejectFile, maxErrors); err != nil {
+			return err
+		}
+		if inferOutliers != "null" && inferOutliers != "reject" {
+			return fmt.Errorf("unknown outlier handling '%s', expected null or reject", inferOutliers)
+		}
+		if numberOutput != "normalize" && numberOutput != "keep" {
+			return fmt.Errorf("unknown number output '%s', expected normalize or keep", numberOutput)
+		}
+		inferOptions, err := parseNumberFormats(locale, numberFormats)
+		if err != nil {
+			return err
+		}
+		inferOptions.Rows, inferOptions.Tolerance = inferRows, inferTolerance
+		// Phases are timed only for the summary, timing every write has a cost
+		timed := statsJSON != "" || metricsFile != ""
+		summary := parseStats{Started: start, Inputs: inputFiles, Output: output, Filters: []filterStats{}}
 
 		// Reading the CSV file structure
-		log.Println("Parsing file structure...")
-		scheme, err := csv.ParseCSVStructure(input)
+		slog.Info("Parsing structure", "input", inputFiles)
+		schemaStart := time.Now()
+		scheme, err := csv.ParseCSVStructureWith(inputFiles, inferOptions)
 		if err != nil {
-			log.Fatal("Error parsing csv structure: ", err)
+			return fmt.Errorf("parsing csv structure: %w", err)
+		}
+		summary.Phases.Schema = time.Since(schemaStart).Seconds()
+		slog.Debug("Structure parsed", "columns", len(scheme.Headers))
+		if sourceColumn {
+			scheme, err = csv.WithSourceColumn(scheme)
+			if err != nil {
+				return fmt.Errorf("adding source column: %w", err)
+			}
 		}
 
 		// Process filters
-		if len(filters) != 0 {
-			log.Println("Parsing filters...")
-
-			for _, filter := range filters {
-				parsedFilter, err := csv.ParseFilter(filter, scheme)
-				if err != nil {
-					log.Fatalf("Filter '%s' parsing error: %s", filter, err)
-				}
-				parsedFilters = append(parsedFilters, parsedFilter)
-			}
+		parsedFilters, err = parseFilters(filters, scheme)
+		if err != nil {
+			return err
 		}
 
 		// Process aggregations
 		if len(sum) != 0 || len(avg) != 0 || len(max) != 0 || len(min) != 0 || len(count) != 0 || len(countd) != 0 {
-			log.Println("Pasing aggregations...")
+			slog.Debug("Parsing aggregations")
 			for _, column := range sum {
 				parsedAggregation, err := csv.ParseAggregation(column, csv.AggSum, scheme)
 				if err != nil {
-					log.Fatalf("Aggregation sum('%s') parsing error: %s", column, err)
+					return fmt.Errorf("parsing aggregation sum('%s'): %w", column, err)
 				}
 				parsedAggregations = append(parsedAggregations, parsedAggregation)
 			}
 			for _, column := range avg {
 				parsedAggregation, err := csv.ParseAggregation(column, csv.AggAvg, scheme)
 				if err != nil {
-					log.Fatalf("Aggregation avg('%s') parsing error: %s", column, err)
+					return fmt.Errorf("parsing aggregation avg('%s'): %w", column, err)
 				}
 				parsedAggregations = append(parsedAggregations, parsedAggregation)
 			}
 			for _, column := range max {
 				parsedAggregation, err := csv.ParseAggregation(column, csv.AggMax, scheme)
 				if err != nil {
-					log.Fatalf("Aggregation max('%s') parsing error: %s", column, err)
+					return fmt.Errorf("parsing aggregation max('%s'): %w", column, err)
 				}
 				parsedAggregations = append(parsedAggregations, parsedAggregation)
 			}
 			for _, column := range min {
 				parsedAggregation, err := csv.ParseAggregation(column, csv.AggMin, scheme)
 				if err != nil {
-					log.Fatalf("Aggregation min('%s') parsing error: %s", column, err)
+					return fmt.Errorf("parsing aggregation min('%s'): %w", column, err)
 				}
 				parsedAggregations = append(parsedAggregations, parsedAggregation)
 			}
 			for _, column := range count {
 				parsedAggregation, err := csv.ParseAggregation(column, csv.AggCount, scheme)
 				if err != nil {
-					log.Fatalf("Aggregation count('%s') parsing error: %s", column, err)
+					return fmt.Errorf("parsing aggregation count('%s'): %w", column, err)
 				}
 				parsedAggregations = append(parsedAggregations, parsedAggregation)
 			}
 			for _, column := range countd {
 				parsedAggregation, err := csv.ParseAggregation(column, csv.AggCountDistinct, scheme)
 				if err != nil {
-					log.Fatalf("Aggregation countd('%s') parsing error: %s", column, err)
+					return fmt.Errorf("parsing aggregation countd('%s'): %w", column, err)
 				}
 				parsedAggregations = append(parsedAggregations, parsedAggregation)
 			}
 		}
 
 		if len(group) != 0 {
-			log.Println("Processing group attributes...")
+			slog.Debug("Parsing group attributes")
 			for _, column := range group {
 				parsedGroup, err := csv.ParseGroup(column, scheme)
 				if err != nil {
-					log.Fatalf("Grouping '%s' parsing error: %s", column, err)
+					return fmt.Errorf("parsing grouping '%s': %w", column, err)
 				}
 				parsedGroups = append(parsedGroups, parsedGroup)
 			}
 		}
 
-		log.Println("Parsing file...")
-		records, err := csv.ParseCSV(input, scheme, parsedFilters, parsedAggregations, parsedGroups)
-		if err != nil {
-			log.Fatal("Error parsing csv file: ", err)
+		resultScheme := csv.ResultScheme(scheme, parsedAggregations, parsedGroups)
+
+		var parsedSort []csv.SortKey
+		if len(sortBy) != 0 {
+			slog.Debug("Parsing sort order")
+			for _, spec := range sortBy {
+				keys, err := csv.ParseSort(spec, resultScheme)
+				if err != nil {
+					return fmt.Errorf("parsing sort '%s': %w", spec, err)
+				}
+				parsedSort = append(parsedSort, keys...)
+			}
+		}
+
+		if limit < 0 || offset < 0 || top < 0 {
+			return errors.New("limit, offset and top must not be negative")
+		}
+
+		var parsedTop []csv.SortKey
+		var parsedPer []string
+		if top != 0 {
+			slog.Debug("Parsing top selection")
+			if topBy == "" {
+				return errors.New("top selection requires the 'by' flag")
+			}
+			parsedTop, err = csv.ParseTopOrder(topBy, resultScheme)
+			if err != nil {
+				return fmt.Errorf("parsing top ranking '%s': %w", topBy, err)
+			}
+			for _, column := range topPer {
+				parsedColumn, err := csv.ParseGroup(column, resultScheme)
+				if err != nil {
+					return fmt.Errorf("parsing top grouping '%s': %w", column, err)
+				}
+				parsedPer = append(parsedPer, parsedColumn)
+			}
 		}
-		// TODO: Add the ability to parse data passed through the pipeline
 
-		log.Println("Saving proccessed data...")
-		err = csv.SaveCSV(records, output)
+		var parsedDedupe *csv.Deduplication
+		// Whole rows or key columns are compared, not both
+		if distinct && len(dedupeBy) != 0 {
+			return errors.New("'distinct' and 'dedupe-by' flags can't be used together, distinct compares whole rows")
+		}
+		if distinct || len(dedupeBy) != 0 {
+			slog.Debug("Parsing deduplication")
+			dedupe, err := csv.ParseDeduplication(dedupeBy, keep, scheme)
+			if err != nil {
+				return fmt.Errorf("parsing deduplication: %w", err)
+			}
+			parsedDedupe = &dedupe
+		}
+
+		writer, err := csv.NewWriter(output)
 		if err != nil {
-			log.Fatal("Error saving csv file", err)
+			return fmt.Errorf("creating output file: %w", err)
+		}
+		recordOutputs(output)
+		defer writer.Close()
+		if err := writer.Write(resultScheme.Headers); err != nil {
+			return fmt.Errorf("saving csv file: %w", err)
 		}
 		// TODO: Add the ability to pass data through the pipeline
 
-		log.Println("CSV data was processed correctly")
+		// Stages that buffer records, in the order the records pass them
+		var stages []csv.Stage
+		var deduper *csv.Deduper
+		if parsedDedupe != nil {
+			deduper = csv.NewDeduper(*parsedDedupe, dedupeBuffer, tempDir)
+			defer deduper.Close()
+			stages = append(stages, deduper)
+		}
+		var grouper *csv.Grouper
+		if len(parsedAggregations) != 0 || len(parsedGroups) != 0 {
+			grouper = csv.NewGrouper(scheme, parsedAggregations, parsedGroups)
+			stages = append(stages, grouper)
+		}
+		if top != 0 {
+			stages = append(stages, csv.NewTopN(top, parsedTop, parsedPer, resultScheme))
+		}
+		if len(parsedSort) != 0 {
+			if limit != 0 {
+				// Only the first offset+limit rows are needed, so they are kept in a bounded heap
+				stages = append(stages, csv.NewTopN(offset+limit, parsedSort, nil, resultScheme))
+			} else {
+				sorter := csv.NewSorter(parsedSort, sortBuffer, tempDir)
+				defer sorter.Close()
+				stages = append(stages, sorter)
+			}
+		}
+
+		// Limits are applied last; without buffering stages they stop reading the file early
+		written := 0
+		var writeTime time.Duration
+		write := writer.Write
+		if numberOutput == "keep" {
+			write = func(record []string) error {
+				return writer.Write(csv.FormatNumbers(record, resultScheme))
+			}
+		}
+		sink := func(record []string) error {
+			written++
+			if !timed {
+				return write(record)
+			}
+			writeStart := time.Now()
+			err := write(record)
+			writeTime += time.Since(writeStart)
+			return err
+		}
+		if limit != 0 || offset != 0 {
+			sink = csv.NewLimiter(offset, limit, sink).Add
+		}
+
+		head := sink
+		if len(stages) != 0 {
+			head = stages[0].Add
+		}
+
+		slog.Info("Parsing files", "files", len(inputFiles), "filters", len(parsedFilters))
+		// Progress is shown only to a user watching the terminal
+		readOptions := csv.ReadOptions{NullMismatches: inferOutliers == "null"}
+		// Only the values the run compares or computes with must match their types, outliers read as nulls are looked for everywhere
+		if !readOptions.NullMismatches {
+			readOptions.Checked = usedColumns(scheme, parsedAggregations, slices.Concat(parsedSort, parsedTop), parsedDedupe)
+		}
+		var reporter *progress.Reporter
+		if !quiet && stderrIsTerminal() {
+			reporter = progress.NewReporter(stderr, inputSize(inputFiles), 200*time.Millisecond)
+			readOptions.Progress = reporter.Update
+		}
+		var rejects *csv.Writer
+		if onError != "fail" {
+			readOptions.MaxErrors = maxErrors
+			readOptions.OnError = func(rowErr *csv.RowError) error {
+				slog.Debug("Bad row left out", "file", rowErr.File, "line", rowErr.Line, "column", rowErr.Column, "error", rowErr.Err)
+				if rejects == nil {
+					return nil
+				}
+				return rejects.Write(rejectRecord(rowErr))
+			}
+		}
+		if onError == "reject" {
+			rejects, err = csv.NewWriter(rejectFile)
+			if err != nil {
+				return fmt.Errorf("creating reject file: %w", err)
+			}
+			defer rejects.Close()
+			recordOutputs(rejectFile)
+			if err := rejects.Write(append([]string{"_file", "_line", "_column", "_error"}, scheme.Headers...)); err != nil {
+				return fmt.Errorf("writing reject file: %w", err)
+			}
+		}
+
+		readStart := time.Now()
+		stats, err := csv.ReadCSVFilesWith(inputFiles, scheme, parsedFilters, head, readOptions)
+		if reporter != nil {
+			reporter.Finish()
+		}
+		if err != nil {
+			return fmt.Errorf("parsing csv file: %w", err)
+		}
+		if rejects != nil {
+			if err := rejects.Close(); err != nil {
+				return fmt.Errorf("writing reject file: %w", err)
+			}
+		}
+		if stats.Rejected != 0 {
+			slog.Warn("Bad rows left out", "rows", stats.Rejected, "on_error", onError)
+		}
+		if stats.Nulled != 0 {
+			slog.Warn("Outliers read as nulls", "values", stats.Nulled)
+		}
+		summary.Phases.Parse = (time.Since(readStart) - writeTime).Seconds()
+		readWriteTime := writeTime
+		slog.Debug("Files parsed", "rows_read", stats.Read, "rows_filtered", stats.Filtered)
+		// TODO: Add the ability to parse data passed through the pipeline
+
+		stagesStart := time.Now()
+		if len(stages) != 0 {
+			slog.Debug("Processing data", "stages", len(stages))
+			for i, stage := range stages {
+				next := sink
+				if i+1 < len(stages) {
+					next = stages[i+1].Add
+				}
+				if err := stage.Each(next); err != nil {
+					return fmt.Errorf("processing data: %w", err)
+				}
+			}
+		}
+
+		summary.Phases.Aggregation = (time.Since(stagesStart) - (writeTime - readWriteTime)).Seconds()
+
+		if deduper != nil {
+			summary.RowsDuplicate = deduper.Dropped()
+			slog.Info("Duplicates dropped", "rows", deduper.Dropped())
+			recordCount("rows_duplicate", int64(deduper.Dropped()))
+		}
+
+		slog.Debug("Saving processed data", "output", output)
+		closeStart := time.Now()
+		if err := writer.Close(); err != nil {
+			return fmt.Errorf("saving csv file: %w", err)
+		}
+		summary.Phases.Write = (writeTime + time.Since(closeStart)).Seconds()
+
+		recordCount("rows_read", int64(stats.Read))
+		recordCount("rows_filtered", int64(stats.Filtered))
+		recordCount("rows_rejected", int64(stats.Rejected))
+		recordCount("values_nulled", int64(stats.Nulled))
+		recordCount("rows_written", int64(written))
+		slog.Info("CSV data was processed",
+			"input", inputFiles,
+			"output", output,
+			"rows_read", stats.Read,
+			"rows_filtered", stats.Filtered,
+			"rows_rejected", stats.Rejected,
+			"rows_written", written,
+			"duration", time.Since(start),
+		)
+
+		if !timed {
+			return nil
+		}
+		summary.DurationSeconds = time.Since(start).Seconds()
+		summary.RowsRead, summary.RowsFiltered, summary.RowsRejected, summary.RowsWritten = stats.Read, stats.Filtered, stats.Rejected, written
+		summary.ValuesNulled = stats.Nulled
+		for i, passed := range stats.Passed {
+			summary.Filters = append(summary.Filters, filterStats{Filter: filters[i], Passed: passed})
+		}
+		if grouper != nil {
+			summary.Groups = grouper.Groups()
+		}
+		summary.BytesIn = inputSize(inputFiles)
+		if info, err := os.Stat(output); err == nil {
+			summary.BytesOut = info.Size()
+		}
+		if statsJSON != "" {
+			if err := writeStatsJSON(statsJSON, summary); err != nil {
+				return fmt.Errorf("writing stats: %w", err)
+			}
+		}
+		if metricsFile != "" {
+			if err := metrics.WriteTextfile(metricsFile, summary.metrics(metricsLabels)); err != nil {
+				return fmt.Errorf("writing metrics: %w", err)
+			}
+		}
+		return nil
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(parseCmd)
-	parseCmd.Flags().StringVarP(&input, "input", "i", "", "file address for processing (required)")
+	parseCmd.Flags().StringArrayVarP(&inputs, "input", "i", []string{}, `file address, directory or glob pattern for processing (required)
+can be repeated, all files are processed as a single table with columns matched by name`)
 	parseCmd.MarkFlagRequired("input")
 
 	parseCmd.Flags().StringVarP(&output, "output", "o", "", "output file address (required)")
 	parseCmd.MarkFlagRequired("output")
 
+	parseCmd.Flags().BoolVar(&sourceColumn, "source-column", false, "add the '"+csv.SourceColumn+"' column with the address of the file each row comes from")
+
 	parseCmd.Flags().StringSliceVarP(&filters, "filter", "f", []string{}, `set of filters in the format "column operation value"
 can be passed in by separating them with commas or by reusing the flag
 possible operations: =, !=, >, >=, <, <=`)
@@ -155,4 +456,142 @@ possible operations: =, !=, >, >=, <, <=`)
 	parseCmd.Flags().StringSliceVarP(&countd, "countd", "C", []string{}, "set of columns for 'count distinct' aggregation")
 
 	parseCmd.Flags().StringSliceVarP(&group, "group", "g", []string{}, "set of columns for grouping")
+
+	parseCmd.Flags().StringArrayVar(&sortBy, "sort", []string{}, `ordering of the output in the format "column [asc|desc], column [asc|desc]"
+columns of the result are compared according to their types, equal rows keep their order`)
+	parseCmd.Flags().IntVar(&sortBuffer, "sort-buffer", 100000, "maximum number of rows sorted in memory, larger outputs are sorted on disk")
+	parseCmd.Flags().IntVar(&limit, "limit", 0, "maximum number of output rows (0 for no limit)")
+	parseCmd.Flags().IntVar(&offset, "offset", 0, "number of output rows to skip")
+
+	parseCmd.Flags().IntVar(&top, "top", 0, "number of best ranked rows kept for every group of the 'per' columns")
+	parseCmd.Flags().StringVar(&topBy, "by", "", `ranking of the top rows in the format "column [asc|desc], ..."
+descending by default, so that the greatest values are kept`)
+	parseCmd.Flags().StringSliceVar(&topPer, "per", []string{}, "set of columns forming groups for the top rows")
+
+	parseCmd.Flags().BoolVar(&distinct, "distinct", false, "drop rows that are exact duplicates of previous rows")
+	parseCmd.Flags().StringSliceVar(&dedupeBy, "dedupe-by", []string{}, "set of columns identifying duplicate rows")
+	parseCmd.Flags().StringVar(&keep, "keep", string(csv.KeepFirst), "which of the duplicate rows is kept: first, last, max(column) or min(column)")
+	parseCmd.Flags().IntVar(&dedupeBuffer, "dedupe-buffer", 1000000, "maximum number of unique rows kept in memory, more rows are deduplicated on disk")
+
+	parseCmd.Flags().StringVar(&tempDir, "temp-dir", "", "directory for temporary files (system default if empty)")
+
+	parseCmd.Flags().StringVar(&onError, "on-error", "fail", `handling of rows that can't be read or have values not matching the types of their columns:
+fail - stop with an error
+skip - leave the rows out
+reject - leave the rows out and write them to the reject-file`)
+	parseCmd.Flags().StringVar(&rejectFile, "reject-file", "", "file address for rejected rows with their file, line, column and error")
+	parseCmd.Flags().IntVar(&maxErrors, "max-errors", 0, "stop with an error when more rows are skipped or rejected (0 for no limit)")
+
+	parseCmd.Flags().IntVar(&inferRows, "infer-rows", 0, "number of data rows of each file sampled to infer the column types (0 for all rows)")
+	parseCmd.Flags().Float64Var(&inferTolerance, "infer-tolerance", 0, `share of the sampled values of a column, from 0 to 1, that may be outliers not matching a numeric type
+with the column still inferred as numeric`)
+	parseCmd.Flags().StringVar(&inferOutliers, "infer-outliers", "reject", `handling of values not matching the inferred type of their column:
+reject - the rows are bad rows handled by on-error
+null - the values are read as nulls`)
+
+	parseCmd.Flags().StringVar(&locale, "locale", "", `locale of the numbers of all columns, like "1.234,56 €" in de or "1 234,56" in ru:
+`+strings.Join(csv.NumberLocales(), ", ")+`; plain numbers if empty`)
+	parseCmd.Flags().StringToStringVar(&numberFormats, "number-format", map[string]string{}, `locales of the numbers of single columns in the format "column=locale,column=locale"`)
+	parseCmd.Flags().StringVar(&numberOutput, "number-output", "normalize", `writing of the numbers read in a locale:
+normalize - as plain numbers like "1234.56"
+keep - in the locale with their currency or percent sign`)
+
+	parseCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "don't show the progress of reading, which is shown only when standard error is a terminal")
+
+	parseCmd.Flags().StringVar(&statsJSON, "stats-json", "", `file address for a JSON summary of the run:
+row counts, rows passing every filter, groups, bytes in and out and durations of the phases`)
+	parseCmd.Flags().StringVar(&metricsFile, "metrics-file", "", "file address for the summary as metrics in the Prometheus text format, e.g. for the node-exporter textfile collector")
+	parseCmd.Flags().StringToStringVar(&metricsLabels, "metrics-labels", map[string]string{}, `labels added to all metrics in the format "name=value,name=value"`)
+}
+
+// parseNumberFormats looks up the locales of the locale and number-format flags
+func parseNumberFormats(locale string, columns map[string]string) (csv.InferOptions, error) {
+	options := csv.InferOptions{Formats: make(map[string]*csv.NumberFormat)}
+	if locale != "" {
+		format, err := csv.LookupNumberFormat(locale)
+		if err != nil {
+			return options, err
+		}
+		options.Locale = format
+	}
+	for column, locale := range columns {
+		format, err := csv.LookupNumberFormat(locale)
+		if err != nil {
+			return options, fmt.Errorf("number format of '%s': %w", column, err)
+		}
+		options.Formats[column] = format
+	}
+	return options, nil
+}
+
+// checkErrorMode checks the flags handling bad rows
+func checkErrorMode(mode, rejectFile string, maxErrors int) error {
+	switch mode {
+	case "fail", "skip":
+		if rejectFile != "" {
+			return fmt.Errorf("the 'reject-file' flag requires '--on-error reject'")
+		}
+	case "reject":
+		if rejectFile == "" {
+			return fmt.Errorf("'--on-error reject' requires the 'reject-file' flag")
+		}
+	default:
+		return fmt.Errorf("unknown error handling '%s', expected fail, skip or reject", mode)
+	}
+	if maxErrors < 0 {
+		return errors.New("max errors must not be negative")
+	}
+	return nil
+}
+
+// rejectRecord is the line of the reject file for a bad row: its file, line, column and error, then its fields
+func rejectRecord(rowErr *csv.RowError) []string {
+	record := []string{rowErr.File, strconv.Itoa(rowErr.Line), rowErr.Column, rowErr.Err.Error()}
+	return append(record, rowErr.Record...)
+}
+
+// inputSize is the total size of the input files
+func inputSize(files []string) int64 {
+	var size int64
+	for _, path := range files {
+		if info, err := os.Stat(path); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// parseFilters parses the filter flags against the scheme
+func parseFilters(filters []string, scheme csv.Scheme) ([]csv.Filter, error) {
+	var parsedFilters []csv.Filter
+	if len(filters) != 0 {
+		slog.Debug("Parsing filters", "filters", filters)
+
+		for _, filter := range filters {
+			parsedFilter, err := csv.ParseFilter(filter, scheme)
+			if err != nil {
+				return nil, fmt.Errorf("parsing filter '%s': %w", filter, err)
+			}
+			parsedFilters = append(parsedFilters, parsedFilter)
+		}
+	}
+	return parsedFilters, nil
+}
+
+// usedColumns returns the input columns whose values are aggregated, sorted, ranked or deduplicated
+func usedColumns(scheme csv.Scheme, aggregations []csv.Aggregator, keys []csv.SortKey, dedupe *csv.Deduplication) []string {
+	used := []string{}
+	for _, aggregation := range aggregations {
+		used = append(used, aggregation.Column())
+	}
+	// Keys of aggregated results name grouping columns or aggregations, only the former are input columns
+	for _, key := range keys {
+		if _, ok := scheme.Columns[key.Column()]; ok {
+			used = append(used, key.Column())
+		}
+	}
+	if dedupe != nil {
+		used = append(used, dedupe.Columns()...)
+	}
+	return used
 }
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"bytes"
+	"go-data-tool/internal/progress"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// The types are inferred from the first two rows, the later rows have bad values
+const badValues = "id,amount,note\n1,10,a\n2,20,b\n3,oops,c\nx,40,d\n"
+
+func TestParseChecksUsedColumns(t *testing.T) {
+	input := writeFile(t, "bad.csv", badValues)
+	output := filepath.Join(t.TempDir(), "out.csv")
+
+	// Columns the run doesn't compute with are passed on as they are
+	if err := execute(t, "parse", "-i", input, "-o", output, "--infer-rows", "2", "-f", "note != b"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readRecords(t, output), [][]string{{"id", "amount", "note"}, {"1", "10", "a"}, {"3", "oops", "c"}, {"x", "40", "d"}}; !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("output %q, want %q", got, want)
+	}
+
+	// A bad value of an aggregated column fails the run
+	err := execute(t, "parse", "-i", input, "-o", output, "--infer-rows", "2", "-s", "amount")
+	if err == nil || !strings.Contains(err.Error(), `"oops"`) {
+		t.Errorf("error %v, want the bad value", err)
+	}
+}
+
+func TestParseSkipRejectAndMaxErrors(t *testing.T) {
+	// The last row has a missing field
+	input := writeFile(t, "bad.csv", badValues+"6,60\n")
+	dir := t.TempDir()
+	output := filepath.Join(dir, "out.csv")
+	rejects := filepath.Join(dir, "rejects.csv")
+
+	if err := execute(t, "parse", "-i", input, "-o", output, "--infer-rows", "2", "-s", "amount", "--on-error", "skip"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readRecords(t, output); len(got) != 2 || got[1][0] != "70" {
+		t.Errorf("output %q, want the sum 70", got)
+	}
+
+	if err := execute(t, "parse", "-i", input, "-o", output, "--infer-rows", "2", "--sort", "id desc", "-f", "amount >= 0",
+		"--on-error", "reject", "--reject-file", rejects); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readRecords(t, output), [][]string{{"id", "amount", "note"}, {"2", "20", "b"}, {"1", "10", "a"}}; !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("output %q, want %q", got, want)
+	}
+	var rejected []string
+	for _, record := range readRecords(t, rejects)[1:] {
+		rejected = append(rejected, record[1]+":"+record[2])
+	}
+	if want := []string{"4:amount", "5:id", "6:"}; !slices.Equal(rejected, want) {
+		t.Errorf("rejected lines and columns %v, want %v", rejected, want)
+	}
+
+	// Three bad rows are more than two
+	err := execute(t, "parse", "-i", input, "-o", output, "--infer-rows", "2", "--sort", "id", "-f", "amount >= 0",
+		"--on-error", "skip", "--max-errors", "2")
+	if err == nil || !strings.Contains(err.Error(), "more than 2 rows with errors") {
+		t.Errorf("error %v, want more than 2 rows with errors", err)
+	}
+	if err := execute(t, "parse", "-i", input, "-o", output, "--infer-rows", "2", "--sort", "id", "-f", "amount >= 0",
+		"--on-error", "skip", "--max-errors", "3"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseDistinctWithDedupeBy(t *testing.T) {
+	input := writeFile(t, "rows.csv", "id,name\n1,a\n1,b\n1,a\n")
+	output := filepath.Join(t.TempDir(), "out.csv")
+
+	err := execute(t, "parse", "-i", input, "-o", output, "--distinct", "--dedupe-by", "id")
+	if err == nil || !strings.Contains(err.Error(), "can't be used together") {
+		t.Errorf("error %v, want the flags refused", err)
+	}
+
+	for _, test := range []struct {
+		args []string
+		rows int
+	}{
+		{[]string{"--distinct"}, 2},
+		{[]string{"--dedupe-by", "id"}, 1},
+	} {
+		if err := execute(t, append([]string{"parse", "-i", input, "-o", output}, test.args...)...); err != nil {
+			t.Fatal(err)
+		}
+		if got := readRecords(t, output); len(got)-1 != test.rows {
+			t.Errorf("%v: output %q, want %d rows", test.args, got, test.rows)
+		}
+	}
+}
+
+func TestParseTopPerSeparatorInValues(t *testing.T) {
+	// Joined with "_" both groups would be "a_b_c_"
+	input := writeFile(t, "scores.csv", "first,second,score\na_b,c,1\na,b_c,2\na_b,c,3\n")
+	output := filepath.Join(t.TempDir(), "out.csv")
+
+	if err := execute(t, "parse", "-i", input, "-o", output, "--top", "1", "--by", "score desc", "--per", "first,second"); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"first", "second", "score"}, {"a_b", "c", "3"}, {"a", "b_c", "2"}}
+	if got := readRecords(t, output); !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("output %q, want %q", got, want)
+	}
+}
+
+func TestParseNumberOutput(t *testing.T) {
+	input := writeFile(t, "prices.csv", "id,price\n1,\"1.234,50 €\"\n2,\"99,90 €\"\n")
+	output := filepath.Join(t.TempDir(), "out.csv")
+
+	tests := []struct {
+		output string
+		want   [][]string
+	}{
+		{"normalize", [][]string{{"id", "price"}, {"2", "99.90"}, {"1", "1234.50"}}},
+		{"keep", [][]string{{"id", "price"}, {"2", "99,90 €"}, {"1", "1.234,50 €"}}},
+	}
+	for _, test := range tests {
+		if err := execute(t, "parse", "-i", input, "-o", output, "--locale", "de", "--sort", "price", "--number-output", test.output); err != nil {
+			t.Fatal(err)
+		}
+		if got := readRecords(t, output); !slices.EqualFunc(got, test.want, slices.Equal) {
+			t.Errorf("%s: output %q, want %q", test.output, got, test.want)
+		}
+	}
+}
+
+func TestParseProgressOnlyOnTerminal(t *testing.T) {
+	input := writeFile(t, "scores.csv", "id,score\n1,10\n2,20\n")
+	output := filepath.Join(t.TempDir(), "out.csv")
+	defer func(line *progress.Line, isTerminal func() bool) { stderr, stderrIsTerminal = line, isTerminal }(stderr, stderrIsTerminal)
+
+	tests := []struct {
+		terminal bool
+		args     []string
+		drawn    bool
+	}{
+		{false, nil, false},
+		{true, []string{"--quiet"}, false},
+		// The status line is at least removed at the end
+		{true, nil, true},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		stderr = progress.NewLine(&b)
+		stderrIsTerminal = func() bool { return test.terminal }
+		if err := execute(t, append([]string{"parse", "-i", input, "-o", output}, test.args...)...); err != nil {
+			t.Fatal(err)
+		}
+		if drawn := strings.Contains(b.String(), "\r"); drawn != test.drawn {
+			t.Errorf("terminal %t, %v: progress drawn %t: %q", test.terminal, test.args, drawn, b.String())
+		}
+	}
+}
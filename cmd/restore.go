@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"go-data-tool/internal/backup"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	restoreFrom     string // directory of the snapshots
+	restoreTo       string // directory the files are restored to
+	restoreAt       string // point in time, latest if empty
+	restoreSnapshot string // ID of the snapshot, chosen by restoreAt if empty
+	restoreForce    bool   // overwrite files that differ from the backup
+)
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore [paths...]",
+	Short: "Restoring files from a backup",
+	Long: `The restore command restores the files of a snapshot created by the backup command.
+The snapshot is the newest one created at or before the given time, the paths select single files or directories of it.
+All copies are verified against their checksums before anything is written.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		start := time.Now()
+		if restoreSnapshot != "" && restoreAt != "" {
+			return errors.New("only one of the 'snapshot' and 'at' flags can be used")
+		}
+		repo, err := openRepository(restoreFrom, false)
+		if err != nil {
+			return err
+		}
+		var manifest backup.Manifest
+		if restoreSnapshot != "" {
+			manifest, err = repo.FindSnapshot(restoreSnapshot)
+			if err != nil {
+				return err
+			}
+		} else {
+			at := time.Now()
+			if restoreAt != "" {
+				at, err = backup.ParseTime(restoreAt, time.Local)
+				if err != nil {
+					return err
+				}
+			}
+			manifests, err := repo.Snapshots()
+			if err != nil {
+				return fmt.Errorf("reading snapshots: %w", err)
+			}
+			manifest, err = backup.SnapshotAt(manifests, at)
+			if err != nil {
+				return err
+			}
+		}
+		slog.Info("Restoring from snapshot", "snapshot", manifest.ID, "created", manifest.Created.Local().Format(time.RFC3339))
+
+		recordInputs(filepath.Join(restoreFrom, manifest.ID))
+		recordOutputs(restoreTo)
+		entries, err := backup.SelectEntries(manifest, args)
+		if err != nil {
+			return err
+		}
+
+		result, err := repo.Restore(manifest, entries, restoreTo, restoreForce)
+		var conflict *backup.ConflictError
+		if errors.As(err, &conflict) {
+			return fmt.Errorf("restoring files: %w, use --force to overwrite them", err)
+		}
+		if err != nil {
+			return fmt.Errorf("restoring files: %w", err)
+		}
+		recordCount("restored", int64(result.Restored))
+		recordCount("unchanged", int64(result.Unchanged))
+		slog.Info("Files restored",
+			"to", restoreTo,
+			"restored", result.Restored,
+			"unchanged", result.Unchanged,
+			"duration", time.Since(start),
+		)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(restoreCmd)
+	restoreCmd.Flags().StringVar(&restoreFrom, "from", "", "directory of the snapshots (required)")
+	restoreCmd.MarkFlagRequired("from")
+	restoreCmd.Flags().StringVar(&restoreTo, "to", "", "directory the files are restored to (required)")
+	restoreCmd.MarkFlagRequired("to")
+
+	restoreCmd.Flags().StringVar(&restoreAt, "at", "", `point in time like "2025-06-01T12:00" in local time or with a zone (latest snapshot if empty)
+the newest snapshot created at or before it is restored`)
+	restoreCmd.Flags().StringVar(&restoreSnapshot, "snapshot", "", "ID of the snapshot to restore instead of choosing it by time")
+	addEncryptionFlags(restoreCmd.Flags())
+	restoreCmd.Flags().BoolVar(&restoreForce, "force", false, "overwrite existing files that differ from the backup")
+}
@@ -0,0 +1,356 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-data-tool/internal/api"
+	"go-data-tool/internal/csv"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	sendInputs     []string // input files, directories or glob patterns
+	sendFilters    []string // slice of installed filters
+	sendURL        string   // address of the API
+	sendMethod     string   // HTTP method
+	sendHeaders    []string // slice of headers in the format "Name: value"
+	sendBatchSize  int      // maximum number of rows per request
+	sendBatchBytes int      // maximum size of the rows of a request
+	sendReport     string   // report file
+	sendBody       string   // body template or @file with the template
+	// Delivery of the requests
+	sendWorkers int
+	sendHTTP    httpFlags
+	sendAuth    authFlags
+	// Failed rows, progress and idempotency
+	sendDeadLetter        string
+	sendState             string
+	sendResume            bool
+	sendIdempotencyKey    []string
+	sendIdempotencyHeader string
+)
+
+var sendCmd = &cobra.Command{
+	Use:   "send",
+	Short: "Sending CSV data to a REST API",
+	Long: `The send command reads CSV file data, performs filtering and sends the rows to a REST API as JSON.
+Rows are sent one per request or in batches as JSON arrays, the result of every row can be written to a report.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		start := time.Now()
+		inputFiles, err := expandInputs(sendInputs)
+		if err != nil {
+			return err
+		}
+		recordInputs(inputFiles...)
+		if sendBatchSize < 0 || sendBatchBytes < 0 {
+			return errors.New("batch size must not be negative")
+		}
+		if sendWorkers < 1 || sendHTTP.rps < 0 || sendHTTP.retries < 0 {
+			return errors.New("workers must be positive, rps and retries must not be negative")
+		}
+
+		header, err := parseHeaders(sendHeaders)
+		if err != nil {
+			return err
+		}
+
+		slog.Info("Parsing structure", "input", inputFiles)
+		scheme, err := csv.ParseCSVStructure(inputFiles...)
+		if err != nil {
+			return fmt.Errorf("parsing csv structure: %w", err)
+		}
+		parsedFilters, err := parseFilters(sendFilters, scheme)
+		if err != nil {
+			return err
+		}
+
+		bodyTemplate := sendBody
+		if path, ok := strings.CutPrefix(sendBody, "@"); ok {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("reading body template: %w", err)
+			}
+			bodyTemplate = string(data)
+		}
+
+		// Templates are rendered per row, or per batch when rows are sent in batches
+		array := sendBatchSize != 1 || sendBatchBytes != 0
+		builder, err := api.NewTemplateBuilder(strings.ToUpper(sendMethod), sendURL, header, bodyTemplate, scheme, array)
+		if err != nil {
+			return fmt.Errorf("parsing template: %w", err)
+		}
+
+		var idempotencyColumns []int
+		for _, column := range sendIdempotencyKey {
+			info, ok := scheme.Columns[column]
+			if !ok {
+				return fmt.Errorf("idempotency key column '%s' doesn't exist", column)
+			}
+			idempotencyColumns = append(idempotencyColumns, info.Index)
+		}
+
+		// The checkpoint identifies the rows, so that a resumed run numbers them the same way
+		checkpoint := api.Checkpoint{Inputs: inputFiles, Filters: sendFilters, URL: sendURL}
+		if sendResume {
+			if sendState == "" {
+				return errors.New("resuming requires the 'state' flag")
+			}
+			saved, err := api.LoadCheckpoint(sendState)
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+				slog.Info("No saved state, starting from the first row", "state", sendState)
+			case err != nil:
+				return fmt.Errorf("reading state file: %w", err)
+			case !saved.Matches(checkpoint):
+				return errors.New("state file was saved for other inputs, filters or url")
+			default:
+				checkpoint.Acknowledged = saved.Acknowledged
+				slog.Info("Resuming", "state", sendState, "acknowledged", checkpoint.Acknowledged)
+			}
+		}
+		progress := api.NewProgress(checkpoint.Acknowledged)
+		lastSave := time.Now()
+		saveState := func() error {
+			if sendState == "" {
+				return nil
+			}
+			checkpoint.Acknowledged = progress.Acknowledged()
+			lastSave = time.Now()
+			return checkpoint.Save(sendState)
+		}
+
+		// Report and dead-letter file are appended to when resuming
+		openOutput := func(path string, headers []string) (*csv.Writer, error) {
+			var writer *csv.Writer
+			empty := true
+			var err error
+			if sendResume {
+				writer, empty, err = csv.NewAppendWriter(path)
+			} else {
+				writer, err = csv.NewWriter(path)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("creating file '%s': %w", path, err)
+			}
+			recordOutputs(path)
+			if empty {
+				if err := writer.Write(headers); err != nil {
+					writer.Close()
+					return nil, fmt.Errorf("writing file '%s': %w", path, err)
+				}
+			}
+			return writer, nil
+		}
+		var report, deadLetter *csv.Writer
+		if sendReport != "" {
+			report, err = openOutput(sendReport, []string{"row", "batch", "status", "attempts", "error"})
+			if err != nil {
+				return err
+			}
+			defer report.Close()
+		}
+		if sendDeadLetter != "" {
+			deadLetter, err = openOutput(sendDeadLetter, append(append([]string{}, scheme.Headers...), "_row", "_status", "_response", "_error"))
+			if err != nil {
+				return err
+			}
+			defer deadLetter.Close()
+		}
+
+		sent, failed := 0, 0
+		onResult := func(result api.Result) error {
+			errorText := ""
+			if result.Err != nil {
+				failed += len(result.Batch.Rows)
+				errorText = result.Err.Error()
+				slog.Warn("Batch failed",
+					"batch", result.Batch.Number,
+					"rows", len(result.Batch.Rows),
+					"status", result.Status,
+					"attempts", result.Attempts,
+					"error", result.Err,
+				)
+			} else {
+				sent += len(result.Batch.Rows)
+				slog.Debug("Batch sent",
+					"batch", result.Batch.Number,
+					"rows", len(result.Batch.Rows),
+					"status", result.Status,
+					"attempts", result.Attempts,
+				)
+			}
+
+			for _, row := range result.Batch.Rows {
+				if report != nil {
+					err := report.Write([]string{
+						strconv.Itoa(row.Number),
+						strconv.Itoa(result.Batch.Number),
+						strconv.Itoa(result.Status),
+						strconv.Itoa(result.Attempts),
+						errorText,
+					})
+					if err != nil {
+						return err
+					}
+				}
+				if deadLetter != nil && result.Err != nil {
+					err := deadLetter.Write(append(append([]string{}, row.Record...),
+						strconv.Itoa(row.Number),
+						strconv.Itoa(result.Status),
+						string(result.Response),
+						errorText,
+					))
+					if err != nil {
+						return err
+					}
+				}
+			}
+
+			// Failed rows are done only once they are kept in the dead-letter file
+			if result.Err != nil && deadLetter == nil {
+				return nil
+			}
+			if progress.Done(result.Batch.Rows) && time.Since(lastSave) > time.Second {
+				// The outputs are flushed first, so that the state never runs ahead of them
+				for _, writer := range []*csv.Writer{report, deadLetter} {
+					if writer != nil {
+						if err := writer.Flush(); err != nil {
+							return err
+						}
+					}
+				}
+				return saveState()
+			}
+			return nil
+		}
+
+		// Ctrl-C cancels the requests in flight, the finished ones are still reported
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.MaxIdleConnsPerHost = sendWorkers
+		client := &http.Client{Transport: transport}
+		auth, err := newAuthenticator(sendAuth, client)
+		if err != nil {
+			return fmt.Errorf("authentication: %w", err)
+		}
+
+		sender := api.NewSender(client, builder, scheme, api.SenderConfig{
+			BatchSize:  sendBatchSize,
+			BatchBytes: sendBatchBytes,
+			Workers:    sendWorkers,
+			RPS:        sendHTTP.rps,
+			Burst:      sendHTTP.burst,
+			Timeout:    sendHTTP.timeout,
+			Retries:    sendHTTP.retries,
+			Backoff:    sendHTTP.backoff,
+			MaxBackoff: sendHTTP.maxBackoff,
+			Skip:       checkpoint.Acknowledged,
+
+			IdempotencyHeader:  sendIdempotencyHeader,
+			IdempotencyColumns: idempotencyColumns,
+			Auth:               auth,
+		}, onResult)
+
+		slog.Info("Sending data", "url", sendURL, "workers", sendWorkers)
+		stats, err := csv.ReadCSVFiles(inputFiles, scheme, parsedFilters, func(record []string) error {
+			return sender.Add(ctx, record)
+		})
+		if closeErr := sender.Close(ctx); err == nil {
+			err = closeErr
+		}
+
+		for _, writer := range []*csv.Writer{report, deadLetter} {
+			if writer != nil {
+				if err := writer.Close(); err != nil {
+					return fmt.Errorf("saving report: %w", err)
+				}
+			}
+		}
+		if err := saveState(); err != nil {
+			return fmt.Errorf("saving state: %w", err)
+		}
+
+		recordCount("rows_read", int64(stats.Read))
+		recordCount("rows_filtered", int64(stats.Filtered))
+		recordCount("rows_sent", int64(sent))
+		recordCount("rows_failed", int64(failed))
+		slog.Info("Data was sent",
+			"input", inputFiles,
+			"rows_read", stats.Read,
+			"rows_filtered", stats.Filtered,
+			"rows_sent", sent,
+			"rows_failed", failed,
+			"duration", time.Since(start),
+		)
+		if errors.Is(err, context.Canceled) {
+			return fmt.Errorf("interrupted: %d rows sent, %d rows failed", sent, failed)
+		}
+		if err != nil {
+			return fmt.Errorf("sending data: %w", err)
+		}
+		if failed != 0 {
+			return fmt.Errorf("%d rows failed", failed)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sendCmd)
+	sendCmd.Flags().StringArrayVarP(&sendInputs, "input", "i", []string{}, "file address, directory or glob pattern for sending (required)")
+	sendCmd.MarkFlagRequired("input")
+
+	sendCmd.Flags().StringSliceVarP(&sendFilters, "filter", "f", []string{}, `set of filters in the format "column operation value"
+can be passed in by separating them with commas or by reusing the flag
+possible operations: =, !=, >, >=, <, <=`)
+
+	sendCmd.Flags().StringVar(&sendURL, "url", "", `address of the API, a template like "https://example.com/users/{{path .id}}" (required)`)
+	sendCmd.MarkFlagRequired("url")
+	sendCmd.Flags().StringVarP(&sendMethod, "method", "X", http.MethodPost, "HTTP method")
+	sendCmd.Flags().StringArrayVarP(&sendHeaders, "header", "H", []string{}, `request header in the format "Name: value", can be repeated
+the value is a template like the url`)
+	addAuthFlags(sendCmd, &sendAuth)
+	sendCmd.Flags().StringVar(&sendBody, "body-template", "", `template of the request body or @file with the template (JSON of the rows if empty)
+templates get the row with typed values, e.g. {{.id}}, or .Batch and .Rows when rows are sent in batches
+functions: json, path, query, date, now, sha256, sha1, md5, base64, lower, upper, trim, default`)
+
+	sendCmd.Flags().IntVar(&sendBatchSize, "batch-size", 1, `maximum number of rows per request (0 for no limit)
+a single row is sent as a JSON object, batches as JSON arrays`)
+	sendCmd.Flags().IntVar(&sendBatchBytes, "batch-bytes", 0, "maximum size in bytes of the JSON rows of a request (0 for no limit)")
+
+	sendCmd.Flags().IntVarP(&sendWorkers, "workers", "w", 1, "number of concurrent requests")
+	addHTTPFlags(sendCmd, &sendHTTP)
+
+	sendCmd.Flags().StringVar(&sendReport, "report", "", "report file address with the result of every row")
+	sendCmd.Flags().StringVar(&sendDeadLetter, "dead-letter", "", "file address for failed rows with their HTTP status, response body and error")
+	sendCmd.Flags().StringVar(&sendState, "state", "", "state file address where the last acknowledged row is saved")
+	sendCmd.Flags().BoolVar(&sendResume, "resume", false, `continue after the last acknowledged row of the state file
+failed rows count as acknowledged only when they are kept in the dead-letter file`)
+	sendCmd.Flags().StringSliceVar(&sendIdempotencyKey, "idempotency-key", []string{}, "set of columns the idempotency key of a request is derived from")
+	sendCmd.Flags().StringVar(&sendIdempotencyHeader, "idempotency-header", "Idempotency-Key", "header carrying the idempotency key")
+}
+
+// parseHeaders parses the header flags
+func parseHeaders(headers []string) (http.Header, error) {
+	header := make(http.Header)
+	for _, h := range headers {
+		name, value, found := strings.Cut(h, ":")
+		if !found {
+			return nil, fmt.Errorf("header '%s' is not in the format 'Name: value'", h)
+		}
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return header, nil
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// resetFlags sets the flags of all commands back to their defaults, since the flag variables outlive a run
+func resetFlags() {
+	var reset func(cmd *cobra.Command)
+	reset = func(cmd *cobra.Command) {
+		cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+			if slice, ok := flag.Value.(pflag.SliceValue); ok {
+				slice.Replace(nil)
+			} else if flag.Value.Type() != "stringToString" {
+				flag.Value.Set(flag.DefValue)
+			}
+			flag.Changed = false
+		})
+		for _, sub := range cmd.Commands() {
+			reset(sub)
+		}
+	}
+	reset(rootCmd)
+}
+
+// execute runs the command line with default flags, runs aren't recorded in the history
+func execute(t *testing.T, args ...string) error {
+	t.Helper()
+	resetFlags()
+	rootCmd.SetArgs(append(args, "--no-history", "--log-level", "error"))
+	return rootCmd.Execute()
+}
+
+// writeFile writes a test file and returns its path
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readRecords(t *testing.T, path string) [][]string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	// Rejected rows keep the fields they had
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+// rowServer answers every row by its id with the status of fail, 200 for the other ids
+type rowServer struct {
+	mu   sync.Mutex
+	ids  []string
+	fail map[string]int
+}
+
+func (s *rowServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	var row struct{ ID json.Number }
+	json.Unmarshal(body, &row)
+	s.mu.Lock()
+	s.ids = append(s.ids, row.ID.String())
+	s.mu.Unlock()
+	if status, ok := s.fail[row.ID.String()]; ok {
+		w.WriteHeader(status)
+		io.WriteString(w, `{"error":"rejected"}`)
+	}
+}
+
+const sendInput = "id,name\n1,Alice\n2,Bob\n3,Carol\n4,Dave\n5,Eve\n"
+
+func TestSendDeadLetter(t *testing.T) {
+	server := httptest.NewServer(&rowServer{fail: map[string]int{"2": 400, "4": 422}})
+	defer server.Close()
+	input := writeFile(t, "users.csv", sendInput)
+	deadLetter := filepath.Join(t.TempDir(), "failed.csv")
+
+	err := execute(t, "send", "-i", input, "--url", server.URL, "--dead-letter", deadLetter, "--retries", "0")
+	if err == nil || !strings.Contains(err.Error(), "2 rows failed") {
+		t.Fatalf("error = %v, want 2 rows failed", err)
+	}
+
+	want := [][]string{
+		{"id", "name", "_row", "_status", "_response", "_error"},
+		{"2", "Bob", "2", "400", `{"error":"rejected"}`, "unexpected status 400 Bad Request"},
+		{"4", "Dave", "4", "422", `{"error":"rejected"}`, "unexpected status 422 Unprocessable Entity"},
+	}
+	got := readRecords(t, deadLetter)
+	if len(got) != len(want) {
+		t.Fatalf("dead letter = %q, want %q", got, want)
+	}
+	for i := range want {
+		if !slices.Equal(got[i][:5], want[i][:5]) || !strings.Contains(got[i][5], want[i][5]) {
+			t.Errorf("dead letter line %d = %q, want %q", i+1, got[i], want[i])
+		}
+	}
+}
+
+func TestSendResume(t *testing.T) {
+	input := writeFile(t, "users.csv", sendInput)
+	dir := t.TempDir()
+	state := filepath.Join(dir, "state.json")
+	report := filepath.Join(dir, "report.csv")
+
+	// The first run stops acknowledging at the failed row, the rows after it are sent again
+	rows := &rowServer{fail: map[string]int{"3": 500}}
+	server := httptest.NewServer(rows)
+	defer server.Close()
+	if err := execute(t, "send", "-i", input, "--url", server.URL, "--state", state, "--report", report, "--retries", "0"); err == nil {
+		t.Fatal("run with a failed row succeeded")
+	}
+
+	rows.fail, rows.ids = nil, nil
+	if err := execute(t, "send", "-i", input, "--url", server.URL, "--state", state, "--report", report, "--resume"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"3", "4", "5"}; !slices.Equal(rows.ids, want) {
+		t.Errorf("resumed run sent %v, want %v", rows.ids, want)
+	}
+
+	// The report is appended to, so row 3 is in it twice
+	var reported []string
+	for _, record := range readRecords(t, report)[1:] {
+		reported = append(reported, record[0]+":"+record[2])
+	}
+	if want := []string{"1:200", "2:200", "3:500", "4:200", "5:200", "3:200", "4:200", "5:200"}; !slices.Equal(reported, want) {
+		t.Errorf("report rows %v, want %v", reported, want)
+	}
+
+	// A state saved for other inputs is refused
+	other := writeFile(t, "other.csv", sendInput)
+	if err := execute(t, "send", "-i", other, "--url", server.URL, "--state", state, "--resume"); err == nil {
+		t.Error("resumed with a state of other inputs")
+	}
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go-data-tool/internal/metrics"
+	"os"
+	"strconv"
+	"time"
+)
+
+// parseStats is the summary of a parse run written by the stats-json and metrics-file flags
+type parseStats struct {
+	Started         time.Time     `json:"started"`
+	DurationSeconds float64       `json:"duration_seconds"`
+	Inputs          []string      `json:"inputs"`
+	Output          string        `json:"output"`
+	RowsRead        int           `json:"rows_read"`
+	RowsFiltered    int           `json:"rows_filtered"`
+	RowsRejected    int           `json:"rows_rejected"`
+	ValuesNulled    int           `json:"values_nulled"`
+	Filters         []filterStats `json:"filters"`
+	Groups          int           `json:"groups"`
+	RowsDuplicate   int           `json:"rows_duplicate"`
+	RowsWritten     int           `json:"rows_written"`
+	BytesIn         int64         `json:"bytes_in"`
+	BytesOut        int64         `json:"bytes_out"`
+	Phases          phaseStats    `json:"phases_seconds"`
+}
+
+// filterStats counts the rows passing a filter, out of the rows that passed the filters before it
+type filterStats struct {
+	Filter string `json:"filter"`
+	Passed int    `json:"passed"`
+}
+
+// phaseStats are the durations of the phases of a run in seconds, every phase excludes the time spent writing
+type phaseStats struct {
+	Schema      float64 `json:"schema"`      // inference of the column types
+	Parse       float64 `json:"parse"`       // reading and filtering
+	Aggregation float64 `json:"aggregation"` // deduplication, grouping, top selection and sorting
+	Write       float64 `json:"write"`       // writing the output
+}
+
+// writeStatsJSON writes the summary as an indented JSON document
+func writeStatsJSON(path string, stats any) error {
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	// Filters like "amount>5" are kept readable
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(stats); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b.Bytes(), 0o644)
+}
+
+// metrics converts the summary to gauges, every sample gets the extra labels
+func (s parseStats) metrics(labels map[string]string) []metrics.Metric {
+	gauge := func(name, help string, value float64) metrics.Metric {
+		return metrics.Metric{
+			Name:    "go_data_tool_parse_" + name,
+			Help:    help,
+			Type:    metrics.Gauge,
+			Samples: []metrics.Sample{{Labels: labels, Value: value}},
+		}
+	}
+	with := func(pairs ...string) map[string]string {
+		merged := make(map[string]string)
+		for k, v := range labels {
+			merged[k] = v
+		}
+		for i := 0; i < len(pairs); i += 2 {
+			merged[pairs[i]] = pairs[i+1]
+		}
+		return merged
+	}
+
+	filters := metrics.Metric{
+		Name: "go_data_tool_parse_filter_rows_passed",
+		Help: "Rows passing the filter out of the rows that passed the filters before it.",
+		Type: metrics.Gauge,
+	}
+	// The position of the filter tells apart samples of a filter passed more than once
+	for i, filter := range s.Filters {
+		sample := metrics.Sample{Labels: with("index", strconv.Itoa(i+1), "filter", filter.Filter), Value: float64(filter.Passed)}
+		filters.Samples = append(filters.Samples, sample)
+	}
+	phases := metrics.Metric{
+		Name: "go_data_tool_parse_phase_duration_seconds",
+		Help: "Duration of the phase of the last run, without the time spent writing the output.",
+		Type: metrics.Gauge,
+		Samples: []metrics.Sample{
+			{Labels: with("phase", "schema"), Value: s.Phases.Schema},
+			{Labels: with("phase", "parse"), Value: s.Phases.Parse},
+			{Labels: with("phase", "aggregation"), Value: s.Phases.Aggregation},
+			{Labels: with("phase", "write"), Value: s.Phases.Write},
+		},
+	}
+
+	result := []metrics.Metric{
+		gauge("last_success_timestamp_seconds", "Time the last successful run finished.", float64(s.Started.UnixNano())/1e9+s.DurationSeconds),
+		gauge("duration_seconds", "Duration of the last run.", s.DurationSeconds),
+		gauge("rows_read", "Rows read from the input files by the last run.", float64(s.RowsRead)),
+		gauge("rows_filtered", "Rows dropped by the filters in the last run.", float64(s.RowsFiltered)),
+		gauge("rows_rejected", "Rows skipped or rejected as they can't be read or have values not matching their column types in the last run.", float64(s.RowsRejected)),
+		gauge("values_nulled", "Outliers read as nulls by the last run.", float64(s.ValuesNulled)),
+		gauge("groups", "Groups formed by the last run.", float64(s.Groups)),
+		gauge("rows_duplicate", "Duplicate rows dropped by the last run.", float64(s.RowsDuplicate)),
+		gauge("rows_written", "Rows written to the output by the last run.", float64(s.RowsWritten)),
+		gauge("input_bytes", "Size of the input files of the last run.", float64(s.BytesIn)),
+		gauge("output_bytes", "Size of the output file of the last run.", float64(s.BytesOut)),
+		phases,
+	}
+	if len(filters.Samples) != 0 {
+		result = append(result, filters)
+	}
+	return result
+}
+
+// checkMetricsLabels checks the names of the labels from the metrics-labels flag
+func checkMetricsLabels(labels map[string]string) error {
+	for name := range labels {
+		if !metrics.ValidLabel(name) || name == "filter" || name == "index" || name == "phase" {
+			return fmt.Errorf("invalid metrics label '%s'", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"go-data-tool/internal/metrics"
+	"strings"
+	"testing"
+)
+
+func TestRepeatedFilterMetrics(t *testing.T) {
+	stats := parseStats{Filters: []filterStats{{"amount>5", 10}, {"name=bob", 4}, {"amount>5", 4}}}
+	text, err := metrics.Format(stats.metrics(map[string]string{"job_name": "nightly"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every filter is a series of its own, the repeated one included
+	want := []string{
+		`go_data_tool_parse_filter_rows_passed{filter="amount>5",index="1",job_name="nightly"} 10`,
+		`go_data_tool_parse_filter_rows_passed{filter="name=bob",index="2",job_name="nightly"} 4`,
+		`go_data_tool_parse_filter_rows_passed{filter="amount>5",index="3",job_name="nightly"} 4`,
+	}
+	for _, line := range want {
+		if !strings.Contains(string(text), line+"\n") {
+			t.Errorf("metrics have no line %s:\n%s", line, text)
+		}
+	}
+}
+
+func TestCheckMetricsLabels(t *testing.T) {
+	if err := checkMetricsLabels(map[string]string{"job_name": "nightly", "env": "prod"}); err != nil {
+		t.Error(err)
+	}
+	for _, name := range []string{"filter", "index", "phase", "__name", "job-name"} {
+		if err := checkMetricsLabels(map[string]string{name: "x"}); err == nil {
+			t.Errorf("label %s accepted", name)
+		}
+	}
+}
@@ -2,9 +2,10 @@ module go-data-tool
 
 go 1.23.4
 
-require github.com/spf13/cobra v1.9.1
-
 require (
-	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/spf13/pflag v1.0.6 // indirect
+	github.com/spf13/cobra v1.9.1
+	github.com/spf13/pflag v1.0.6
+	golang.org/x/crypto v0.31.0
 )
+
+require github.com/inconshreveable/mousetrap v1.1.0 // indirect
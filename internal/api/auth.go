@@ -0,0 +1,222 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clock returns the current time of signatures and token expiry, tests replace it
+var clock = time.Now
+
+// Authenticator adds credentials to a request before every attempt
+type Authenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+// invalidator is an Authenticator whose credentials can expire before their time, e.g. revoked tokens
+type invalidator interface {
+	Invalidate()
+}
+
+/*
+ReadSecret reads a secret from an environment variable or a file, so that secrets never appear
+in the command line and the shell history. The reference is "env:NAME" or "file:PATH",
+trailing line breaks of files are removed.
+*/
+func ReadSecret(ref string) (string, error) {
+	kind, name, ok := strings.Cut(ref, ":")
+	if !ok || name == "" {
+		return "", fmt.Errorf("secret '%s' must be in the format env:NAME or file:PATH", ref)
+	}
+	switch kind {
+	case "env":
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			return "", fmt.Errorf("environment variable '%s' is not set", name)
+		}
+		return value, nil
+	case "file":
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return "", err
+		}
+		value := strings.TrimRight(string(data), "\r\n")
+		if value == "" {
+			return "", fmt.Errorf("secret file '%s' is empty", name)
+		}
+		return value, nil
+	}
+	return "", fmt.Errorf("unknown secret source '%s', use env or file", kind)
+}
+
+// HeaderAuth sets a static header, e.g. "Authorization: Bearer <token>" or an API key
+type HeaderAuth struct {
+	Name  string
+	Value string
+}
+
+func (a *HeaderAuth) Authenticate(req *http.Request) error {
+	req.Header.Set(a.Name, a.Value)
+	return nil
+}
+
+// BasicAuth sets the Authorization header of HTTP basic authentication
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+func (a *BasicAuth) Authenticate(req *http.Request) error {
+	req.SetBasicAuth(a.Username, a.Password)
+	return nil
+}
+
+/*
+HMACAuth signs every request with HMAC-SHA256 of the string
+
+	METHOD\nREQUEST-URI\nTIMESTAMP\nHEX(SHA256(BODY))
+
+The hex signature is sent in Header and the unix timestamp in TimestampHeader,
+so that the server can reject replayed requests.
+*/
+type HMACAuth struct {
+	Key             []byte
+	Header          string
+	TimestampHeader string
+}
+
+func (a *HMACAuth) Authenticate(req *http.Request) error {
+	bodyHash := sha256.New()
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(bodyHash, body)
+		body.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	timestamp := strconv.FormatInt(clock().Unix(), 10)
+	mac := hmac.New(sha256.New, a.Key)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%x", req.Method, req.URL.RequestURI(), timestamp, bodyHash.Sum(nil))
+
+	req.Header.Set(a.TimestampHeader, timestamp)
+	req.Header.Set(a.Header, hex.EncodeToString(mac.Sum(nil)))
+	return nil
+}
+
+// Tokens are refreshed this long before they expire, or after half of their lifetime if it is shorter
+const tokenExpiryMargin = 30 * time.Second
+
+/*
+ClientCredentials gets access tokens with the OAuth2 client credentials grant (RFC 6749, section 4.4).
+The token is cached and shared by all workers; it is requested again shortly before it expires
+and after the API rejects it with 401 Unauthorized.
+*/
+type ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	Client       *http.Client
+
+	mu     sync.Mutex
+	token  string // "Bearer <access token>", empty if no valid token
+	expiry time.Time
+}
+
+// tokenResponse is the successful response of a token endpoint
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+func (c *ClientCredentials) Authenticate(req *http.Request) error {
+	token, err := c.Token(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", token)
+	return nil
+}
+
+// Invalidate drops the cached token, so that the next request gets a new one
+func (c *ClientCredentials) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = ""
+}
+
+// Token returns the value of the Authorization header, requesting a new token if needed
+func (c *ClientCredentials) Token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && (c.expiry.IsZero() || clock().Before(c.expiry)) {
+		return c.token, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.Scopes) != 0 {
+		form.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return "", fmt.Errorf("token request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request: unexpected status %s: %s", resp.Status, body)
+	}
+
+	var token tokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", fmt.Errorf("token response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("token response: no access_token")
+	}
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return "", fmt.Errorf("token response: unsupported token type '%s'", token.TokenType)
+	}
+
+	c.token = "Bearer " + token.AccessToken
+	c.expiry = time.Time{}
+	if token.ExpiresIn > 0 {
+		lifetime := time.Duration(token.ExpiresIn) * time.Second
+		c.expiry = clock().Add(lifetime - min(tokenExpiryMargin, lifetime/2))
+	}
+	return c.token, nil
+}
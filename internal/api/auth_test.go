@@ -0,0 +1,193 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// setClock makes the package see a fixed time that the test moves with the returned function
+func setClock(t *testing.T, start time.Time) func(time.Duration) {
+	current := start
+	clock = func() time.Time { return current }
+	t.Cleanup(func() { clock = time.Now })
+	return func(d time.Duration) { current = current.Add(d) }
+}
+
+// tokenServer issues the tokens "t1", "t2", ... valid for expiresIn seconds
+func tokenServer(t *testing.T, expiresIn int) (*httptest.Server, *atomic.Int32) {
+	var issued atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id, secret, ok := req.BasicAuth()
+		if !ok || id != "client" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err := req.ParseForm(); err != nil || req.PostForm.Get("grant_type") != "client_credentials" || req.PostForm.Get("scope") != "read write" {
+			t.Errorf("token request form %v", req.PostForm)
+		}
+		n := issued.Add(1)
+		fmt.Fprintf(w, `{"access_token":"t%d","token_type":"Bearer","expires_in":%d}`, n, expiresIn)
+	}))
+	t.Cleanup(server.Close)
+	return server, &issued
+}
+
+func TestClientCredentialsCachesToken(t *testing.T) {
+	advance := setClock(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	server, issued := tokenServer(t, 3600)
+	auth := &ClientCredentials{TokenURL: server.URL, ClientID: "client", ClientSecret: "s3cret", Scopes: []string{"read", "write"}, Client: server.Client()}
+	ctx := context.Background()
+
+	tests := []struct {
+		advance time.Duration
+		want    string
+	}{
+		{0, "Bearer t1"},
+		{time.Hour - tokenExpiryMargin - time.Second, "Bearer t1"}, // cached until shortly before the expiry
+		{time.Second, "Bearer t2"},                                 // refreshed at the margin
+		{time.Minute, "Bearer t2"},
+	}
+	for i, test := range tests {
+		advance(test.advance)
+		token, err := auth.Token(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != test.want {
+			t.Errorf("step %d: token %q, want %q", i+1, token, test.want)
+		}
+	}
+	if n := issued.Load(); n != 2 {
+		t.Errorf("%d tokens issued, want 2", n)
+	}
+
+	auth.Invalidate()
+	if token, _ := auth.Token(ctx); token != "Bearer t3" {
+		t.Errorf("token after invalidation %q, want Bearer t3", token)
+	}
+}
+
+func TestClientCredentialsShortLifetime(t *testing.T) {
+	// Tokens living less than twice the margin are refreshed after half of their lifetime
+	advance := setClock(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	server, _ := tokenServer(t, 20)
+	auth := &ClientCredentials{TokenURL: server.URL, ClientID: "client", ClientSecret: "s3cret", Scopes: []string{"read", "write"}, Client: server.Client()}
+	ctx := context.Background()
+
+	first, _ := auth.Token(ctx)
+	advance(9 * time.Second)
+	second, _ := auth.Token(ctx)
+	advance(time.Second)
+	third, _ := auth.Token(ctx)
+	if first != "Bearer t1" || second != "Bearer t1" || third != "Bearer t2" {
+		t.Errorf("tokens %q, %q, %q, want t1, t1, t2", first, second, third)
+	}
+}
+
+func TestClientCredentialsErrors(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		want   string
+	}{
+		{http.StatusOK, `{"token_type":"Bearer"}`, "no access_token"},
+		{http.StatusOK, `{"access_token":"x","token_type":"mac"}`, "unsupported token type"},
+		{http.StatusOK, `{"access_token":`, "token response"},
+		{http.StatusUnauthorized, `{"error":"invalid_client"}`, "unexpected status 401"},
+	}
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		}))
+		auth := &ClientCredentials{TokenURL: server.URL, ClientID: "client", ClientSecret: "s3cret"}
+		_, err := auth.Token(context.Background())
+		server.Close()
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("response %s: error %v, want %q", test.body, err, test.want)
+		}
+	}
+}
+
+func TestSenderRenewsRejectedToken(t *testing.T) {
+	tokens, issued := tokenServer(t, 3600)
+	auth := &ClientCredentials{TokenURL: tokens.URL, ClientID: "client", ClientSecret: "s3cret", Scopes: []string{"read", "write"}, Client: tokens.Client()}
+
+	// The API revokes the first token before its expiry
+	var authorizations []string
+	rec := &recorder{response: func(n int, w http.ResponseWriter) {
+		if n == 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}}
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		authorizations = append(authorizations, req.Header.Get("Authorization"))
+		rec.ServeHTTP(w, req)
+	}))
+	defer api.Close()
+
+	results := send(t, api, testRows(1), SenderConfig{BatchSize: 1, Auth: auth})
+	if result := results[0]; result.Err != nil || result.Attempts != 2 {
+		t.Errorf("attempts %d, error %v", result.Attempts, result.Err)
+	}
+	if want := []string{"Bearer t1", "Bearer t2"}; strings.Join(authorizations, ",") != strings.Join(want, ",") || issued.Load() != 2 {
+		t.Errorf("authorizations %v, want %v", authorizations, want)
+	}
+}
+
+func TestHMACAuthKnownSignature(t *testing.T) {
+	setClock(t, time.Unix(1700000000, 0))
+	auth := &HMACAuth{Key: []byte("secret-key"), Header: "X-Signature", TimestampHeader: "X-Timestamp"}
+
+	// Signatures computed with: printf 'POST\n/users?dry=1\n1700000000\n<sha256 of body>' | openssl dgst -sha256 -hmac secret-key
+	tests := []struct {
+		method, url, body string
+		want              string
+	}{
+		{http.MethodPost, "https://example.com/users?dry=1", `{"id":1}`, "998619327c4c2be56d4dfbe381cb5be69bfed1a70f40d7ceb5339f355ad4d10c"},
+		{http.MethodGet, "https://example.com/users/7", "", "257ebd8bd75b8e3697ede22fd726a9fdafc84871793bc94045f09080eeaebbed"},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, test.url, strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := auth.Authenticate(req); err != nil {
+			t.Fatal(err)
+		}
+		if got := req.Header.Get("X-Signature"); got != test.want {
+			t.Errorf("%s %s: signature %s, want %s", test.method, test.url, got, test.want)
+		}
+		if got := req.Header.Get("X-Timestamp"); got != "1700000000" {
+			t.Errorf("%s %s: timestamp %s", test.method, test.url, got)
+		}
+	}
+}
+
+func TestStaticAuthHeaders(t *testing.T) {
+	tests := []struct {
+		auth         Authenticator
+		header, want string
+	}{
+		// "user:pa:ss" in base64, the password may contain colons
+		{&BasicAuth{Username: "user", Password: "pa:ss"}, "Authorization", "Basic dXNlcjpwYTpzcw=="},
+		{&HeaderAuth{Name: "X-Api-Key", Value: "k3y"}, "X-Api-Key", "k3y"},
+		{&HeaderAuth{Name: "Authorization", Value: "Bearer abc"}, "Authorization", "Bearer abc"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+		req.Header.Set(test.header, "previous")
+		if err := test.auth.Authenticate(req); err != nil {
+			t.Fatal(err)
+		}
+		if got := req.Header.Values(test.header); len(got) != 1 || got[0] != test.want {
+			t.Errorf("%T: %s = %q, want %q", test.auth, test.header, got, test.want)
+		}
+	}
+}
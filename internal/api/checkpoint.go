@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+/*
+Checkpoint is the progress of a send saved in a state file.
+All rows up to Acknowledged were either accepted or written to the dead-letter file,
+so a resumed run starts after it. Inputs and Filters make sure the rows are numbered the same way.
+*/
+type Checkpoint struct {
+	Inputs       []string  `json:"inputs"`
+	Filters      []string  `json:"filters"`
+	URL          string    `json:"url"`
+	Acknowledged int       `json:"acknowledged"`
+	Updated      time.Time `json:"updated"`
+}
+
+func LoadCheckpoint(path string) (Checkpoint, error) {
+	var checkpoint Checkpoint
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return checkpoint, err
+	}
+	err = json.Unmarshal(data, &checkpoint)
+	return checkpoint, err
+}
+
+// Matches reports whether the checkpoint was made for the same rows and target
+func (c Checkpoint) Matches(other Checkpoint) bool {
+	return slices.Equal(c.Inputs, other.Inputs) && slices.Equal(c.Filters, other.Filters) && c.URL == other.URL
+}
+
+// Save writes the checkpoint atomically, so that an interrupted write doesn't lose the previous state
+func (c Checkpoint) Save(path string) error {
+	c.Updated = time.Now().UTC()
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+/*
+Progress tracks which rows are done while batches finish out of order
+and finds the last row up to which all rows are done.
+*/
+type Progress struct {
+	acknowledged int
+	done         map[int]bool // rows after acknowledged that are done
+}
+
+// NewProgress starts after the rows acknowledged by a previous run
+func NewProgress(acknowledged int) *Progress {
+	return &Progress{acknowledged: acknowledged, done: make(map[int]bool)}
+}
+
+// Done marks the rows of a batch and reports whether the acknowledged row moved
+func (p *Progress) Done(rows []Row) bool {
+	for _, row := range rows {
+		p.done[row.Number] = true
+	}
+	advanced := false
+	for p.done[p.acknowledged+1] {
+		delete(p.done, p.acknowledged+1)
+		p.acknowledged++
+		advanced = true
+	}
+	return advanced
+}
+
+func (p *Progress) Acknowledged() int {
+	return p.acknowledged
+}
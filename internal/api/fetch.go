@@ -0,0 +1,308 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-data-tool/internal/csv"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type PaginationType string
+
+const (
+	NoPagination     PaginationType = "none"
+	PagePagination   PaginationType = "page"   // page number in a query parameter
+	OffsetPagination PaginationType = "offset" // number of skipped items in a query parameter
+	CursorPagination PaginationType = "cursor" // cursor or next URL taken from the response
+	LinkPagination   PaginationType = "link"   // next URL in the Link header
+)
+
+// Pagination describes how the pages of a response are requested
+type Pagination struct {
+	Type       PaginationType
+	Param      string // query parameter of the page number, offset or cursor
+	SizeParam  string // query parameter of the page size, not sent if empty
+	Size       int    // number of items per page, 0 if the API decides
+	Start      int    // number of the first page or the first offset
+	CursorPath string // path of the next cursor or the next URL in the response
+	MaxPages   int    // maximum number of requested pages, 0 means no limit
+}
+
+// ParsePagination checks the pagination and fills in the default parameter names
+func ParsePagination(p Pagination) (Pagination, error) {
+	switch p.Type {
+	case NoPagination, LinkPagination:
+	case PagePagination:
+		if p.Param == "" {
+			p.Param = "page"
+		}
+	case OffsetPagination:
+		if p.Param == "" {
+			p.Param = "offset"
+		}
+		if p.Size <= 0 {
+			return p, errors.New("offset pagination requires the page size")
+		}
+	case CursorPagination:
+		if p.Param == "" {
+			p.Param = "cursor"
+		}
+		if p.CursorPath == "" {
+			return p, errors.New("cursor pagination requires the path of the cursor in the response")
+		}
+	default:
+		return p, fmt.Errorf("unknown pagination '%s'", p.Type)
+	}
+	if p.Size < 0 || p.MaxPages < 0 {
+		return p, errors.New("page size and number of pages must not be negative")
+	}
+	return p, nil
+}
+
+// FetcherConfig controls the requests of a Fetcher
+type FetcherConfig struct {
+	Header     http.Header
+	Auth       Authenticator // adds credentials to every attempt, none if nil
+	Pagination Pagination
+	DataPath   string        // path of the items in a page, the page itself if empty
+	RPS        float64       // maximum number of requests per second, 0 means no limit
+	Burst      int           // number of requests allowed at once above the rate
+	Timeout    time.Duration // timeout of a single request, 0 means no timeout
+	Retries    int           // number of retries of a failed request
+	Backoff    time.Duration // delay before the first retry
+	MaxBackoff time.Duration // maximum delay between retries
+}
+
+/*
+Fetcher requests the pages of a JSON API one after another and passes every item, flattened, to emit.
+Failed requests are retried like the ones of Sender.
+*/
+type Fetcher struct {
+	client  *http.Client
+	config  FetcherConfig
+	limiter *rateLimiter
+}
+
+func NewFetcher(client *http.Client, config FetcherConfig) *Fetcher {
+	return &Fetcher{client: client, config: config, limiter: newRateLimiter(config.RPS, config.Burst)}
+}
+
+// Fetch requests all pages starting from rawURL and returns the number of pages
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string, emit func([]csv.Field) error) (int, error) {
+	p := f.config.Pagination
+	next, err := url.Parse(rawURL)
+	if err != nil {
+		return 0, err
+	}
+	position := p.Start // page number or offset
+	if p.Type == PagePagination || p.Type == OffsetPagination {
+		next = withQuery(next, p.Param, strconv.Itoa(position))
+	}
+	if p.SizeParam != "" && p.Size > 0 {
+		next = withQuery(next, p.SizeParam, strconv.Itoa(p.Size))
+	}
+
+	pages := 0
+	for next != nil && (p.MaxPages == 0 || pages < p.MaxPages) {
+		current := next
+		body, header, err := f.get(ctx, current.String())
+		if err != nil {
+			return pages, fmt.Errorf("page %d: %w", pages+1, err)
+		}
+		pages++
+
+		document, err := parseJSON(body)
+		if err != nil {
+			return pages, fmt.Errorf("page %d: %w", pages, err)
+		}
+		data, err := JSONPath(document, f.config.DataPath)
+		if err != nil {
+			return pages, fmt.Errorf("page %d: %w", pages, err)
+		}
+		items, ok := data.([]any)
+		if !ok {
+			// A single object is a page with one item
+			items = []any{data}
+		}
+		for _, item := range items {
+			if err := emit(Flatten(item)); err != nil {
+				return pages, err
+			}
+		}
+
+		next = nil
+		switch p.Type {
+		case PagePagination, OffsetPagination:
+			if len(items) == 0 || (p.Size > 0 && len(items) < p.Size) {
+				break
+			}
+			if p.Type == PagePagination {
+				position++
+			} else {
+				position += len(items)
+			}
+			next = withQuery(current, p.Param, strconv.Itoa(position))
+		case CursorPagination:
+			next, err = cursorURL(current, document, p)
+		case LinkPagination:
+			next, err = linkURL(current, header.Values("Link"))
+		}
+		if err != nil {
+			return pages, fmt.Errorf("page %d: %w", pages, err)
+		}
+		// The same URL again would never end
+		if next != nil && next.String() == current.String() {
+			next = nil
+		}
+	}
+	return pages, nil
+}
+
+// get requests a page, retrying failed attempts
+func (f *Fetcher) get(ctx context.Context, rawURL string) ([]byte, http.Header, error) {
+	reauthorized := false
+	for attempt := 0; ; attempt++ {
+		var result Result
+		var retryAfter time.Duration
+		var body []byte
+		var header http.Header
+		if err := f.limiter.Wait(ctx); err != nil {
+			result.Err = err
+		} else {
+			body, header, result, retryAfter = f.attempt(ctx, rawURL)
+		}
+		if result.Err == nil {
+			return body, header, nil
+		}
+
+		if result.Status == http.StatusUnauthorized && !reauthorized {
+			if auth, ok := f.config.Auth.(invalidator); ok {
+				auth.Invalidate()
+				reauthorized = true
+				continue
+			}
+		}
+		if attempt >= f.config.Retries || !retryable(ctx, result) {
+			return nil, nil, result.Err
+		}
+
+		delay := retryAfter
+		if delay == 0 {
+			delay = backoff(attempt, f.config.Backoff, f.config.MaxBackoff)
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return nil, nil, errors.Join(result.Err, err)
+		}
+	}
+}
+
+// attempt makes a single request and returns the delay requested by the server through Retry-After
+func (f *Fetcher) attempt(ctx context.Context, rawURL string) ([]byte, http.Header, Result, time.Duration) {
+	var result Result
+	if f.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.config.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		result.Err = &requestError{err}
+		return nil, nil, result, 0
+	}
+	for name, values := range f.config.Header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json")
+	}
+	if f.config.Auth != nil {
+		if err := f.config.Auth.Authenticate(req); err != nil {
+			result.Err = err
+			return nil, nil, result, 0
+		}
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		result.Err = err
+		return nil, nil, result, 0
+	}
+	defer resp.Body.Close()
+
+	result.Status = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		response, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+		result.Err = fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(response)))
+		return nil, nil, result, parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		result.Err = err
+		return nil, nil, result, 0
+	}
+	return body, resp.Header, result, 0
+}
+
+// withQuery returns a copy of u with the query parameter set
+func withQuery(u *url.URL, name, value string) *url.URL {
+	next := *u
+	query := next.Query()
+	query.Set(name, value)
+	next.RawQuery = query.Encode()
+	return &next
+}
+
+// cursorURL returns the next page of cursor pagination, nil when the cursor is missing or empty
+func cursorURL(current *url.URL, document any, p Pagination) (*url.URL, error) {
+	cursor, err := JSONPath(document, p.CursorPath)
+	if err != nil {
+		// The last page may leave the cursor out
+		return nil, nil
+	}
+	value := scalarText(cursor)
+	switch {
+	case value == "":
+		return nil, nil
+	case strings.HasPrefix(value, "http://"), strings.HasPrefix(value, "https://"), strings.HasPrefix(value, "/"), strings.HasPrefix(value, "?"):
+		// Some APIs return the URL of the next page instead of a cursor
+		return current.Parse(value)
+	}
+	return withQuery(current, p.Param, value), nil
+}
+
+// linkURL returns the target of the Link header with rel="next" (RFC 8288), nil if there is none
+func linkURL(current *url.URL, links []string) (*url.URL, error) {
+	for _, header := range links {
+		for _, link := range strings.Split(header, ",") {
+			target, params, ok := strings.Cut(strings.TrimSpace(link), ";")
+			if !ok {
+				continue
+			}
+			target = strings.TrimSpace(target)
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, param := range strings.Split(params, ";") {
+				name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if !strings.EqualFold(strings.TrimSpace(name), "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(strings.TrimSpace(value), `"`)) {
+					if strings.EqualFold(rel, "next") {
+						return current.Parse(target[1 : len(target)-1])
+					}
+				}
+			}
+		}
+	}
+	return nil, nil
+}
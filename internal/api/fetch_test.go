@@ -0,0 +1,269 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"go-data-tool/internal/csv"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// pageServer answers with the pages written by page and keeps the requested URIs
+type pageServer struct {
+	mu   sync.Mutex
+	uris []string
+	page func(w http.ResponseWriter, req *http.Request)
+}
+
+func (s *pageServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	s.uris = append(s.uris, req.URL.RequestURI())
+	s.mu.Unlock()
+	s.page(w, req)
+}
+
+// items writes the ids from first to last as a JSON array
+func items(first, last int) string {
+	var ids []string
+	for id := first; id <= last; id++ {
+		ids = append(ids, fmt.Sprintf(`{"id":%d}`, id))
+	}
+	return "[" + strings.Join(ids, ",") + "]"
+}
+
+// fetch fetches the pages from the path of the server and returns the fetched ids
+func fetch(t *testing.T, server *httptest.Server, path string, config FetcherConfig) ([]string, int) {
+	t.Helper()
+	pagination, err := ParsePagination(config.Pagination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Pagination = pagination
+	var ids []string
+	pages, err := NewFetcher(server.Client(), config).Fetch(context.Background(), server.URL+path, func(fields []csv.Field) error {
+		for _, field := range fields {
+			if field.Name == "id" {
+				ids = append(ids, field.Value)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids, pages
+}
+
+// numberParam reads an int query parameter, fallback if it is missing
+func numberParam(req *http.Request, name string, fallback int) int {
+	if value, err := strconv.Atoi(req.URL.Query().Get(name)); err == nil {
+		return value
+	}
+	return fallback
+}
+
+func TestFetchPagePagination(t *testing.T) {
+	// Seven items in pages of the requested size
+	pages := &pageServer{page: func(w http.ResponseWriter, req *http.Request) {
+		page, size := numberParam(req, "p", 1), numberParam(req, "size", 3)
+		first := (page-1)*size + 1
+		fmt.Fprintf(w, `{"data":%s}`, items(first, min(first+size-1, 7)))
+	}}
+	server := httptest.NewServer(pages)
+	defer server.Close()
+
+	tests := []struct {
+		pagination Pagination
+		uris       []string
+	}{
+		// A short page is the last one
+		{Pagination{Type: PagePagination, Param: "p", SizeParam: "size", Size: 3, Start: 1},
+			[]string{"/items?p=1&size=3", "/items?p=2&size=3", "/items?p=3&size=3"}},
+		// Without the page size the pages go on until an empty one
+		{Pagination{Type: PagePagination, Param: "p", Start: 1},
+			[]string{"/items?p=1", "/items?p=2", "/items?p=3", "/items?p=4"}},
+		{Pagination{Type: PagePagination, Param: "p", SizeParam: "size", Size: 3, Start: 1, MaxPages: 2},
+			[]string{"/items?p=1&size=3", "/items?p=2&size=3"}},
+	}
+	for _, test := range tests {
+		pages.uris = nil
+		ids, n := fetch(t, server, "/items", FetcherConfig{Pagination: test.pagination, DataPath: "data"})
+		if !slices.Equal(pages.uris, test.uris) || n != len(test.uris) {
+			t.Errorf("%+v: requests %v, %d pages, want %v", test.pagination, pages.uris, n, test.uris)
+		}
+		want := []string{"1", "2", "3", "4", "5", "6", "7"}[:min(7, 3*len(test.uris))]
+		if !slices.Equal(ids, want) {
+			t.Errorf("%+v: ids %v, want %v", test.pagination, ids, want)
+		}
+	}
+}
+
+func TestFetchOffsetPagination(t *testing.T) {
+	pages := &pageServer{page: func(w http.ResponseWriter, req *http.Request) {
+		offset, limit := numberParam(req, "offset", 0), numberParam(req, "limit", 3)
+		fmt.Fprint(w, items(offset+1, min(offset+limit, 7)))
+	}}
+	server := httptest.NewServer(pages)
+	defer server.Close()
+
+	ids, n := fetch(t, server, "/items?sort=id", FetcherConfig{Pagination: Pagination{Type: OffsetPagination, SizeParam: "limit", Size: 3}})
+	if want := []string{"/items?limit=3&offset=0&sort=id", "/items?limit=3&offset=3&sort=id", "/items?limit=3&offset=6&sort=id"}; !slices.Equal(pages.uris, want) || n != 3 {
+		t.Errorf("requests %v, %d pages, want %v", pages.uris, n, want)
+	}
+	if want := []string{"1", "2", "3", "4", "5", "6", "7"}; !slices.Equal(ids, want) {
+		t.Errorf("ids %v, want %v", ids, want)
+	}
+}
+
+func TestFetchCursorPagination(t *testing.T) {
+	tests := []struct {
+		name string
+		next func(after int) string // next member of the page ending with the item after
+		uris []string
+	}{
+		{"cursor", func(after int) string { return fmt.Sprintf(`"c%d"`, after) },
+			[]string{"/items", "/items?cursor=c3", "/items?cursor=c6"}},
+		{"relative URL", func(after int) string { return fmt.Sprintf(`"/items?after=%d"`, after) },
+			[]string{"/items", "/items?after=3", "/items?after=6"}},
+		{"query", func(after int) string { return fmt.Sprintf(`"?after=%d"`, after) },
+			[]string{"/items", "/items?after=3", "/items?after=6"}},
+	}
+	for _, test := range tests {
+		pages := &pageServer{page: func(w http.ResponseWriter, req *http.Request) {
+			after := 0
+			for _, name := range []string{"cursor", "after"} {
+				if value := strings.TrimPrefix(req.URL.Query().Get(name), "c"); value != "" {
+					after, _ = strconv.Atoi(value)
+				}
+			}
+			// The last page has no next cursor
+			next := "null"
+			if after+3 < 7 {
+				next = test.next(after + 3)
+			}
+			fmt.Fprintf(w, `{"items":%s,"meta":{"next":%s}}`, items(after+1, min(after+3, 7)), next)
+		}}
+		server := httptest.NewServer(pages)
+		ids, n := fetch(t, server, "/items", FetcherConfig{
+			Pagination: Pagination{Type: CursorPagination, CursorPath: "meta.next"},
+			DataPath:   "items",
+		})
+		server.Close()
+		if !slices.Equal(pages.uris, test.uris) || n != 3 {
+			t.Errorf("%s: requests %v, %d pages, want %v", test.name, pages.uris, n, test.uris)
+		}
+		if want := []string{"1", "2", "3", "4", "5", "6", "7"}; !slices.Equal(ids, want) {
+			t.Errorf("%s: ids %v, want %v", test.name, ids, want)
+		}
+	}
+}
+
+func TestFetchLinkPagination(t *testing.T) {
+	// The next link is among links with other rels, once as one of several rels
+	pages := &pageServer{page: func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Query().Get("page") {
+		case "":
+			w.Header().Add("Link", `</items?page=3>; rel="last", </items?page=2>; rel="next"`)
+			fmt.Fprint(w, items(1, 2))
+		case "2":
+			w.Header().Add("Link", `</items>; rel="prev first"`)
+			w.Header().Add("Link", `<https://other.example>; title="next", </items?page=3>; type="application/json"; rel="last next"`)
+			fmt.Fprint(w, items(3, 4))
+		default:
+			w.Header().Add("Link", `</items?page=2>; rel=prev, </items>; rel=first`)
+			fmt.Fprint(w, items(5, 5))
+		}
+	}}
+	server := httptest.NewServer(pages)
+	defer server.Close()
+
+	ids, n := fetch(t, server, "/items", FetcherConfig{Pagination: Pagination{Type: LinkPagination}})
+	if want := []string{"/items", "/items?page=2", "/items?page=3"}; !slices.Equal(pages.uris, want) || n != 3 {
+		t.Errorf("requests %v, %d pages, want %v", pages.uris, n, want)
+	}
+	if want := []string{"1", "2", "3", "4", "5"}; !slices.Equal(ids, want) {
+		t.Errorf("ids %v, want %v", ids, want)
+	}
+}
+
+func TestFetchStopsOnRepeatedURL(t *testing.T) {
+	tests := []struct {
+		name       string
+		pagination Pagination
+		page       func(w http.ResponseWriter, req *http.Request)
+		uris       []string
+	}{
+		{"cursor", Pagination{Type: CursorPagination, CursorPath: "next"}, func(w http.ResponseWriter, req *http.Request) {
+			fmt.Fprint(w, `{"next":"same","id":1}`)
+		}, []string{"/items", "/items?cursor=same"}},
+		{"link", Pagination{Type: LinkPagination}, func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Link", `</items>; rel="next"`)
+			fmt.Fprint(w, items(1, 1))
+		}, []string{"/items"}},
+	}
+	for _, test := range tests {
+		pages := &pageServer{page: test.page}
+		server := httptest.NewServer(pages)
+		_, n := fetch(t, server, "/items", FetcherConfig{Pagination: test.pagination})
+		server.Close()
+		if !slices.Equal(pages.uris, test.uris) || n != len(test.uris) {
+			t.Errorf("%s: requests %v, %d pages, want %v", test.name, pages.uris, n, test.uris)
+		}
+	}
+}
+
+func TestParsePagination(t *testing.T) {
+	p, err := ParsePagination(Pagination{Type: CursorPagination, CursorPath: "next"})
+	if err != nil || p.Param != "cursor" {
+		t.Errorf("cursor pagination %+v, %v", p, err)
+	}
+	for _, p := range []Pagination{
+		{Type: "scroll"},
+		{Type: OffsetPagination},
+		{Type: CursorPagination},
+		{Type: PagePagination, MaxPages: -1},
+	} {
+		if _, err := ParsePagination(p); err == nil {
+			t.Errorf("%+v accepted", p)
+		}
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	document, err := parseJSON([]byte(`{"results":[{"rows":[
+		{"id":7,"address":{"zip":"0150","city":"Oslo","geo":{"lat":59.91,"lon":10.75}},"tags":["a","b"],"note":null,"active":true,"price":1.50},
+		"text"
+	]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := JSONPath(document, "$.results.0.rows")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Members keep the order of the document, nested ones are named by their path
+	var got []string
+	for _, field := range Flatten(rows.([]any)[0]) {
+		got = append(got, field.Name+"="+field.Value)
+	}
+	want := []string{"id=7", "address.zip=0150", "address.city=Oslo", "address.geo.lat=59.91", "address.geo.lon=10.75",
+		`tags=["a","b"]`, "note=", "active=true", "price=1.50"}
+	if !slices.Equal(got, want) {
+		t.Errorf("fields %q, want %q", got, want)
+	}
+	if fields := Flatten(rows.([]any)[1]); len(fields) != 1 || fields[0] != (csv.Field{Name: "value", Value: "text"}) {
+		t.Errorf("scalar item fields %v", fields)
+	}
+
+	for _, path := range []string{"results.1", "results.x", "results.0.rows.0.id.value", "data"} {
+		if value, err := JSONPath(document, path); err == nil {
+			t.Errorf("JSONPath(%s) = %v, want an error", path, value)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-data-tool/internal/csv"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/*
+EncodeRow turns a CSV record into a JSON object with the columns in scheme order.
+Numeric columns become JSON numbers and empty numeric values become null.
+*/
+func EncodeRow(record []string, scheme csv.Scheme) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, header := range scheme.Headers {
+		if i != 0 {
+			b.WriteByte(',')
+		}
+		name, err := json.Marshal(header)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(name)
+		b.WriteByte(':')
+
+		value, err := TypedValue(record[i], scheme.Columns[header].ColumnType)
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(data)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// TypedValue parses a raw value by the column type, empty values of non-string columns are nil
+func TypedValue(raw string, columnType csv.ColumnTypeInterface) (any, error) {
+	if raw == "" && columnType != csv.TypeString {
+		return nil, nil
+	}
+	return columnType.Parse(raw)
+}
+
+// joinJSON joins encoded values into a JSON array
+func joinJSON(values [][]byte) []byte {
+	var b bytes.Buffer
+	b.WriteByte('[')
+	for i, value := range values {
+		if i != 0 {
+			b.WriteByte(',')
+		}
+		b.Write(value)
+	}
+	b.WriteByte(']')
+	return b.Bytes()
+}
+
+// jsonField is a member of a JSON object decoded by decodeJSON
+type jsonField struct {
+	name  string
+	value any
+}
+
+// jsonObject keeps the members of a JSON object in document order, so that columns follow the API
+type jsonObject []jsonField
+
+/*
+decodeJSON decodes the next JSON value: objects become jsonObject, arrays []any,
+numbers json.Number with their original text, and strings, booleans and null the usual Go values.
+*/
+func decodeJSON(dec *json.Decoder) (any, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return token, nil
+	}
+
+	switch delim {
+	case '{':
+		object := jsonObject{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			object = append(object, jsonField{name: key.(string), value: value})
+		}
+		_, err = dec.Token()
+		return object, err
+	case '[':
+		array := []any{}
+		for dec.More() {
+			value, err := decodeJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		_, err = dec.Token()
+		return array, err
+	}
+	return nil, fmt.Errorf("unexpected %v", delim)
+}
+
+// parseJSON decodes a whole document with decodeJSON
+func parseJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	value, err := decodeJSON(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after the JSON value")
+	}
+	return value, nil
+}
+
+// encodeJSON encodes a value decoded by decodeJSON back to JSON
+func encodeJSON(value any) []byte {
+	switch value := value.(type) {
+	case jsonObject:
+		values := make([][]byte, len(value))
+		for i, field := range value {
+			name, _ := json.Marshal(field.name)
+			values[i] = append(append(name, ':'), encodeJSON(field.value)...)
+		}
+		object := joinJSON(values)
+		object[0], object[len(object)-1] = '{', '}'
+		return object
+	case []any:
+		values := make([][]byte, len(value))
+		for i, item := range value {
+			values[i] = encodeJSON(item)
+		}
+		return joinJSON(values)
+	}
+	data, _ := json.Marshal(value)
+	return data
+}
+
+/*
+JSONPath returns the value at a dot separated path like "data.items" or "$.results.0.rows",
+where numbers index arrays. An empty path or "$" is the document itself.
+*/
+func JSONPath(value any, path string) (any, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return value, nil
+	}
+
+	for _, step := range strings.Split(path, ".") {
+		switch current := value.(type) {
+		case jsonObject:
+			found := false
+			for _, field := range current {
+				if field.name == step {
+					value, found = field.value, true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("path '%s': no member '%s'", path, step)
+			}
+		case []any:
+			index, err := strconv.Atoi(step)
+			if err != nil || index < 0 || index >= len(current) {
+				return nil, fmt.Errorf("path '%s': no item '%s'", path, step)
+			}
+			value = current[index]
+		default:
+			return nil, fmt.Errorf("path '%s': '%s' of a value that is neither an object nor an array", path, step)
+		}
+	}
+	return value, nil
+}
+
+/*
+Flatten turns a JSON value into named fields: members of nested objects are named by their path
+joined with dots ("address.city"), arrays are kept as JSON text, null becomes an empty value.
+A value that isn't an object becomes a single field named "value".
+*/
+func Flatten(value any) []csv.Field {
+	if _, ok := value.(jsonObject); !ok {
+		return []csv.Field{{Name: "value", Value: scalarText(value)}}
+	}
+	var fields []csv.Field
+	flattenInto(&fields, "", value)
+	return fields
+}
+
+func flattenInto(fields *[]csv.Field, name string, value any) {
+	object, ok := value.(jsonObject)
+	if !ok {
+		*fields = append(*fields, csv.Field{Name: name, Value: scalarText(value)})
+		return
+	}
+	for _, field := range object {
+		fieldName := field.name
+		if name != "" {
+			fieldName = name + "." + field.name
+		}
+		flattenInto(fields, fieldName, field.value)
+	}
+}
+
+// scalarText is the CSV value of a JSON value
+func scalarText(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case bool:
+		return strconv.FormatBool(value)
+	}
+	return string(encodeJSON(value))
+}
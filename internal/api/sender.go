@@ -0,0 +1,324 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go-data-tool/internal/csv"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Maximum number of response bytes kept in a result
+const maxResponseBytes = 4096
+
+// Row of the input together with its number among the data rows, starting from 1
+type Row struct {
+	Number int
+	Record []string
+	JSON   []byte // the row encoded by EncodeRow
+}
+
+// Rows sent in a single request
+type Batch struct {
+	Number int // number of the batch, starting from 1
+	Rows   []Row
+}
+
+// Result of sending a batch
+type Result struct {
+	Batch    Batch
+	Status   int    // HTTP status of the last attempt, 0 if no response was received
+	Response []byte // beginning of the response body
+	Attempts int    // number of requests made
+	Err      error  // nil if the batch was accepted
+}
+
+// RequestBuilder creates the request for a batch
+type RequestBuilder interface {
+	Build(ctx context.Context, batch Batch) (*http.Request, error)
+}
+
+// SenderConfig controls batching and delivery of the rows
+type SenderConfig struct {
+	BatchSize  int           // maximum number of rows per request, 0 means no limit
+	BatchBytes int           // maximum size of the JSON rows per request, 0 means no limit
+	Workers    int           // number of concurrent requests
+	RPS        float64       // maximum number of requests per second, 0 means no limit
+	Burst      int           // number of requests allowed at once above the rate
+	Timeout    time.Duration // timeout of a single request, 0 means no timeout
+	Retries    int           // number of retries of a failed request
+	Backoff    time.Duration // delay before the first retry
+	MaxBackoff time.Duration // maximum delay between retries
+	Skip       int           // number of leading rows counted but not sent, e.g. acknowledged by a previous run
+	// Header carrying a key derived from the IdempotencyColumns of the rows, no header if either is empty
+	IdempotencyHeader  string
+	IdempotencyColumns []int
+	Auth               Authenticator // adds credentials to every attempt, none if nil
+}
+
+/*
+Sender collects rows into batches and sends every batch as one request.
+A batch is sent once it has BatchSize rows or once the next row would make it exceed BatchBytes.
+Batches are delivered by a pool of workers: requests are rate limited, and requests failing
+with 429, 5xx or a network error are retried with exponential backoff and jitter,
+waiting for Retry-After when the server sends it.
+Results are passed to onResult one at a time, in order of completion.
+*/
+type Sender struct {
+	client   *http.Client
+	builder  RequestBuilder
+	scheme   csv.Scheme
+	config   SenderConfig
+	limiter  *rateLimiter
+	onResult func(Result) error
+
+	batch Batch
+	bytes int // size of the rows in the current batch
+	rows  int // number of added rows
+
+	batches  chan Batch
+	workers  sync.WaitGroup
+	resultMu sync.Mutex
+	err      error // first error returned by onResult
+}
+
+func NewSender(client *http.Client, builder RequestBuilder, scheme csv.Scheme, config SenderConfig, onResult func(Result) error) *Sender {
+	if config.Workers < 1 {
+		config.Workers = 1
+	}
+	return &Sender{
+		client:   client,
+		builder:  builder,
+		scheme:   scheme,
+		config:   config,
+		limiter:  newRateLimiter(config.RPS, config.Burst),
+		onResult: onResult,
+		batch:    Batch{Number: 1},
+	}
+}
+
+func (s *Sender) Add(ctx context.Context, record []string) error {
+	if err := s.failure(ctx); err != nil {
+		return err
+	}
+
+	s.rows++
+	if s.rows <= s.config.Skip {
+		return nil
+	}
+	data, err := EncodeRow(record, s.scheme)
+	if err != nil {
+		return fmt.Errorf("row %d: %w", s.rows, err)
+	}
+
+	if s.config.BatchBytes > 0 && len(s.batch.Rows) != 0 && s.bytes+len(data) > s.config.BatchBytes {
+		if err := s.dispatch(ctx); err != nil {
+			return err
+		}
+	}
+	s.batch.Rows = append(s.batch.Rows, Row{Number: s.rows, Record: record, JSON: data})
+	s.bytes += len(data)
+
+	if s.config.BatchSize > 0 && len(s.batch.Rows) >= s.config.BatchSize {
+		return s.dispatch(ctx)
+	}
+	return nil
+}
+
+// Close sends the last batch and waits for all requests to finish
+func (s *Sender) Close(ctx context.Context) error {
+	err := s.failure(ctx)
+	if err == nil {
+		err = s.dispatch(ctx)
+	}
+	if s.batches != nil {
+		close(s.batches)
+		s.workers.Wait()
+		s.batches = nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.failure(ctx)
+}
+
+// failure returns the error that stops sending: a failed result handler or a cancelled context
+func (s *Sender) failure(ctx context.Context) error {
+	s.resultMu.Lock()
+	defer s.resultMu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	return ctx.Err()
+}
+
+// dispatch passes the current batch to the workers, starting them on the first batch
+func (s *Sender) dispatch(ctx context.Context) error {
+	if len(s.batch.Rows) == 0 {
+		return nil
+	}
+	batch := s.batch
+	s.batch = Batch{Number: batch.Number + 1}
+	s.bytes = 0
+
+	if s.batches == nil {
+		s.batches = make(chan Batch)
+		for range s.config.Workers {
+			s.workers.Add(1)
+			go s.work(ctx)
+		}
+	}
+
+	select {
+	case s.batches <- batch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Sender) work(ctx context.Context) {
+	defer s.workers.Done()
+	for batch := range s.batches {
+		// After a failure the remaining batches are dropped
+		if s.failure(ctx) != nil {
+			continue
+		}
+		result := s.deliver(ctx, batch)
+
+		s.resultMu.Lock()
+		if s.err == nil {
+			s.err = s.onResult(result)
+		}
+		s.resultMu.Unlock()
+	}
+}
+
+// deliver sends a batch, retrying failed attempts
+func (s *Sender) deliver(ctx context.Context, batch Batch) Result {
+	reauthorized := false
+	for attempt := 0; ; attempt++ {
+		var result Result
+		var retryAfter time.Duration
+		if err := s.limiter.Wait(ctx); err != nil {
+			result = Result{Batch: batch, Err: err}
+		} else {
+			result, retryAfter = s.send(ctx, batch)
+		}
+		result.Attempts = attempt + 1
+
+		// A rejected token is renewed and the request repeated once, regardless of the retries
+		if result.Status == http.StatusUnauthorized && !reauthorized {
+			if auth, ok := s.config.Auth.(invalidator); ok {
+				auth.Invalidate()
+				reauthorized = true
+				continue
+			}
+		}
+		if result.Err == nil || attempt >= s.config.Retries || !retryable(ctx, result) {
+			return result
+		}
+
+		delay := retryAfter
+		if delay == 0 {
+			delay = backoff(attempt, s.config.Backoff, s.config.MaxBackoff)
+		}
+		if err := sleep(ctx, delay); err != nil {
+			result.Err = errors.Join(result.Err, err)
+			return result
+		}
+	}
+}
+
+// retryable reports whether a failed attempt may succeed when repeated
+func retryable(ctx context.Context, result Result) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	switch {
+	case result.Status == 0:
+		// Network errors and timeouts, but not errors building the request
+		var requestErr *requestError
+		return !errors.As(result.Err, &requestErr)
+	case result.Status == http.StatusTooManyRequests, result.Status >= 500:
+		return true
+	}
+	return false
+}
+
+// requestError is an error building a request, which repeating doesn't fix
+type requestError struct {
+	err error
+}
+
+func (e *requestError) Error() string { return e.err.Error() }
+
+func (e *requestError) Unwrap() error { return e.err }
+
+// send makes a single attempt and returns the delay requested by the server through Retry-After
+func (s *Sender) send(ctx context.Context, batch Batch) (Result, time.Duration) {
+	result := Result{Batch: batch}
+
+	if s.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.config.Timeout)
+		defer cancel()
+	}
+
+	req, err := s.builder.Build(ctx, batch)
+	if err != nil {
+		result.Err = &requestError{err}
+		return result, 0
+	}
+	if s.config.IdempotencyHeader != "" && len(s.config.IdempotencyColumns) > 0 {
+		req.Header.Set(s.config.IdempotencyHeader, IdempotencyKey(batch, s.config.IdempotencyColumns))
+	}
+	if s.config.Auth != nil {
+		// Failing to get credentials, e.g. a token, is retried like a network error
+		if err := s.config.Auth.Authenticate(req); err != nil {
+			result.Err = err
+			return result, 0
+		}
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		result.Err = err
+		return result, 0
+	}
+	defer resp.Body.Close()
+
+	result.Status = resp.StatusCode
+	result.Response, err = io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		result.Err = err
+		return result, 0
+	}
+	// Drain the rest so that the connection can be reused
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		result.Err = fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return result, parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+}
+
+/*
+IdempotencyKey derives a key from the values of the key columns of all rows of a batch.
+The same rows always get the same key, across retries and across resumed runs.
+*/
+func IdempotencyKey(batch Batch, columns []int) string {
+	h := sha256.New()
+	for _, row := range batch.Rows {
+		for _, column := range columns {
+			value := row.Record[column]
+			fmt.Fprintf(h, "%d:%s", len(value), value)
+		}
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
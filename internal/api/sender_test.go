@@ -0,0 +1,269 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"go-data-tool/internal/csv"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testScheme = csv.Scheme{
+	Headers: []string{"id", "name"},
+	Columns: map[string]csv.ColumnInfo{
+		"id":   {Index: 0, ColumnType: csv.TypeInt},
+		"name": {Index: 1, ColumnType: csv.TypeString},
+	},
+}
+
+func testRows(n int) [][]string {
+	rows := make([][]string, n)
+	for i := range rows {
+		rows[i] = []string{strconv.Itoa(i + 1), "name " + strconv.Itoa(i+1)}
+	}
+	return rows
+}
+
+// recorder is a test server keeping the bodies and headers of the requests it got
+type recorder struct {
+	mu       sync.Mutex
+	bodies   []string
+	headers  []http.Header
+	response func(n int, w http.ResponseWriter) // answers the nth request, 200 if nil
+}
+
+func (r *recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	r.bodies = append(r.bodies, string(body))
+	r.headers = append(r.headers, req.Header.Clone())
+	n := len(r.bodies)
+	r.mu.Unlock()
+	if r.response != nil {
+		r.response(n, w)
+	}
+}
+
+// send sends the rows to the server and returns the results
+func send(t *testing.T, server *httptest.Server, rows [][]string, config SenderConfig) []Result {
+	t.Helper()
+	array := config.BatchSize != 1 || config.BatchBytes != 0
+	builder, err := NewTemplateBuilder(http.MethodPost, server.URL, nil, "", testScheme, array)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []Result
+	sender := NewSender(server.Client(), builder, testScheme, config, func(result Result) error {
+		results = append(results, result)
+		return nil
+	})
+	ctx := context.Background()
+	for _, row := range rows {
+		if err := sender.Add(ctx, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sender.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(results, func(a, b Result) int { return a.Batch.Number - b.Batch.Number })
+	return results
+}
+
+func TestSenderSingleRows(t *testing.T) {
+	rec := &recorder{}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	results := send(t, server, testRows(3), SenderConfig{BatchSize: 1, Workers: 2})
+	if len(results) != 3 {
+		t.Fatalf("%d results, want 3", len(results))
+	}
+	for _, result := range results {
+		if result.Err != nil || result.Status != http.StatusOK || result.Attempts != 1 {
+			t.Errorf("batch %d: status %d, attempts %d, error %v", result.Batch.Number, result.Status, result.Attempts, result.Err)
+		}
+	}
+	slices.Sort(rec.bodies)
+	want := []string{`{"id":1,"name":"name 1"}`, `{"id":2,"name":"name 2"}`, `{"id":3,"name":"name 3"}`}
+	if !slices.Equal(rec.bodies, want) {
+		t.Errorf("bodies = %q, want %q", rec.bodies, want)
+	}
+	if got := rec.headers[0].Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+}
+
+func TestSenderBatches(t *testing.T) {
+	rec := &recorder{}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	results := send(t, server, testRows(5), SenderConfig{BatchSize: 2})
+	var sizes []int
+	for _, result := range results {
+		sizes = append(sizes, len(result.Batch.Rows))
+	}
+	if !slices.Equal(sizes, []int{2, 2, 1}) {
+		t.Errorf("batch sizes = %v, want [2 2 1]", sizes)
+	}
+	var first []map[string]any
+	if err := json.Unmarshal([]byte(rec.bodies[0]), &first); err != nil {
+		t.Fatalf("batch body %s: %v", rec.bodies[0], err)
+	}
+	if len(first) != 2 || first[1]["id"] != 2.0 {
+		t.Errorf("first batch = %v", first)
+	}
+}
+
+func TestSenderBatchBytes(t *testing.T) {
+	server := httptest.NewServer(&recorder{})
+	defer server.Close()
+
+	// Every row is 24 bytes of JSON, so 50 bytes hold two rows
+	results := send(t, server, testRows(5), SenderConfig{BatchBytes: 50})
+	var sizes []int
+	for _, result := range results {
+		sizes = append(sizes, len(result.Batch.Rows))
+	}
+	if !slices.Equal(sizes, []int{2, 2, 1}) {
+		t.Errorf("batch sizes = %v, want [2 2 1]", sizes)
+	}
+}
+
+func TestSenderSkip(t *testing.T) {
+	rec := &recorder{}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	results := send(t, server, testRows(4), SenderConfig{BatchSize: 1, Skip: 2})
+	var numbers []int
+	for _, result := range results {
+		numbers = append(numbers, result.Batch.Rows[0].Number)
+	}
+	if !slices.Equal(numbers, []int{3, 4}) {
+		t.Errorf("sent rows %v, want [3 4]", numbers)
+	}
+}
+
+func TestSenderRetriesWithRetryAfter(t *testing.T) {
+	rec := &recorder{response: func(n int, w http.ResponseWriter) {
+		switch n {
+		case 1:
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	start := time.Now()
+	config := SenderConfig{
+		BatchSize: 1, Retries: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond,
+		IdempotencyHeader: "Idempotency-Key", IdempotencyColumns: []int{0},
+	}
+	results := send(t, server, testRows(1), config)
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, Retry-After asked for 1s", elapsed)
+	}
+	if result := results[0]; result.Err != nil || result.Attempts != 3 || result.Status != http.StatusOK {
+		t.Errorf("status %d, attempts %d, error %v", result.Status, result.Attempts, result.Err)
+	}
+	// Retries repeat the same idempotency key
+	key := rec.headers[0].Get("Idempotency-Key")
+	for _, header := range rec.headers {
+		if key == "" || header.Get("Idempotency-Key") != key {
+			t.Errorf("idempotency key %q, want %q on every attempt", header.Get("Idempotency-Key"), key)
+		}
+	}
+}
+
+func TestSenderGivesUp(t *testing.T) {
+	tests := []struct {
+		status   int
+		attempts int
+	}{
+		{http.StatusInternalServerError, 3}, // retried
+		{http.StatusBadRequest, 1},          // not retried
+	}
+	for _, test := range tests {
+		rec := &recorder{response: func(n int, w http.ResponseWriter) {
+			w.WriteHeader(test.status)
+			io.WriteString(w, `{"error":"nope"}`)
+		}}
+		server := httptest.NewServer(rec)
+		results := send(t, server, testRows(1), SenderConfig{BatchSize: 1, Retries: 2, Backoff: time.Millisecond})
+		server.Close()
+
+		result := results[0]
+		if result.Err == nil || result.Status != test.status || result.Attempts != test.attempts {
+			t.Errorf("status %d: got status %d, attempts %d, error %v, want %d attempts", test.status, result.Status, result.Attempts, result.Err, test.attempts)
+		}
+		if string(result.Response) != `{"error":"nope"}` {
+			t.Errorf("status %d: response %q", test.status, result.Response)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := map[string]time.Duration{
+		"":                              0,
+		"5":                             5 * time.Second,
+		"-1":                            0,
+		"Wed, 01 Jan 2025 12:00:30 GMT": 30 * time.Second,
+		"Wed, 01 Jan 2025 11:00:00 GMT": 0,
+		"soon":                          0,
+	}
+	for value, want := range tests {
+		if got := parseRetryAfter(value, now); got != want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestProgressOutOfOrder(t *testing.T) {
+	progress := NewProgress(2)
+	rows := func(numbers ...int) []Row {
+		var rows []Row
+		for _, n := range numbers {
+			rows = append(rows, Row{Number: n})
+		}
+		return rows
+	}
+	if progress.Done(rows(5, 6)) || progress.Acknowledged() != 2 {
+		t.Fatalf("acknowledged %d after a gap, want 2", progress.Acknowledged())
+	}
+	if !progress.Done(rows(3, 4)) || progress.Acknowledged() != 6 {
+		t.Fatalf("acknowledged %d, want 6", progress.Acknowledged())
+	}
+}
+
+func TestCheckpointSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	checkpoint := Checkpoint{Inputs: []string{"a.csv"}, Filters: []string{"id>1"}, URL: "http://example.com", Acknowledged: 42}
+	if err := checkpoint.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Acknowledged != 42 || !loaded.Matches(checkpoint) {
+		t.Errorf("loaded %+v", loaded)
+	}
+	other := checkpoint
+	other.URL = "http://example.org"
+	if loaded.Matches(other) {
+		t.Error("checkpoint matches another url")
+	}
+}
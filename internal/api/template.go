@@ -0,0 +1,252 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go-data-tool/internal/csv"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Layouts tried when a template function parses a date
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+	"02.01.2006",
+	"01/02/2006",
+}
+
+/*
+TemplateBuilder renders the URL, the headers and the body of a request with text/template.
+For single rows the template data is the row: {{.id}} is the value of the "id" column,
+typed according to the scheme. For batches the data has .Batch (the batch number) and .Rows.
+Without a body template the rows are sent as JSON: a single object per request, or an array for batches.
+*/
+type TemplateBuilder struct {
+	method string
+	url    *template.Template
+	header map[string][]*template.Template
+	body   *template.Template // nil for JSON bodies
+	scheme csv.Scheme
+	array  bool
+}
+
+func NewTemplateBuilder(method, rawURL string, header http.Header, body string, scheme csv.Scheme, array bool) (*TemplateBuilder, error) {
+	b := &TemplateBuilder{
+		method: method,
+		header: make(map[string][]*template.Template),
+		scheme: scheme,
+		array:  array,
+	}
+
+	var err error
+	b.url, err = parseTemplate("url", rawURL)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		for _, value := range values {
+			t, err := parseTemplate("header "+name, value)
+			if err != nil {
+				return nil, err
+			}
+			b.header[name] = append(b.header[name], t)
+		}
+	}
+	if body != "" {
+		b.body, err = parseTemplate("body", body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Option("missingkey=error").Funcs(TemplateFuncs()).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s template: %w", name, err)
+	}
+	return t, nil
+}
+
+func (b *TemplateBuilder) Build(ctx context.Context, batch Batch) (*http.Request, error) {
+	data, err := b.data(batch)
+	if err != nil {
+		return nil, err
+	}
+
+	rawURL, err := render(b.url, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var body []byte
+	switch {
+	case b.body != nil:
+		rendered, err := render(b.body, data)
+		if err != nil {
+			return nil, err
+		}
+		body = []byte(rendered)
+	case b.array:
+		values := make([][]byte, len(batch.Rows))
+		for i, row := range batch.Rows {
+			values[i] = row.JSON
+		}
+		body = joinJSON(values)
+	default:
+		body = batch.Rows[0].JSON
+	}
+
+	req, err := http.NewRequestWithContext(ctx, b.method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, templates := range b.header {
+		for _, t := range templates {
+			value, err := render(t, data)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Add(name, value)
+		}
+	}
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// data returns the template data of a batch: the row itself unless the rows are sent in batches
+func (b *TemplateBuilder) data(batch Batch) (any, error) {
+	if !b.array {
+		if len(batch.Rows) != 1 {
+			return nil, fmt.Errorf("batch of %d rows can't be sent as a single row", len(batch.Rows))
+		}
+		return RowData(batch.Rows[0].Record, b.scheme)
+	}
+
+	rows := make([]map[string]any, len(batch.Rows))
+	for i, row := range batch.Rows {
+		data, err := RowData(row.Record, b.scheme)
+		if err != nil {
+			return nil, err
+		}
+		rows[i] = data
+	}
+	return map[string]any{"Batch": batch.Number, "Rows": rows}, nil
+}
+
+// RowData maps the columns of a record to their values typed according to the scheme
+func RowData(record []string, scheme csv.Scheme) (map[string]any, error) {
+	data := make(map[string]any, len(scheme.Headers))
+	for i, header := range scheme.Headers {
+		value, err := TypedValue(record[i], scheme.Columns[header].ColumnType)
+		if err != nil {
+			return nil, fmt.Errorf("column '%s': %w", header, err)
+		}
+		data[header] = value
+	}
+	return data, nil
+}
+
+func render(t *template.Template, data any) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+/*
+TemplateFuncs returns the helper functions available in request templates:
+
+	json      - JSON encoding of a value, e.g. {"name": {{json .name}}}
+	path      - escaping for a URL path segment
+	query     - escaping for a URL query value
+	date      - reformatting of a date: {{date "02.01.2006" .created_at}}
+	now       - current time, formatted with date: {{date "2006-01-02" now}}
+	sha256, sha1, md5 - hex digest of a value
+	base64    - standard base64 encoding of a value
+	lower, upper, trim - string helpers
+	default   - a fallback for empty values: {{default "n/a" .comment}}
+*/
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"json": func(v any) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+		"path":  func(v any) string { return url.PathEscape(toString(v)) },
+		"query": func(v any) string { return url.QueryEscape(toString(v)) },
+		"date":  formatDate,
+		"now":   time.Now,
+		"sha256": func(v any) string {
+			sum := sha256.Sum256([]byte(toString(v)))
+			return hex.EncodeToString(sum[:])
+		},
+		"sha1": func(v any) string {
+			sum := sha1.Sum([]byte(toString(v)))
+			return hex.EncodeToString(sum[:])
+		},
+		"md5": func(v any) string {
+			sum := md5.Sum([]byte(toString(v)))
+			return hex.EncodeToString(sum[:])
+		},
+		"base64": func(v any) string { return base64.StdEncoding.EncodeToString([]byte(toString(v))) },
+		"lower":  func(v any) string { return strings.ToLower(toString(v)) },
+		"upper":  func(v any) string { return strings.ToUpper(toString(v)) },
+		"trim":   func(v any) string { return strings.TrimSpace(toString(v)) },
+		"default": func(fallback, v any) any {
+			if v == nil || toString(v) == "" {
+				return fallback
+			}
+			return v
+		},
+	}
+}
+
+// formatDate formats a time, a date string in one of the dateLayouts or unix seconds
+func formatDate(layout string, v any) (string, error) {
+	switch value := v.(type) {
+	case time.Time:
+		return value.Format(layout), nil
+	case int:
+		return time.Unix(int64(value), 0).UTC().Format(layout), nil
+	case nil:
+		return "", nil
+	}
+
+	s := toString(v)
+	for _, dateLayout := range dateLayouts {
+		if t, err := time.Parse(dateLayout, s); err == nil {
+			return t.Format(layout), nil
+		}
+	}
+	if seconds, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC().Format(layout), nil
+	}
+	return "", fmt.Errorf("can't parse date '%s'", s)
+}
+
+func toString(v any) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
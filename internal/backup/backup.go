@@ -0,0 +1,197 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// source is a file to back up
+type source struct {
+	abs  string // absolute path of the file
+	path string // path inside the backup
+	info fs.FileInfo
+}
+
+/*
+collectSources lists the regular files of the given paths. A file is backed up under its name,
+the files of a directory under the directory name followed by their relative path.
+*/
+func collectSources(paths []string) ([]source, error) {
+	var sources []source
+	seen := make(map[string]string)
+	add := func(abs, name string, info fs.FileInfo) error {
+		if other, ok := seen[name]; ok {
+			if other == abs {
+				return nil
+			}
+			return fmt.Errorf("'%s' and '%s' would both be backed up as '%s'", other, abs, name)
+		}
+		seen[name] = abs
+		sources = append(sources, source{abs: abs, path: name, info: info})
+		return nil
+	}
+
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(abs)
+		if err != nil {
+			return nil, err
+		}
+		base := filepath.Base(abs)
+
+		if !info.IsDir() {
+			if !info.Mode().IsRegular() {
+				return nil, fmt.Errorf("'%s' is not a regular file", p)
+			}
+			if err := add(abs, base, info); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		err = filepath.WalkDir(abs, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// Symbolic links, devices and the like are skipped
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(abs, file)
+			if err != nil {
+				return err
+			}
+			return add(file, path.Join(base, filepath.ToSlash(rel)), info)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	slices.SortFunc(sources, func(a, b source) int {
+		return strings.Compare(a.path, b.path)
+	})
+	return sources, nil
+}
+
+/*
+Create backs up the files and directories to a new snapshot in dest and returns its manifest.
+Files are cut into content-defined chunks stored once under their SHA-256, so that a snapshot
+only adds the chunks that changed since earlier ones.
+The manifest is written to a hidden directory first and renamed once all chunks are stored,
+so that an interrupted backup never looks like a valid snapshot.
+*/
+func (r *Repository) Create(paths []string, compression Compression, now time.Time) (Manifest, error) {
+	sources, err := collectSources(paths)
+	if err != nil {
+		return Manifest{}, err
+	}
+	if len(sources) == 0 {
+		return Manifest{}, errors.New("no files to back up")
+	}
+	if err := os.MkdirAll(r.Dest, 0o755); err != nil {
+		return Manifest{}, err
+	}
+	unlock, err := r.lock()
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer unlock()
+
+	manifest := Manifest{
+		Version:     manifestVersion,
+		Created:     now.UTC().Truncate(time.Second),
+		Compression: compression,
+		Files:       make([]Entry, 0, len(sources)),
+	}
+
+	tmp, err := os.MkdirTemp(r.Dest, ".snapshot-*")
+	if err != nil {
+		return manifest, err
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, src := range sources {
+		entry, added, err := r.store(src, compression)
+		if err != nil {
+			return manifest, fmt.Errorf("backing up '%s': %w", src.abs, err)
+		}
+		manifest.Files = append(manifest.Files, entry)
+		manifest.Added += added
+	}
+
+	// Snapshots made within the same second get a suffix
+	base := manifest.Created.Format(idLayout)
+	manifest.ID = base
+	for i := 2; ; i++ {
+		if _, err := os.Stat(filepath.Join(r.Dest, manifest.ID)); errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		manifest.ID = base + "-" + strconv.Itoa(i)
+	}
+	if err := r.writeManifest(tmp, manifest); err != nil {
+		return manifest, err
+	}
+	if err := os.Rename(tmp, filepath.Join(r.Dest, manifest.ID)); err != nil {
+		return manifest, err
+	}
+	return manifest, nil
+}
+
+// store saves the chunks of a file, hashing the whole content on the way, and returns the size of the new chunks
+func (r *Repository) store(src source, compression Compression) (Entry, int64, error) {
+	entry := Entry{
+		Path:    src.path,
+		Source:  src.abs,
+		Chunks:  []string{},
+		Mode:    src.info.Mode().Perm(),
+		ModTime: src.info.ModTime().UTC(),
+	}
+
+	in, err := os.Open(src.abs)
+	if err != nil {
+		return entry, 0, err
+	}
+	defer in.Close()
+
+	var added int64
+	hash := sha256.New()
+	chunks := newChunker(io.TeeReader(in, hash))
+	for {
+		chunk, err := chunks.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return entry, added, err
+		}
+		id := r.key.chunkID(chunk)
+		n, err := r.storeChunk(id, chunk, compression)
+		if err != nil {
+			return entry, added, err
+		}
+		added += n
+		entry.Chunks = append(entry.Chunks, id)
+		entry.Size += int64(len(chunk))
+	}
+	entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return entry, added, nil
+}
@@ -0,0 +1,73 @@
+package backup
+
+import (
+	"bufio"
+	"io"
+)
+
+// Chunk sizes of content-defined chunking
+const (
+	minChunkSize = 16 << 10
+	avgChunkBits = 16 // average chunk size is 2^16 = 64 KiB
+	maxChunkSize = 256 << 10
+)
+
+// A boundary is where the top avgChunkBits bits of the gear hash are zero
+const chunkMask = uint64(1<<avgChunkBits-1) << (64 - avgChunkBits)
+
+/*
+gearTable holds the random values of the gear rolling hash. It is generated from a fixed seed,
+and must never change: other values would cut the same data differently and defeat deduplication
+against existing backups.
+*/
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x6a09e667f3bcc908)
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+/*
+chunker cuts a stream into chunks at positions chosen by the content (content-defined chunking):
+a boundary depends only on the bytes shortly before it, so an insertion or deletion changes
+the chunks around it and the following chunks stay the same.
+*/
+type chunker struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func newChunker(r io.Reader) *chunker {
+	return &chunker{r: bufio.NewReaderSize(r, 64<<10), buf: make([]byte, 0, maxChunkSize)}
+}
+
+// Next returns the next chunk, valid until the following call, or io.EOF after the last one
+func (c *chunker) Next() ([]byte, error) {
+	c.buf = c.buf[:0]
+	var hash uint64
+	for len(c.buf) < maxChunkSize {
+		b, err := c.r.ReadByte()
+		if err == io.EOF {
+			if len(c.buf) == 0 {
+				return nil, io.EOF
+			}
+			return c.buf, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.buf = append(c.buf, b)
+		hash = hash<<1 + gearTable[b]
+		if len(c.buf) >= minChunkSize && hash&chunkMask == 0 {
+			break
+		}
+	}
+	return c.buf, nil
+}
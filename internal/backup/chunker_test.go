@@ -0,0 +1,108 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// cutChunks cuts the data and returns the chunks
+func cutChunks(t *testing.T, data []byte) [][]byte {
+	t.Helper()
+	c := newChunker(bytes.NewReader(data))
+	var chunks [][]byte
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			return chunks
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, bytes.Clone(chunk))
+	}
+}
+
+// randomData returns n bytes from a fixed seed
+func randomData(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(data)
+	return data
+}
+
+func TestChunkSizes(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"random", randomData(4 << 20)},
+		// Zeros never make a boundary, so the chunks are cut at the maximum size
+		{"zeros", make([]byte, 3*maxChunkSize+10)},
+		{"short", []byte("id,name\n1,Ann\n")},
+	}
+	for _, test := range tests {
+		chunks := cutChunks(t, test.data)
+		if !bytes.Equal(bytes.Join(chunks, nil), test.data) {
+			t.Errorf("%s: chunks don't make up the data", test.name)
+		}
+		for i, chunk := range chunks {
+			// Only the last chunk may be shorter than the minimum
+			if len(chunk) > maxChunkSize || len(chunk) < minChunkSize && i != len(chunks)-1 || len(chunk) == 0 {
+				t.Errorf("%s: chunk %d of %d has %d bytes", test.name, i, len(chunks), len(chunk))
+			}
+		}
+		if test.name == "zeros" && (len(chunks) != 4 || len(chunks[3]) != 10) {
+			t.Errorf("zeros: %d chunks", len(chunks))
+		}
+	}
+
+	if chunks := cutChunks(t, nil); len(chunks) != 0 {
+		t.Errorf("%d chunks of no data", len(chunks))
+	}
+}
+
+func TestChunksAfterInsertionStayTheSame(t *testing.T) {
+	digests := func(chunks [][]byte) []string {
+		var digests []string
+		for _, chunk := range chunks {
+			digest := sha256.Sum256(chunk)
+			digests = append(digests, hex.EncodeToString(digest[:]))
+		}
+		return digests
+	}
+	data := randomData(4 << 20)
+	original := digests(cutChunks(t, data))
+	if len(original) < 20 {
+		t.Fatalf("only %d chunks", len(original))
+	}
+
+	// A byte inserted near the start only changes the first chunk
+	changed := slices.Insert(slices.Clone(data), 100, 'x')
+	got := digests(cutChunks(t, changed))
+	if got[0] == original[0] {
+		t.Error("first chunk unchanged")
+	}
+	if !slices.Equal(got[1:], original[1:]) {
+		t.Errorf("chunks after the insertion changed, %d chunks, want %d", len(got), len(original))
+	}
+}
+
+func TestGearTableIsFixed(t *testing.T) {
+	// Other values would cut existing backups differently
+	want := map[int]uint64{
+		0:   0x1ac046dda8e86e2a,
+		1:   0xbe2c3b00b1d348c8,
+		2:   0x9b1a66a95412ff75,
+		3:   0xc448c2b1f05f7e4c,
+		255: 0x869756f713a06d5e,
+	}
+	for i, value := range want {
+		if gearTable[i] != value {
+			t.Errorf("gearTable[%d] = %#016x, want %#016x", i, gearTable[i], value)
+		}
+	}
+}
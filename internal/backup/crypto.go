@@ -0,0 +1,221 @@
+package backup
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Name of the key file of an encrypted destination
+const keyName = "key.json"
+
+// scrypt cost of new keys: 32 MiB of memory and about a tenth of a second
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// Minimum size of a key file, so that it can't be guessed like a passphrase
+const minKeyFileSize = 32
+
+// Version of new key files, version 1 derived the key from a key file with PBKDF2
+const keyVersion = 2
+
+// hkdfInfo binds keys derived from key files to their use
+var hkdfInfo = []byte("go-data-tool backup key")
+
+var ErrWrongKey = errors.New("wrong passphrase or key file")
+
+// Secret unlocks an encrypted destination: a passphrase or the content of a key file
+type Secret struct {
+	Passphrase []byte
+	KeyFile    []byte
+}
+
+func (s Secret) source() string {
+	if s.KeyFile != nil {
+		return "key-file"
+	}
+	return "passphrase"
+}
+
+/*
+keyParams is the content of the key file of a destination. The master key is random,
+sealed with a key derived from the secret: with scrypt from a passphrase,
+with HKDF-SHA256 from a key file, which has enough entropy to need no stretching.
+*/
+type keyParams struct {
+	Version int    `json:"version"`
+	Source  string `json:"source"` // passphrase or key-file
+	N       int    `json:"n,omitempty"`
+	R       int    `json:"r,omitempty"`
+	P       int    `json:"p,omitempty"`
+	Salt    []byte `json:"salt"`
+	Key     []byte `json:"key"` // sealed master key
+}
+
+/*
+Key encrypts chunks and manifests with AES-256-GCM and names chunks by an HMAC of their content,
+so that chunk names don't reveal whether a destination holds some known data.
+*/
+type Key struct {
+	aead cipher.AEAD
+	id   []byte
+}
+
+func newKey(master []byte) (*Key, error) {
+	aead, err := newAEAD(master[:32])
+	if err != nil {
+		return nil, err
+	}
+	return &Key{aead: aead, id: master[32:]}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// deriveKey derives the key sealing the master key from the secret
+func deriveKey(secret Secret, params keyParams) ([]byte, error) {
+	if params.Source != secret.source() {
+		return nil, fmt.Errorf("destination is encrypted with a %s", params.Source)
+	}
+	if params.Source == "key-file" {
+		if len(secret.KeyFile) < minKeyFileSize {
+			return nil, fmt.Errorf("key file must have at least %d bytes", minKeyFileSize)
+		}
+		if params.Version == 1 {
+			return pbkdf2.Key(secret.KeyFile, params.Salt, 1, 32, sha256.New), nil
+		}
+		key := make([]byte, 32)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, secret.KeyFile, params.Salt, hkdfInfo), key); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if len(secret.Passphrase) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	return scrypt.Key(secret.Passphrase, params.Salt, params.N, params.R, params.P, 32)
+}
+
+// createKey makes a new master key and saves it sealed with the secret
+func createKey(dest string, secret Secret) (*Key, error) {
+	params := keyParams{Version: keyVersion, Source: secret.source(), Salt: make([]byte, 32)}
+	if params.Source == "passphrase" {
+		params.N, params.R, params.P = scryptN, scryptR, scryptP
+	}
+	master := make([]byte, 64)
+	if _, err := rand.Read(params.Salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(master); err != nil {
+		return nil, err
+	}
+
+	kek, err := deriveKey(secret, params)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+	params.Key = seal(aead, master, []byte(keyName))
+
+	data, err := json.MarshalIndent(params, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	// Exclusive creation, so that two first backups can't make different keys
+	file, err := os.OpenFile(filepath.Join(dest, keyName), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+	return newKey(master)
+}
+
+// loadKey unseals the master key, a wrong secret gives ErrWrongKey
+func loadKey(dest string, secret Secret) (*Key, error) {
+	data, err := os.ReadFile(filepath.Join(dest, keyName))
+	if err != nil {
+		return nil, err
+	}
+	var params keyParams
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, fmt.Errorf("key file of '%s': %w", dest, err)
+	}
+	if params.Version < 1 || params.Version > keyVersion {
+		return nil, fmt.Errorf("key file of '%s' has unsupported version %d", dest, params.Version)
+	}
+
+	kek, err := deriveKey(secret, params)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+	master, err := open(aead, params.Key, []byte(keyName))
+	if err != nil || len(master) != 64 {
+		return nil, ErrWrongKey
+	}
+	return newKey(master)
+}
+
+// seal encrypts and authenticates data, the result starts with the random nonce
+func seal(aead cipher.AEAD, data, additional []byte) []byte {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	return aead.Seal(nonce, nonce, data, additional)
+}
+
+func open(aead cipher.AEAD, data, additional []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("message too short")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], additional)
+}
+
+// chunkID names a chunk: its SHA-256, or its HMAC-SHA256 when encrypted
+func (k *Key) chunkID(data []byte) string {
+	if k == nil {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, k.id)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
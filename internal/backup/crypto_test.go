@@ -0,0 +1,98 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// The passphrase key is the scrypt of RFC 7914, section 12, cut to 32 bytes
+func TestDeriveKeyPassphraseRFC7914(t *testing.T) {
+	tests := []struct {
+		passphrase, salt string
+		n, r, p          int
+		want             string
+	}{
+		{"password", "NaCl", 1024, 8, 16, "fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b373162"},
+		{"pleaseletmein", "SodiumChloride", 16384, 8, 1, "7023bdcb3afd7348461c06cd81fd38ebfda8fbba904f8e3ea9b543f6545da1f2"},
+	}
+	for _, test := range tests {
+		params := keyParams{Version: keyVersion, Source: "passphrase", N: test.n, R: test.r, P: test.p, Salt: []byte(test.salt)}
+		key, err := deriveKey(Secret{Passphrase: []byte(test.passphrase)}, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(key); got != test.want {
+			t.Errorf("scrypt(%q, %q) = %s, want %s", test.passphrase, test.salt, got, test.want)
+		}
+	}
+}
+
+// hkdfSHA256 is HKDF from RFC 5869 written out for one block of output, as an independent reference
+func hkdfSHA256(secret, salt, info []byte) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write(info)
+	expand.Write([]byte{1})
+	return expand.Sum(nil)
+}
+
+func TestHKDFReferenceRFC5869(t *testing.T) {
+	// Test case 1 of RFC 5869, first 32 bytes of the output
+	secret := bytes.Repeat([]byte{0x0b}, 22)
+	salt := mustHex(t, "000102030405060708090a0b0c")
+	info := mustHex(t, "f0f1f2f3f4f5f6f7f8f9")
+	want := "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf"
+	if got := hex.EncodeToString(hkdfSHA256(secret, salt, info)); got != want {
+		t.Errorf("HKDF = %s, want %s", got, want)
+	}
+}
+
+func TestDeriveKeyKeyFile(t *testing.T) {
+	secret := Secret{KeyFile: bytes.Repeat([]byte{0x42}, minKeyFileSize)}
+	salt := bytes.Repeat([]byte{7}, 32)
+
+	key, err := deriveKey(secret, keyParams{Version: keyVersion, Source: "key-file", Salt: salt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := hkdfSHA256(secret.KeyFile, salt, hkdfInfo); !bytes.Equal(key, want) {
+		t.Errorf("key = %x, want %x", key, want)
+	}
+
+	// Version 1 keys were derived with PBKDF2-HMAC-SHA256 and one iteration, which is a single HMAC block
+	legacy, err := deriveKey(secret, keyParams{Version: 1, Source: "key-file", Salt: salt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, secret.KeyFile)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	if want := mac.Sum(nil); !bytes.Equal(legacy, want) {
+		t.Errorf("version 1 key = %x, want %x", legacy, want)
+	}
+}
+
+func TestDeriveKeyRejectsWeakSecrets(t *testing.T) {
+	if _, err := deriveKey(Secret{KeyFile: []byte("short")}, keyParams{Version: keyVersion, Source: "key-file"}); err == nil {
+		t.Error("short key file accepted")
+	}
+	if _, err := deriveKey(Secret{Passphrase: []byte{}}, keyParams{Version: keyVersion, Source: "passphrase"}); err == nil {
+		t.Error("empty passphrase accepted")
+	}
+	if _, err := deriveKey(Secret{Passphrase: []byte("x")}, keyParams{Version: keyVersion, Source: "key-file"}); err == nil {
+		t.Error("passphrase accepted for a key file destination")
+	}
+}
@@ -0,0 +1,172 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Name of the manifest file in every snapshot directory, encrypted manifests have their own name
+const (
+	ManifestName          = "manifest.json"
+	encryptedManifestName = "manifest.enc"
+)
+
+/*
+Version of the manifest format:
+1 - every file is a copy in the snapshot directory
+2 - files are lists of content-addressed chunks shared by all snapshots
+*/
+const manifestVersion = 2
+
+// Layout of snapshot IDs: the creation time in UTC, usable as a directory name on every system
+const idLayout = "2006-01-02T15-04-05Z"
+
+type Compression string
+
+const (
+	NoCompression Compression = "none"
+	Gzip          Compression = "gzip"
+)
+
+func ParseCompression(s string) (Compression, error) {
+	switch c := Compression(s); c {
+	case NoCompression, Gzip:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown compression '%s', use none or gzip", s)
+}
+
+// Entry is a backed up file
+type Entry struct {
+	Path    string      `json:"path"`             // path inside the backup, with forward slashes
+	Source  string      `json:"source"`           // absolute path of the original file
+	Stored  string      `json:"stored,omitempty"` // path of the copy relative to the snapshot directory, version 1 only
+	Chunks  []string    `json:"chunks,omitempty"` // SHA-256 of the chunks of the content, in order
+	Size    int64       `json:"size"`             // size of the original file
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mod_time"`
+	SHA256  string      `json:"sha256"` // checksum of the original content
+}
+
+// Manifest describes a snapshot: one backup of a set of files
+type Manifest struct {
+	Version     int         `json:"version"`
+	ID          string      `json:"id"`
+	Created     time.Time   `json:"created"`
+	Compression Compression `json:"compression"`
+	Added       int64       `json:"added"` // size of the chunks stored by this snapshot, the rest were stored before
+	Files       []Entry     `json:"files"`
+}
+
+// Size returns the total size of the original files
+func (m Manifest) Size() int64 {
+	var size int64
+	for _, entry := range m.Files {
+		size += entry.Size
+	}
+	return size
+}
+
+// readManifest reads the manifest of a snapshot directory, decrypting it if needed
+func (r *Repository) readManifest(dir string) (Manifest, error) {
+	var manifest Manifest
+	data, err := os.ReadFile(filepath.Join(r.Dest, dir, r.manifestName()))
+	if err != nil {
+		return manifest, err
+	}
+	if r.key != nil {
+		// The directory name is authenticated too, so that snapshots can't be swapped
+		data, err = open(r.key.aead, data, []byte(dir))
+		if err != nil {
+			return manifest, fmt.Errorf("manifest of '%s' can't be decrypted: %w", dir, err)
+		}
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("manifest of '%s': %w", dir, err)
+	}
+	if manifest.Version > manifestVersion {
+		return manifest, fmt.Errorf("manifest of '%s' has unsupported version %d", dir, manifest.Version)
+	}
+	return manifest, nil
+}
+
+// writeManifest writes the manifest to a directory, which is renamed to the snapshot ID afterwards
+func (r *Repository) writeManifest(dir string, manifest Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if r.key != nil {
+		data = seal(r.key.aead, data, []byte(manifest.ID))
+	}
+	return writeFileSync(filepath.Join(dir, r.manifestName()), data)
+}
+
+// writeFileSync writes a file and flushes it to the disk
+func writeFileSync(path string, data []byte) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+/*
+Snapshots returns the manifests of all snapshots in the destination, oldest first.
+Directories without a manifest, e.g. unfinished snapshots, are skipped.
+*/
+func (r *Repository) Snapshots() ([]Manifest, error) {
+	dirs, err := os.ReadDir(r.Dest)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifests []Manifest
+	for _, dir := range dirs {
+		if !dir.IsDir() || strings.HasPrefix(dir.Name(), ".") {
+			continue
+		}
+		manifest, err := r.readManifest(dir.Name())
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, manifest)
+	}
+	slices.SortFunc(manifests, func(a, b Manifest) int {
+		if c := a.Created.Compare(b.Created); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return manifests, nil
+}
+
+// FindSnapshot returns the manifest of the snapshot with the given ID
+func (r *Repository) FindSnapshot(id string) (Manifest, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return Manifest{}, fmt.Errorf("invalid snapshot '%s'", id)
+	}
+	manifest, err := r.readManifest(id)
+	if errors.Is(err, fs.ErrNotExist) {
+		return manifest, fmt.Errorf("snapshot '%s' not found", id)
+	}
+	return manifest, err
+}
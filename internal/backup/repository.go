@@ -0,0 +1,87 @@
+package backup
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+Repository is a destination directory of backups. An encrypted destination has a key file,
+and all its chunks and manifests are encrypted with the key.
+*/
+type Repository struct {
+	Dest string
+	key  *Key // nil when the destination isn't encrypted
+}
+
+/*
+OpenRepository opens a destination directory. An encrypted destination needs the secret it was created with,
+a wrong one is detected here, before anything is read or written. With create, a secret makes a new
+destination encrypted; a destination already holding unencrypted backups can't become encrypted.
+*/
+func OpenRepository(dest string, secret *Secret, create bool) (*Repository, error) {
+	repo := &Repository{Dest: dest}
+	_, err := os.Stat(filepath.Join(dest, keyName))
+	encrypted := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	switch {
+	case encrypted && secret == nil:
+		return nil, errors.New("destination is encrypted, a passphrase or key file is required")
+	case encrypted:
+		repo.key, err = loadKey(dest, *secret)
+		return repo, err
+	case secret == nil:
+		return repo, nil
+	case !create:
+		return nil, errors.New("destination isn't encrypted")
+	}
+
+	used, err := repo.used()
+	if err != nil {
+		return nil, err
+	}
+	if used {
+		return nil, errors.New("destination holds unencrypted backups, encrypted ones need a new destination")
+	}
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		return nil, err
+	}
+	repo.key, err = createKey(dest, *secret)
+	return repo, err
+}
+
+// Encrypted reports whether the destination is encrypted
+func (r *Repository) Encrypted() bool {
+	return r.key != nil
+}
+
+// used reports whether the destination holds chunks or snapshots
+func (r *Repository) used() (bool, error) {
+	entries, err := os.ReadDir(r.Dest)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// manifestName is the name of the manifest files in the snapshot directories
+func (r *Repository) manifestName() string {
+	if r.key != nil {
+		return encryptedManifestName
+	}
+	return ManifestName
+}
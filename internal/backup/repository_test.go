@@ -0,0 +1,167 @@
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// listFiles returns the paths of all files and directories under dir
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func writeSources(t *testing.T) (string, map[string][]byte) {
+	t.Helper()
+	src := t.TempDir()
+	files := map[string][]byte{
+		"a.csv":     []byte("id,name\n1,Alice\n2,Bob\n"),
+		"sub/b.txt": bytes.Repeat([]byte("customer data "), 10000),
+	}
+	for name, data := range files {
+		path := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return src, files
+}
+
+func TestEncryptedBackupRoundTrip(t *testing.T) {
+	src, files := writeSources(t)
+	dest := t.TempDir()
+	secret := &Secret{Passphrase: []byte("correct horse battery staple")}
+
+	repo, err := OpenRepository(dest, secret, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !repo.Encrypted() {
+		t.Fatal("repository isn't encrypted")
+	}
+	manifest, err := repo.Create([]string{src}, Gzip, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The content must not be readable from the destination
+	for _, path := range listFiles(t, dest) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if bytes.Contains(data, []byte("Alice")) || bytes.Contains(data, []byte("customer data")) {
+			t.Errorf("%s holds plain text", path)
+		}
+	}
+
+	reopened, err := OpenRepository(dest, secret, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshots, err := reopened.Snapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 || snapshots[0].ID != manifest.ID {
+		t.Fatalf("snapshots = %v, want %s", snapshots, manifest.ID)
+	}
+	if problems := reopened.Verify(snapshots[0]); len(problems) != 0 {
+		t.Fatalf("verify: %v", problems)
+	}
+
+	to := t.TempDir()
+	result, err := reopened.Restore(snapshots[0], snapshots[0].Files, to, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Restored != len(files) {
+		t.Errorf("restored %d files, want %d", result.Restored, len(files))
+	}
+	for _, entry := range snapshots[0].Files {
+		data, err := os.ReadFile(filepath.Join(to, filepath.FromSlash(entry.Path)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel, err := filepath.Rel(src, entry.Source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, files[filepath.ToSlash(rel)]) {
+			t.Errorf("restored %s differs from the original", entry.Path)
+		}
+	}
+}
+
+func TestWrongSecretWritesNothing(t *testing.T) {
+	src, _ := writeSources(t)
+	dest := t.TempDir()
+	repo, err := OpenRepository(dest, &Secret{Passphrase: []byte("right")}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Create([]string{src}, NoCompression, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	before := listFiles(t, dest)
+
+	for _, create := range []bool{false, true} {
+		_, err := OpenRepository(dest, &Secret{Passphrase: []byte("wrong")}, create)
+		if !errors.Is(err, ErrWrongKey) {
+			t.Errorf("create=%v: error = %v, want ErrWrongKey", create, err)
+		}
+	}
+	if after := listFiles(t, dest); !slices.Equal(before, after) {
+		t.Errorf("destination changed:\nbefore %v\nafter  %v", before, after)
+	}
+
+	if _, err := OpenRepository(dest, nil, false); err == nil {
+		t.Error("encrypted destination opened without a secret")
+	}
+}
+
+func TestKeyFileBackupRoundTrip(t *testing.T) {
+	src, _ := writeSources(t)
+	dest := t.TempDir()
+	secret := &Secret{KeyFile: bytes.Repeat([]byte{0x5a}, minKeyFileSize)}
+	repo, err := OpenRepository(dest, secret, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := repo.Create([]string{src}, NoCompression, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenRepository(dest, secret, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := reopened.Verify(manifest); len(problems) != 0 {
+		t.Fatalf("verify: %v", problems)
+	}
+	other := &Secret{KeyFile: bytes.Repeat([]byte{0x5b}, minKeyFileSize)}
+	if _, err := OpenRepository(dest, other, false); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("error = %v, want ErrWrongKey", err)
+	}
+}
@@ -0,0 +1,208 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Layouts accepted by ParseTime, from the most to the least precise
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ParseTime parses a point in time like "2025-06-01T12:00", times without a zone are in loc
+func ParseTime(s string, loc *time.Location) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("time '%s' is not in the format 2006-01-02T15:04:05", s)
+}
+
+// SnapshotAt returns the newest snapshot created at or before the given time
+func SnapshotAt(manifests []Manifest, at time.Time) (Manifest, error) {
+	var found *Manifest
+	for i := range manifests {
+		if manifests[i].Created.After(at) {
+			continue
+		}
+		if found == nil || !manifests[i].Created.Before(found.Created) {
+			found = &manifests[i]
+		}
+	}
+	if found == nil {
+		return Manifest{}, fmt.Errorf("no snapshot was created before %s", at.Format(time.RFC3339))
+	}
+	return *found, nil
+}
+
+/*
+SelectEntries returns the files of a snapshot with the given paths, where a directory path
+selects all files under it. No paths select the whole snapshot.
+*/
+func SelectEntries(manifest Manifest, paths []string) ([]Entry, error) {
+	if len(paths) == 0 {
+		return manifest.Files, nil
+	}
+
+	var entries []Entry
+	selected := make(map[string]bool)
+	for _, p := range paths {
+		p = strings.Trim(path.Clean(filepath.ToSlash(p)), "/")
+		matched := false
+		for _, entry := range manifest.Files {
+			if entry.Path != p && !strings.HasPrefix(entry.Path, p+"/") {
+				continue
+			}
+			matched = true
+			if !selected[entry.Path] {
+				selected[entry.Path] = true
+				entries = append(entries, entry)
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("'%s' is not in snapshot %s", p, manifest.ID)
+		}
+	}
+	return entries, nil
+}
+
+// ConflictError lists existing files that differ from the backup
+type ConflictError struct {
+	Paths []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%d file(s) differ from the backup and would be overwritten: %s", len(e.Paths), strings.Join(e.Paths, ", "))
+}
+
+// RestoreResult counts the files of a restore
+type RestoreResult struct {
+	Restored  int // files written
+	Unchanged int // files already equal to the backup
+}
+
+/*
+Restore writes the files of a snapshot to the directory to, under their paths in the backup.
+All copies are verified before anything is written. Existing files equal to the backup are left alone,
+files that differ are overwritten only with force, otherwise a *ConflictError is returned.
+Every file is written to a temporary file first and renamed over the target once its checksum matches.
+*/
+func (r *Repository) Restore(manifest Manifest, entries []Entry, to string, force bool) (RestoreResult, error) {
+	var result RestoreResult
+
+	for _, entry := range entries {
+		if !filepath.IsLocal(filepath.FromSlash(entry.Path)) {
+			return result, fmt.Errorf("unsafe path '%s' in snapshot %s", entry.Path, manifest.ID)
+		}
+		if err := r.verifyEntry(manifest, entry); err != nil {
+			return result, fmt.Errorf("copy of '%s' in snapshot %s: %w", entry.Path, manifest.ID, err)
+		}
+	}
+
+	// Existing files are compared by checksum before anything is overwritten
+	var pending []Entry
+	var conflicts []string
+	for _, entry := range entries {
+		target := filepath.Join(to, filepath.FromSlash(entry.Path))
+		sum, err := fileChecksum(target)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			pending = append(pending, entry)
+		case err != nil:
+			return result, err
+		case sum == entry.SHA256:
+			result.Unchanged++
+		default:
+			conflicts = append(conflicts, entry.Path)
+			pending = append(pending, entry)
+		}
+	}
+	if len(conflicts) != 0 && !force {
+		return result, &ConflictError{Paths: conflicts}
+	}
+
+	for _, entry := range pending {
+		if err := r.restoreEntry(manifest, entry, filepath.Join(to, filepath.FromSlash(entry.Path))); err != nil {
+			return result, fmt.Errorf("restoring '%s': %w", entry.Path, err)
+		}
+		result.Restored++
+	}
+	return result, nil
+}
+
+// fileChecksum returns the SHA-256 of a regular file
+func fileChecksum(name string) (string, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("'%s' is not a regular file", name)
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (r *Repository) restoreEntry(manifest Manifest, entry Entry, target string) error {
+	reader, err := r.openEntry(manifest, entry)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hash), reader); err != nil {
+		return err
+	}
+	// The copy could have changed since it was verified
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != entry.SHA256 {
+		return fmt.Errorf("checksum is %s, expected %s", sum, entry.SHA256)
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), entry.Mode); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp.Name(), entry.ModTime, entry.ModTime); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
@@ -0,0 +1,194 @@
+package backup
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotAt(t *testing.T) {
+	first := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	manifests := []Manifest{
+		{ID: "second", Created: first.Add(24 * time.Hour)},
+		{ID: "first", Created: first},
+		{ID: "third", Created: first.Add(48 * time.Hour)},
+	}
+	tests := []struct {
+		at   time.Time
+		want string
+	}{
+		{first, "first"}, // a snapshot created exactly at the time counts
+		{first.Add(24*time.Hour - time.Second), "first"},
+		{first.Add(24 * time.Hour), "second"},
+		{first.Add(24 * time.Hour).In(time.FixedZone("UTC+2", 2*60*60)), "second"},
+		{first.Add(365 * 24 * time.Hour), "third"},
+	}
+	for _, test := range tests {
+		manifest, err := SnapshotAt(manifests, test.at)
+		if err != nil || manifest.ID != test.want {
+			t.Errorf("SnapshotAt(%s) = %s, %v, want %s", test.at, manifest.ID, err, test.want)
+		}
+	}
+
+	if manifest, err := SnapshotAt(manifests, first.Add(-time.Second)); err == nil {
+		t.Errorf("snapshot %s found before the first one", manifest.ID)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	loc := time.FixedZone("UTC+3", 3*60*60)
+	tests := []struct {
+		text string
+		want time.Time
+	}{
+		{"2025-06-01", time.Date(2025, 6, 1, 0, 0, 0, 0, loc)},
+		{"2025-06-01 12:30", time.Date(2025, 6, 1, 12, 30, 0, 0, loc)},
+		{"2025-06-01T12:30:15", time.Date(2025, 6, 1, 12, 30, 15, 0, loc)},
+		{"2025-06-01T12:30:15Z", time.Date(2025, 6, 1, 12, 30, 15, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		if got, err := ParseTime(test.text, loc); err != nil || !got.Equal(test.want) {
+			t.Errorf("ParseTime(%s) = %s, %v, want %s", test.text, got, err, test.want)
+		}
+	}
+	if _, err := ParseTime("01.06.2025", loc); err == nil {
+		t.Error("01.06.2025 accepted")
+	}
+}
+
+func TestSelectEntries(t *testing.T) {
+	manifest := Manifest{ID: "s1", Files: []Entry{{Path: "data/a.csv"}, {Path: "data/sub/b.csv"}, {Path: "data2/c.csv"}, {Path: "d.txt"}}}
+	tests := []struct {
+		paths []string
+		want  []string
+	}{
+		{nil, []string{"data/a.csv", "data/sub/b.csv", "data2/c.csv", "d.txt"}},
+		// A directory doesn't select other directories starting with its name
+		{[]string{"data"}, []string{"data/a.csv", "data/sub/b.csv"}},
+		{[]string{"./data/sub/", "data"}, []string{"data/sub/b.csv", "data/a.csv"}},
+		{[]string{"d.txt", "/d.txt"}, []string{"d.txt"}},
+	}
+	for _, test := range tests {
+		entries, err := SelectEntries(manifest, test.paths)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, entry := range entries {
+			got = append(got, entry.Path)
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("SelectEntries(%q) = %q, want %q", test.paths, got, test.want)
+		}
+	}
+
+	for _, path := range []string{"dat", "data/a", "e.txt"} {
+		if _, err := SelectEntries(manifest, []string{path}); err == nil {
+			t.Errorf("%s selected", path)
+		}
+	}
+}
+
+// restoredFiles returns the backed up files and their paths under the restore directory
+func restoredFiles(t *testing.T, manifest Manifest, src, to string, files map[string][]byte) map[string][]byte {
+	t.Helper()
+	targets := make(map[string][]byte)
+	for _, entry := range manifest.Files {
+		rel, err := filepath.Rel(src, entry.Source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		targets[filepath.Join(to, filepath.FromSlash(entry.Path))] = files[filepath.ToSlash(rel)]
+	}
+	return targets
+}
+
+func TestRestoreConflicts(t *testing.T) {
+	src, files := writeSources(t)
+	repo, err := OpenRepository(t.TempDir(), nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := repo.Create([]string{src}, Gzip, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := t.TempDir()
+	targets := restoredFiles(t, manifest, src, to, files)
+
+	if result, err := repo.Restore(manifest, manifest.Files, to, false); err != nil || result.Restored != 2 || result.Unchanged != 0 {
+		t.Fatalf("first restore = %+v, %v", result, err)
+	}
+	// Equal files are left alone
+	if result, err := repo.Restore(manifest, manifest.Files, to, false); err != nil || result.Restored != 0 || result.Unchanged != 2 {
+		t.Errorf("restore over equal files = %+v, %v", result, err)
+	}
+
+	// One file differs and the other one is missing, without force nothing is written
+	var changed, missing string
+	for target := range targets {
+		if strings.HasSuffix(target, "a.csv") {
+			changed = target
+		} else {
+			missing = target
+		}
+	}
+	if err := os.WriteFile(changed, []byte("id,name\n1,Mallory\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(missing); err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.Restore(manifest, manifest.Files, to, false)
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || len(conflict.Paths) != 1 || !strings.HasSuffix(conflict.Paths[0], "a.csv") {
+		t.Fatalf("error %v, want a conflict of a.csv", err)
+	}
+	if data, _ := os.ReadFile(changed); string(data) != "id,name\n1,Mallory\n" {
+		t.Errorf("conflicting file overwritten with %q", data)
+	}
+	if _, err := os.Stat(missing); err == nil {
+		t.Error("missing file restored despite the conflict")
+	}
+
+	// With force both are restored
+	if result, err := repo.Restore(manifest, manifest.Files, to, true); err != nil || result.Restored != 2 {
+		t.Fatalf("forced restore = %+v, %v", result, err)
+	}
+	for target, want := range targets {
+		if data, err := os.ReadFile(target); err != nil || string(data) != string(want) {
+			t.Errorf("%s differs from the backup after the forced restore", target)
+		}
+	}
+}
+
+func TestRestoreRejectsUnsafePaths(t *testing.T) {
+	src, _ := writeSources(t)
+	repo, err := OpenRepository(t.TempDir(), nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := repo.Create([]string{src}, NoCompression, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parent := t.TempDir()
+	to := filepath.Join(parent, "to")
+	for _, path := range []string{"../x", "a/../../x", "/etc/x"} {
+		entries := slices.Clone(manifest.Files)
+		entries[1].Path = path
+		_, err := repo.Restore(manifest, entries, to, true)
+		if err == nil || !strings.Contains(err.Error(), "unsafe path") {
+			t.Errorf("%s: error %v, want an unsafe path", path, err)
+		}
+	}
+	// Nothing is written, not even the safe entry
+	if files := listFiles(t, parent); len(files) != 1 {
+		t.Errorf("files written: %v", files[1:])
+	}
+}
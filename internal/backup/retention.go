@@ -0,0 +1,145 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+/*
+Retention tells which snapshots to keep: the Last newest ones, and the newest snapshot
+of each of the Daily last days, Weekly last weeks, Monthly last months and Yearly last years
+that have snapshots. A snapshot kept by several rules counts for all of them.
+Periods follow the creation time from the manifest, not the file times of the snapshot directory.
+*/
+type Retention struct {
+	Last    int
+	Daily   int
+	Weekly  int
+	Monthly int
+	Yearly  int
+}
+
+func (r Retention) Empty() bool {
+	return r.Last == 0 && r.Daily == 0 && r.Weekly == 0 && r.Monthly == 0 && r.Yearly == 0
+}
+
+// Decision is the fate of a snapshot under a retention policy
+type Decision struct {
+	Manifest Manifest
+	Keep     bool
+	Reasons  []string // rules keeping the snapshot
+	Removed  bool     // the snapshot was removed, false for dry runs and snapshots left by a failure
+}
+
+// retentionRule keeps the newest snapshot of each of the count last periods
+type retentionRule struct {
+	name   string
+	count  int
+	period func(t time.Time) string
+}
+
+/*
+Apply decides about every snapshot, newest first. Periods are calendar days, ISO weeks,
+months and years in the given location.
+*/
+func (r Retention) Apply(manifests []Manifest, loc *time.Location) []Decision {
+	decisions := make([]Decision, len(manifests))
+	for i, manifest := range manifests {
+		decisions[i] = Decision{Manifest: manifest}
+	}
+	slices.SortStableFunc(decisions, func(a, b Decision) int {
+		return b.Manifest.Created.Compare(a.Manifest.Created)
+	})
+
+	rules := []retentionRule{
+		{"last", r.Last, nil},
+		{"daily", r.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", r.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{"monthly", r.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{"yearly", r.Yearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+	for _, rule := range rules {
+		kept := 0
+		last := ""
+		for i := range decisions {
+			if kept >= rule.count {
+				break
+			}
+			if rule.period != nil {
+				period := rule.period(decisions[i].Manifest.Created.In(loc))
+				// Only the newest snapshot of a period counts
+				if period == last {
+					continue
+				}
+				last = period
+			}
+			decisions[i].Keep = true
+			decisions[i].Reasons = append(decisions[i].Reasons, rule.name)
+			kept++
+		}
+	}
+	return decisions
+}
+
+/*
+RemoveSnapshot deletes a snapshot. The directory is renamed first, so that a partially
+deleted snapshot is never mistaken for a valid one.
+*/
+func (r *Repository) RemoveSnapshot(manifest Manifest) error {
+	dir := filepath.Join(r.Dest, manifest.ID)
+	trash := filepath.Join(r.Dest, ".removing-"+manifest.ID)
+	if err := os.Rename(dir, trash); err != nil {
+		return err
+	}
+	return os.RemoveAll(trash)
+}
+
+/*
+Prune removes the snapshots not kept by the retention policy and then the chunks only they referenced.
+It returns the decisions and the chunks that were, or with dryRun would be, removed.
+When removing a snapshot fails, the decisions are returned with the error and tell which snapshots were removed.
+*/
+func (r *Repository) Prune(retention Retention, loc *time.Location, dryRun bool) ([]Decision, GCResult, error) {
+	if retention.Empty() {
+		return nil, GCResult{}, errors.New("retention policy would remove all snapshots")
+	}
+	unlock, err := r.lock()
+	if err != nil {
+		return nil, GCResult{}, err
+	}
+	defer unlock()
+
+	manifests, err := r.Snapshots()
+	if err != nil {
+		return nil, GCResult{}, err
+	}
+	decisions := retention.Apply(manifests, loc)
+
+	var kept []Manifest
+	for i, decision := range decisions {
+		if decision.Keep {
+			kept = append(kept, decision.Manifest)
+			continue
+		}
+		if dryRun {
+			continue
+		}
+		if err := r.RemoveSnapshot(decision.Manifest); err != nil {
+			return decisions, GCResult{}, fmt.Errorf("removing snapshot %s: %w", decision.Manifest.ID, err)
+		}
+		decisions[i].Removed = true
+	}
+
+	gc, err := r.collectGarbage(kept, dryRun)
+	if err != nil {
+		return decisions, gc, fmt.Errorf("removing unused chunks: %w", err)
+	}
+	return decisions, gc, nil
+}
@@ -0,0 +1,147 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// snapshotsAt returns manifests created at the UTC times like "2025-01-31 12:00", the times are the IDs
+func snapshotsAt(t *testing.T, times ...string) []Manifest {
+	t.Helper()
+	manifests := make([]Manifest, len(times))
+	for i, text := range times {
+		created, err := time.Parse("2006-01-02 15:04", text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifests[i] = Manifest{ID: text, Created: created}
+	}
+	return manifests
+}
+
+func TestRetentionApply(t *testing.T) {
+	utcPlus1 := time.FixedZone("UTC+1", 60*60)
+	tests := []struct {
+		name      string
+		retention Retention
+		loc       *time.Location
+		snapshots []string
+		want      map[string]string // rules keeping the snapshots, the others are removed
+	}{
+		{"last", Retention{Last: 2}, time.UTC,
+			[]string{"2025-03-10 08:00", "2025-03-12 10:00", "2025-03-10 20:00", "2025-03-11 09:00"},
+			map[string]string{"2025-03-12 10:00": "last", "2025-03-11 09:00": "last"}},
+		// The newest snapshot of a day is kept
+		{"daily", Retention{Daily: 3}, time.UTC,
+			[]string{"2025-03-10 08:00", "2025-03-12 10:00", "2025-03-10 20:00", "2025-03-11 09:00", "2025-03-09 12:00"},
+			map[string]string{"2025-03-12 10:00": "daily", "2025-03-11 09:00": "daily", "2025-03-10 20:00": "daily"}},
+		// Monday 2024-12-30 is in 2025-W01 and Sunday 2024-12-29 in 2024-W52
+		{"weekly", Retention{Weekly: 3}, time.UTC,
+			[]string{"2024-12-22 10:00", "2024-12-23 10:00", "2024-12-29 10:00", "2024-12-30 10:00", "2025-01-03 10:00"},
+			map[string]string{"2025-01-03 10:00": "weekly", "2024-12-29 10:00": "weekly", "2024-12-22 10:00": "weekly"}},
+		// Sunday 2026-01-04 still is in 2026-W01, Monday 2026-01-05 in 2026-W02
+		{"weekly year start", Retention{Weekly: 2}, time.UTC,
+			[]string{"2025-12-29 10:00", "2026-01-04 10:00", "2026-01-05 10:00"},
+			map[string]string{"2026-01-05 10:00": "weekly", "2026-01-04 10:00": "weekly"}},
+		{"monthly", Retention{Monthly: 2}, time.UTC,
+			[]string{"2025-01-31 23:00", "2025-02-01 00:00", "2025-02-28 23:59", "2025-03-01 00:00"},
+			map[string]string{"2025-03-01 00:00": "monthly", "2025-02-28 23:59": "monthly"}},
+		{"yearly", Retention{Yearly: 5}, time.UTC,
+			[]string{"2023-06-01 10:00", "2024-01-01 00:00", "2024-12-31 23:59"},
+			map[string]string{"2024-12-31 23:59": "yearly", "2023-06-01 10:00": "yearly"}},
+		// A snapshot kept by several rules records all of them
+		{"overlapping", Retention{Last: 2, Daily: 2, Weekly: 1, Monthly: 3}, time.UTC,
+			[]string{"2025-02-20 10:00", "2025-03-03 10:00", "2025-03-04 08:00", "2025-03-04 10:00"},
+			map[string]string{
+				"2025-03-04 10:00": "last,daily,weekly,monthly",
+				"2025-03-04 08:00": "last",
+				"2025-03-03 10:00": "daily",
+				"2025-02-20 10:00": "monthly",
+			}},
+		// 23:30 UTC is the next day in UTC+1
+		{"days in UTC", Retention{Daily: 2}, time.UTC,
+			[]string{"2025-03-09 12:00", "2025-03-10 22:00", "2025-03-10 23:30"},
+			map[string]string{"2025-03-10 23:30": "daily", "2025-03-09 12:00": "daily"}},
+		{"days in UTC+1", Retention{Daily: 2}, utcPlus1,
+			[]string{"2025-03-09 12:00", "2025-03-10 22:00", "2025-03-10 23:30"},
+			map[string]string{"2025-03-10 23:30": "daily", "2025-03-10 22:00": "daily"}},
+		// Sunday 23:30 UTC is Monday in UTC+1, a new ISO week
+		{"weeks in UTC+1", Retention{Weekly: 2}, utcPlus1,
+			[]string{"2025-01-04 10:00", "2025-01-05 22:00", "2025-01-05 23:30"},
+			map[string]string{"2025-01-05 23:30": "weekly", "2025-01-05 22:00": "weekly"}},
+		{"months in UTC+1", Retention{Monthly: 2}, utcPlus1,
+			[]string{"2025-01-15 10:00", "2025-01-31 22:00", "2025-01-31 23:30"},
+			map[string]string{"2025-01-31 23:30": "monthly", "2025-01-31 22:00": "monthly"}},
+	}
+	for _, test := range tests {
+		decisions := test.retention.Apply(snapshotsAt(t, test.snapshots...), test.loc)
+		if len(decisions) != len(test.snapshots) {
+			t.Fatalf("%s: %d decisions for %d snapshots", test.name, len(decisions), len(test.snapshots))
+		}
+		// Newest first
+		if !slices.IsSortedFunc(decisions, func(a, b Decision) int { return b.Manifest.Created.Compare(a.Manifest.Created) }) {
+			t.Errorf("%s: decisions not ordered newest first", test.name)
+		}
+		for _, decision := range decisions {
+			id := decision.Manifest.ID
+			if reasons := strings.Join(decision.Reasons, ","); reasons != test.want[id] || decision.Keep != (reasons != "") {
+				t.Errorf("%s: snapshot %s kept %t by %q, want %q", test.name, id, decision.Keep, reasons, test.want[id])
+			}
+		}
+	}
+}
+
+func TestPruneReportsRemovedSnapshots(t *testing.T) {
+	src, _ := writeSources(t)
+	dest := t.TempDir()
+	repo, err := OpenRepository(dest, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	var ids []string // oldest first
+	for i := range 4 {
+		manifest, err := repo.Create([]string{src}, NoCompression, start.Add(time.Duration(i)*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, manifest.ID)
+	}
+
+	// Snapshots are removed from the newest to the oldest, the second one of them can't be renamed
+	if err := os.MkdirAll(filepath.Join(dest, ".removing-"+ids[1], "leftover"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	decisions, _, err := repo.Prune(Retention{Last: 1}, time.UTC, false)
+	if err == nil {
+		t.Fatal("prune succeeded")
+	}
+
+	want := map[string]bool{ids[2]: true, ids[1]: false, ids[0]: false, ids[3]: false}
+	for _, decision := range decisions {
+		id := decision.Manifest.ID
+		if decision.Removed != want[id] {
+			t.Errorf("snapshot %s: removed %v, want %v", id, decision.Removed, want[id])
+		}
+		_, statErr := os.Stat(filepath.Join(dest, id))
+		if exists := statErr == nil; exists == decision.Removed {
+			t.Errorf("snapshot %s: removed %v, but exists %v", id, decision.Removed, exists)
+		}
+	}
+
+	// Dry runs remove nothing
+	os.RemoveAll(filepath.Join(dest, ".removing-"+ids[1]))
+	decisions, _, err = repo.Prune(Retention{Last: 1}, time.UTC, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, decision := range decisions {
+		if decision.Removed {
+			t.Errorf("dry run removed %s", decision.Manifest.ID)
+		}
+	}
+}
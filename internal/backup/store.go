@@ -0,0 +1,253 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Directory of the chunks in the destination, chunks are stored in subdirectories by the first two hex digits
+const chunksDir = "chunks"
+
+// Name of the lock file held by the commands changing the destination
+const lockName = ".lock"
+
+/*
+Formats of the chunk files, given by their first byte.
+An encrypted chunk is the nonce and the sealed raw or gzip chunk.
+*/
+const (
+	chunkRaw       byte = 0
+	chunkGzip      byte = 1
+	chunkEncrypted byte = 2
+)
+
+func (r *Repository) chunkPath(id string) string {
+	return filepath.Join(r.Dest, chunksDir, id[:2], id)
+}
+
+/*
+storeChunk saves a chunk under its ID unless a chunk with the same content exists,
+and returns the number of bytes written, 0 for an existing chunk.
+*/
+func (r *Repository) storeChunk(id string, data []byte, compression Compression) (int64, error) {
+	target := r.chunkPath(id)
+	if _, err := os.Stat(target); err == nil {
+		return 0, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+
+	var b bytes.Buffer
+	if compression == Gzip {
+		b.WriteByte(chunkGzip)
+		zw := gzip.NewWriter(&b)
+		if _, err := zw.Write(data); err != nil {
+			return 0, err
+		}
+		if err := zw.Close(); err != nil {
+			return 0, err
+		}
+	}
+	// Data that doesn't compress is kept as it is
+	if compression != Gzip || b.Len() > len(data)+1 {
+		b.Reset()
+		b.WriteByte(chunkRaw)
+		b.Write(data)
+	}
+	if r.key != nil {
+		// The ID is authenticated too, so that chunks can't be swapped
+		sealed := seal(r.key.aead, b.Bytes(), []byte(id))
+		b.Reset()
+		b.WriteByte(chunkEncrypted)
+		b.Write(sealed)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+id+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b.Bytes()); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	return int64(b.Len()), os.Rename(tmp.Name(), target)
+}
+
+// readChunk loads a chunk and checks that its content matches the ID
+func (r *Repository) readChunk(id string) ([]byte, error) {
+	raw, err := os.ReadFile(r.chunkPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("missing chunk %s", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("empty chunk %s", id)
+	}
+
+	if raw[0] == chunkEncrypted {
+		if r.key == nil {
+			return nil, fmt.Errorf("chunk %s is encrypted", id)
+		}
+		raw, err = open(r.key.aead, raw[1:], []byte(id))
+		if err != nil || len(raw) == 0 {
+			return nil, fmt.Errorf("corrupted chunk %s", id)
+		}
+	} else if r.key != nil {
+		return nil, fmt.Errorf("chunk %s isn't encrypted", id)
+	}
+
+	data := raw[1:]
+	switch raw[0] {
+	case chunkRaw:
+	case chunkGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("unreadable chunk %s: %w", id, err)
+		}
+		data, err = io.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("unreadable chunk %s: %w", id, err)
+		}
+	default:
+		return nil, fmt.Errorf("chunk %s has unknown format %d", id, raw[0])
+	}
+
+	if r.key.chunkID(data) != id {
+		return nil, fmt.Errorf("corrupted chunk %s", id)
+	}
+	return data, nil
+}
+
+// chunkReader reads the content of a file from its chunks one by one
+type chunkReader struct {
+	repo   *Repository
+	chunks []string
+	data   []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		if len(r.chunks) == 0 {
+			return 0, io.EOF
+		}
+		data, err := r.repo.readChunk(r.chunks[0])
+		if err != nil {
+			return 0, err
+		}
+		r.data, r.chunks = data, r.chunks[1:]
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func (r *chunkReader) Close() error {
+	return nil
+}
+
+/*
+lock takes the lock of the destination, so that pruning never removes chunks a running backup relies on.
+The returned function releases it. A lock left behind by a crash has to be removed by hand.
+*/
+func (r *Repository) lock() (func(), error) {
+	name := filepath.Join(r.Dest, lockName)
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, fs.ErrExist) {
+		owner, _ := os.ReadFile(name)
+		return nil, fmt.Errorf("destination is locked by another backup or prune %s, remove '%s' if none is running",
+			strings.TrimSpace(string(owner)), name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(file, "pid %d since %s\n", os.Getpid(), time.Now().Format(time.RFC3339))
+	file.Close()
+	return func() { os.Remove(name) }, nil
+}
+
+// GCResult counts the chunks removed by garbage collection
+type GCResult struct {
+	Chunks int
+	Bytes  int64
+}
+
+/*
+collectGarbage removes the chunks no snapshot in keep references,
+along with temporary files and snapshots left behind by interrupted runs.
+The caller holds the lock.
+*/
+func (r *Repository) collectGarbage(keep []Manifest, dryRun bool) (GCResult, error) {
+	var result GCResult
+	referenced := make(map[string]bool)
+	for _, manifest := range keep {
+		for _, entry := range manifest.Files {
+			for _, hash := range entry.Chunks {
+				referenced[hash] = true
+			}
+		}
+	}
+
+	if !dryRun {
+		leftovers, err := os.ReadDir(r.Dest)
+		if err != nil {
+			return result, err
+		}
+		for _, leftover := range leftovers {
+			if strings.HasPrefix(leftover.Name(), ".snapshot-") || strings.HasPrefix(leftover.Name(), ".removing-") {
+				if err := os.RemoveAll(filepath.Join(r.Dest, leftover.Name())); err != nil {
+					return result, err
+				}
+			}
+		}
+	}
+
+	root := filepath.Join(r.Dest, chunksDir)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == root {
+			return filepath.SkipDir
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		name := d.Name()
+		temporary := strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".tmp")
+		if referenced[name] || (!temporary && strings.HasPrefix(name, ".")) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !temporary {
+			result.Chunks++
+			result.Bytes += info.Size()
+		}
+		if dryRun {
+			return nil
+		}
+		return os.Remove(path)
+	})
+	return result, err
+}
@@ -0,0 +1,94 @@
+package backup
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Problem is a copy that doesn't match its manifest entry
+type Problem struct {
+	Snapshot string
+	Path     string
+	Err      error
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s: %s", p.Snapshot, p.Path, p.Err)
+}
+
+/*
+Verify re-hashes every file of a snapshot and returns the missing and corrupted ones.
+Every chunk is checked against its hash as well, so that the damaged chunk is named.
+*/
+func (r *Repository) Verify(manifest Manifest) []Problem {
+	var problems []Problem
+	for _, entry := range manifest.Files {
+		if err := r.verifyEntry(manifest, entry); err != nil {
+			problems = append(problems, Problem{Snapshot: manifest.ID, Path: entry.Path, Err: err})
+		}
+	}
+	return problems
+}
+
+func (r *Repository) verifyEntry(manifest Manifest, entry Entry) error {
+	reader, err := r.openEntry(manifest, entry)
+	if errors.Is(err, fs.ErrNotExist) {
+		return errors.New("missing copy")
+	}
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, reader)
+	if err != nil {
+		return fmt.Errorf("unreadable copy: %w", err)
+	}
+	if size != entry.Size {
+		return fmt.Errorf("size is %d, expected %d", size, entry.Size)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != entry.SHA256 {
+		return fmt.Errorf("checksum is %s, expected %s", sum, entry.SHA256)
+	}
+	return nil
+}
+
+// openEntry returns the original content of a file, from its chunks or from a copy of version 1
+func (r *Repository) openEntry(manifest Manifest, entry Entry) (io.ReadCloser, error) {
+	if entry.Stored == "" {
+		return &chunkReader{repo: r, chunks: entry.Chunks}, nil
+	}
+
+	file, err := os.Open(filepath.Join(r.Dest, manifest.ID, filepath.FromSlash(entry.Stored)))
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Compression != Gzip {
+		return file, nil
+	}
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("unreadable copy: %w", err)
+	}
+	return &gzipFile{Reader: zr, file: file}, nil
+}
+
+// gzipFile closes both the decompressor and the file
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g *gzipFile) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
@@ -0,0 +1,112 @@
+package backup
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// entryOf returns the entry of the file with the given base name
+func entryOf(t *testing.T, manifest Manifest, name string) Entry {
+	t.Helper()
+	for _, entry := range manifest.Files {
+		if path.Base(entry.Path) == name {
+			return entry
+		}
+	}
+	t.Fatalf("%s not in snapshot %s", name, manifest.ID)
+	return Entry{}
+}
+
+func TestVerifyReportsDamagedChunks(t *testing.T) {
+	tests := []struct {
+		name        string
+		secret      *Secret
+		compression Compression
+	}{
+		{"raw", nil, NoCompression},
+		{"gzip", nil, Gzip},
+		{"encrypted", &Secret{Passphrase: []byte("correct horse battery staple")}, Gzip},
+	}
+	for _, test := range tests {
+		src, _ := writeSources(t)
+		repo, err := OpenRepository(t.TempDir(), test.secret, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifest, err := repo.Create([]string{src}, test.compression, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if problems := repo.Verify(manifest); len(problems) != 0 {
+			t.Fatalf("%s: problems in an intact snapshot: %v", test.name, problems)
+		}
+
+		// A flipped byte in the middle of the chunk of a.csv
+		entry := entryOf(t, manifest, "a.csv")
+		chunk := repo.chunkPath(entry.Chunks[0])
+		data, err := os.ReadFile(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[len(data)/2] ^= 0x01
+		if err := os.WriteFile(chunk, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		problems := repo.Verify(manifest)
+		if len(problems) != 1 || problems[0].Path != entry.Path || !strings.Contains(problems[0].Err.Error(), entry.Chunks[0]) {
+			t.Errorf("%s: problems with a flipped byte %v, want one naming chunk %s of %s", test.name, problems, entry.Chunks[0], entry.Path)
+		}
+
+		// A missing chunk
+		if err := os.Remove(chunk); err != nil {
+			t.Fatal(err)
+		}
+		problems = repo.Verify(manifest)
+		if len(problems) != 1 || problems[0].Path != entry.Path || !strings.Contains(problems[0].Err.Error(), "missing chunk "+entry.Chunks[0]) {
+			t.Errorf("%s: problems with a missing chunk %v", test.name, problems)
+		}
+	}
+}
+
+func TestDamagedManifest(t *testing.T) {
+	src, _ := writeSources(t)
+	repo, err := OpenRepository(t.TempDir(), nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := repo.Create([]string{src}, NoCompression, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(repo.Dest, manifest.ID, repo.manifestName())
+
+	// A truncated manifest fails listing the snapshots and finding the snapshot, so verify stops
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, data[:len(data)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Snapshots(); err == nil || !strings.Contains(err.Error(), manifest.ID) {
+		t.Errorf("snapshots with a truncated manifest: error %v", err)
+	}
+	if _, err := repo.FindSnapshot(manifest.ID); err == nil {
+		t.Error("snapshot with a truncated manifest found")
+	}
+
+	// Without a manifest the snapshot is unfinished, it isn't listed and can't be verified
+	if err := os.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	if manifests, err := repo.Snapshots(); err != nil || len(manifests) != 0 {
+		t.Errorf("snapshots without a manifest %v, %v", manifests, err)
+	}
+	if _, err := repo.FindSnapshot(manifest.ID); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("snapshot without a manifest: error %v", err)
+	}
+}
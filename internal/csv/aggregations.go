@@ -2,13 +2,14 @@ package csv
 
 import (
 	"fmt"
-	"reflect"
+	"math/big"
 )
 
 type Aggregator interface {
 	Name() string                     // {aggregationType_column} like count_age
 	Column() string                   // column name
 	AggregationType() AggregationType // sum, avg, mix, max, count, countd (count distinct)
+	ResultType() ColumnTypeInterface  // type of the aggregated value
 	Aggregate([]string) (string, error)
 }
 
@@ -23,6 +24,45 @@ const (
 	AggMax           AggregationType = "max"
 )
 
+/*
+exactSum adds numbers without overflowing: an int sum leaving the int range
+continues as a big integer, so that it can't wrap around to a wrong value.
+*/
+type exactSum[T Numeric] struct {
+	sum  T
+	wide *big.Int // the sum once it overflowed
+}
+
+func (s *exactSum[T]) add(v T) {
+	if s.wide != nil {
+		s.wide.Add(s.wide, big.NewInt(int64(v)))
+		return
+	}
+	sum := s.sum + v
+	// A wrapped sum of two numbers of the same sign has the other sign, float sums become infinite instead
+	if s.sum > 0 && v > 0 && sum < 0 || s.sum < 0 && v < 0 && sum >= 0 {
+		s.wide = new(big.Int).Add(big.NewInt(int64(s.sum)), big.NewInt(int64(v)))
+		return
+	}
+	s.sum = sum
+}
+
+func (s *exactSum[T]) String() string {
+	if s.wide != nil {
+		return s.wide.String()
+	}
+	return fmt.Sprintf("%v", s.sum)
+}
+
+// average divides the sum by the number of values
+func (s *exactSum[T]) average(count int) float64 {
+	if s.wide != nil {
+		avg, _ := new(big.Rat).SetFrac(s.wide, big.NewInt(int64(count))).Float64()
+		return avg
+	}
+	return float64(s.sum) / float64(count)
+}
+
 type SumAggregator[T Numeric] struct {
 	columnName string
 	columnType *ColumnType[T]
@@ -40,16 +80,33 @@ func (a SumAggregator[T]) AggregationType() AggregationType {
 	return AggSum
 }
 
+// ResultType of int sums is decimal, as a sum of ints may not fit into an int
+func (a SumAggregator[T]) ResultType() ColumnTypeInterface {
+	if a.columnType.Name() == TypeInt.Name() {
+		return TypeDecimal
+	}
+	return a.columnType
+}
+
 func (a SumAggregator[T]) Aggregate(values []string) (string, error) {
-	var sum T
+	var sum exactSum[T]
+	found := false
 	for _, s := range values {
-		v, err := a.columnType.ParseTyped(s)
+		v, null, err := a.columnType.parseNullable(s)
 		if err != nil {
 			return "", err
 		}
-		sum += v
+		if null {
+			continue
+		}
+		sum.add(v)
+		found = true
 	}
-	return fmt.Sprintf("%v", sum), nil
+	// The sum of nulls only is null
+	if !found {
+		return "", nil
+	}
+	return sum.String(), nil
 }
 
 type AvgAggregator[T Numeric] struct {
@@ -69,16 +126,29 @@ func (a AvgAggregator[T]) AggregationType() AggregationType {
 	return AggAvg
 }
 
+func (a AvgAggregator[T]) ResultType() ColumnTypeInterface {
+	return TypeFloat
+}
+
 func (a AvgAggregator[T]) Aggregate(values []string) (string, error) {
-	var sum T
+	var sum exactSum[T]
+	count := 0
 	for _, s := range values {
-		v, err := a.columnType.ParseTyped(s)
+		v, null, err := a.columnType.parseNullable(s)
 		if err != nil {
 			return "", err
 		}
-		sum += v
+		if null {
+			continue
+		}
+		sum.add(v)
+		count++
+	}
+	// The average of nulls only is null
+	if count == 0 {
+		return "", nil
 	}
-	return fmt.Sprintf("%v", float64(sum)/float64(len(values))), nil
+	return fmt.Sprintf("%v", sum.average(count)), nil
 }
 
 type MaxAggregator[T Ordered] struct {
@@ -98,19 +168,30 @@ func (a MaxAggregator[T]) AggregationType() AggregationType {
 	return AggMax
 }
 
+func (a MaxAggregator[T]) ResultType() ColumnTypeInterface {
+	return a.columnType
+}
+
 func (a MaxAggregator[T]) Aggregate(values []string) (string, error) {
 	var max T
+	found := false
 	for _, s := range values {
-		v, err := a.columnType.ParseTyped(s)
+		v, null, err := a.columnType.parseNullable(s)
 		if err != nil {
 			return "", err
 		}
-		if isEmpty(max) {
-			max = v
-		} else if v > max {
+		if null {
+			continue
+		}
+		if !found || v > max {
 			max = v
+			found = true
 		}
 	}
+	// The maximum of nulls only is null
+	if !found {
+		return "", nil
+	}
 	return fmt.Sprintf("%v", max), nil
 }
 
@@ -131,19 +212,30 @@ func (a MinAggregator[T]) AggregationType() AggregationType {
 	return AggMin
 }
 
+func (a MinAggregator[T]) ResultType() ColumnTypeInterface {
+	return a.columnType
+}
+
 func (a MinAggregator[T]) Aggregate(values []string) (string, error) {
 	var min T
+	found := false
 	for _, s := range values {
-		v, err := a.columnType.ParseTyped(s)
+		v, null, err := a.columnType.parseNullable(s)
 		if err != nil {
 			return "", err
 		}
-		if isEmpty(min) {
-			min = v
-		} else if v < min {
+		if null {
+			continue
+		}
+		if !found || v < min {
 			min = v
+			found = true
 		}
 	}
+	// The minimum of nulls only is null
+	if !found {
+		return "", nil
+	}
 	return fmt.Sprintf("%v", min), nil
 }
 
@@ -163,6 +255,10 @@ func (a CountAggregator[T]) AggregationType() AggregationType {
 	return AggCount
 }
 
+func (a CountAggregator[T]) ResultType() ColumnTypeInterface {
+	return TypeInt
+}
+
 func (a CountAggregator[T]) Aggregate(values []string) (string, error) {
 	return fmt.Sprintf("%v", len(values)), nil
 }
@@ -183,6 +279,10 @@ func (a CountDistinctAggregator[T]) AggregationType() AggregationType {
 	return AggCountDistinct
 }
 
+func (a CountDistinctAggregator[T]) ResultType() ColumnTypeInterface {
+	return TypeInt
+}
+
 func (a CountDistinctAggregator[T]) Aggregate(values []string) (string, error) {
 	valuesMap := make(map[string]bool)
 	for _, v := range values {
@@ -191,6 +291,67 @@ func (a CountDistinctAggregator[T]) Aggregate(values []string) (string, error) {
 	return fmt.Sprintf("%v", len(valuesMap)), nil
 }
 
-func isEmpty[T any](v T) bool {
-	return reflect.ValueOf(v).IsZero()
+/*
+DecimalAggregator aggregates decimal columns without losing digits: the sum is exact,
+the average is the float nearest to the exact average, and max and min keep the text of the value.
+*/
+type DecimalAggregator struct {
+	columnName      string
+	aggregationType AggregationType // sum, avg, max or min
+}
+
+func (a DecimalAggregator) Name() string {
+	return fmt.Sprintf("%s_%s", a.columnName, a.aggregationType)
+}
+
+func (a DecimalAggregator) Column() string {
+	return a.columnName
+}
+
+func (a DecimalAggregator) AggregationType() AggregationType {
+	return a.aggregationType
+}
+
+func (a DecimalAggregator) ResultType() ColumnTypeInterface {
+	if a.aggregationType == AggAvg {
+		return TypeFloat
+	}
+	return TypeDecimal
+}
+
+func (a DecimalAggregator) Aggregate(values []string) (string, error) {
+	var result Decimal
+	var text string // text of the maximum or minimum
+	count := 0
+	for _, s := range values {
+		v, null, err := TypeDecimal.parseNullable(s)
+		if err != nil {
+			return "", err
+		}
+		if null {
+			continue
+		}
+		switch {
+		case count == 0:
+			result, text = v, s
+		case a.aggregationType == AggSum || a.aggregationType == AggAvg:
+			result = result.Add(v)
+		case a.aggregationType == AggMax && v.Cmp(result) > 0,
+			a.aggregationType == AggMin && v.Cmp(result) < 0:
+			result, text = v, s
+		}
+		count++
+	}
+	// Aggregations of nulls only are null
+	if count == 0 {
+		return "", nil
+	}
+	switch a.aggregationType {
+	case AggSum:
+		return result.String(), nil
+	case AggAvg:
+		avg, _ := new(big.Rat).Quo(result.value, big.NewRat(int64(count), 1)).Float64()
+		return fmt.Sprintf("%v", avg), nil
+	}
+	return text, nil
 }
@@ -0,0 +1,120 @@
+package csv
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestIntSumOverflow(t *testing.T) {
+	maxInt := strconv.Itoa(math.MaxInt64)
+	minInt := strconv.Itoa(math.MinInt64)
+	sum := SumAggregator[int]{"n", TypeInt}
+	avg := AvgAggregator[int]{"n", TypeInt}
+
+	tests := []struct {
+		values   []string
+		sum, avg string
+	}{
+		{[]string{"1", "2", "", "3"}, "6", "2"},
+		{[]string{maxInt, "1"}, "9223372036854775808", "4.611686018427388e+18"},
+		{[]string{maxInt, maxInt, maxInt}, "27670116110564327421", "9.223372036854776e+18"},
+		{[]string{minInt, "-1"}, "-9223372036854775809", "-4.611686018427388e+18"},
+		// The sum comes back into the int range after it overflowed
+		{[]string{maxInt, "10", "-20"}, "9223372036854775797", "3.0744573456182584e+18"},
+		{[]string{maxInt, minInt}, "-1", "-0.5"},
+	}
+	for _, test := range tests {
+		got, err := sum.Aggregate(test.values)
+		if err != nil || got != test.sum {
+			t.Errorf("sum %v = %s, %v, want %s", test.values, got, err, test.sum)
+		}
+		// The result type holds any sum
+		if err := sum.ResultType().Check(got); err != nil {
+			t.Errorf("sum %v: %v", test.values, err)
+		}
+		got, err = avg.Aggregate(test.values)
+		if err != nil || got != test.avg {
+			t.Errorf("avg %v = %s, %v, want %s", test.values, got, err, test.avg)
+		}
+	}
+}
+
+func TestFloatSum(t *testing.T) {
+	sum := SumAggregator[float64]{"x", TypeFloat}
+	if got, err := sum.Aggregate([]string{"1.5", "2", ""}); err != nil || got != "3.5" {
+		t.Errorf("sum = %s, %v, want 3.5", got, err)
+	}
+	if sum.ResultType() != TypeFloat {
+		t.Errorf("result type %s, want float", sum.ResultType().Name())
+	}
+	if got, _ := sum.Aggregate([]string{"", ""}); got != "" {
+		t.Errorf("sum of nulls = %q, want null", got)
+	}
+}
+
+func TestDecimalAggregator(t *testing.T) {
+	values := []string{"0.1234567890123456789", "99999999999999999999", "", "-0.0000000000000000001"}
+	tests := map[AggregationType]string{
+		AggSum: "99999999999999999999.1234567890123456788",
+		AggAvg: "3.333333333333333e+19",
+		AggMax: "99999999999999999999",
+		AggMin: "-0.0000000000000000001",
+	}
+	for aggregation, want := range tests {
+		got, err := DecimalAggregator{"d", aggregation}.Aggregate(values)
+		if err != nil || got != want {
+			t.Errorf("%s = %s, %v, want %s", aggregation, got, err, want)
+		}
+	}
+}
+
+func TestAggregationsSkipNulls(t *testing.T) {
+	values := []string{"", "3", "", "0", "-2"}
+	nulls := []string{"", ""}
+	tests := []struct {
+		aggregator Aggregator
+		want       string
+	}{
+		{SumAggregator[int]{"n", TypeInt}, "1"},
+		{AvgAggregator[int]{"n", TypeInt}, "0.3333333333333333"}, // nulls aren't counted
+		{MaxAggregator[int]{"n", TypeInt}, "3"},
+		{MinAggregator[int]{"n", TypeInt}, "-2"},
+		{CountAggregator[string]{"n"}, "5"}, // rows are counted, nulls included
+		{CountDistinctAggregator[string]{"n"}, "4"},
+	}
+	for _, test := range tests {
+		got, err := test.aggregator.Aggregate(values)
+		if err != nil || got != test.want {
+			t.Errorf("%s = %q, %v, want %s", test.aggregator.Name(), got, err, test.want)
+		}
+		// Aggregations of nulls only are null, counts are not
+		got, _ = test.aggregator.Aggregate(nulls)
+		isCount := test.aggregator.AggregationType() == AggCount || test.aggregator.AggregationType() == AggCountDistinct
+		if !isCount && got != "" {
+			t.Errorf("%s of nulls = %q, want null", test.aggregator.Name(), got)
+		}
+	}
+}
+
+func TestMaxMinWithZero(t *testing.T) {
+	// Zero values are values, not the start of the search
+	tests := []struct {
+		values   []string
+		max, min string
+	}{
+		{[]string{"3", "0", "5"}, "5", "0"},
+		{[]string{"-5", "0", "-3"}, "0", "-5"},
+		{[]string{"0"}, "0", "0"},
+	}
+	for _, test := range tests {
+		max, _ := MaxAggregator[int]{"n", TypeInt}.Aggregate(test.values)
+		min, _ := MinAggregator[int]{"n", TypeInt}.Aggregate(test.values)
+		if max != test.max || min != test.min {
+			t.Errorf("%v: max %s, min %s, want %s and %s", test.values, max, min, test.max, test.min)
+		}
+	}
+	if max, _ := (MaxAggregator[float64]{"x", TypeFloat}).Aggregate([]string{"-1.5", "0", ""}); max != "0" {
+		t.Errorf("float max %s, want 0", max)
+	}
+}
@@ -1,12 +1,17 @@
 package csv
 
-import "fmt"
+import (
+	"cmp"
+	"fmt"
+)
 
 // Column type interface for storage versatility
 type ColumnTypeInterface interface {
 	Name() string
 	Parse(string) (any, error)
 	Compare(aRaw, bRaw string, cmp comparisonType) (bool, error)
+	Order(aRaw, bRaw string) (int, error) // -1, 0 or +1 like cmp.Compare
+	Check(string) error                   // nil for values of the type and nulls
 }
 
 type ColumnType[T Ordered] struct {
@@ -20,25 +25,88 @@ func (ct ColumnType[T]) Name() string {
 }
 
 func (ct ColumnType[T]) Parse(s string) (any, error) {
-	return ct.ParseFn(s)
+	return ct.ParseTyped(s)
 }
 
+// ParseTyped parses a value of the type, failures are *ValueError
 func (ct ColumnType[T]) ParseTyped(s string) (T, error) {
-	return ct.ParseFn(s)
+	v, err := ct.ParseFn(s)
+	if err != nil {
+		return v, &ValueError{Value: s, Type: ct.TypeName, Err: err}
+	}
+	return v, nil
+}
+
+// ValueError is a value that can't be parsed as the type of its column
+type ValueError struct {
+	Value string
+	Type  string // name of the expected type
+	Err   error  // error of the parser, nil if there was no parser
+}
+
+func (e *ValueError) Error() string {
+	return fmt.Sprintf("value %q is not a valid %s", e.Value, e.Type)
 }
 
+func (e *ValueError) Unwrap() error {
+	return e.Err
+}
+
+/*
+Compare compares two raw values.
+Empty values of types that can't parse them are nulls, and comparisons with nulls are false.
+*/
 func (ct ColumnType[T]) Compare(aRaw, bRaw string, cmp comparisonType) (bool, error) {
-	a, err := ct.ParseFn(aRaw)
+	a, aNull, err := ct.parseNullable(aRaw)
 	if err != nil {
 		return false, err
 	}
-	b, err := ct.ParseFn(bRaw)
+	b, bNull, err := ct.parseNullable(bRaw)
 	if err != nil {
 		return false, err
 	}
+	if aNull || bNull {
+		return false, nil
+	}
 	cmpFunc, ok := ct.CmpFns[cmp]
 	if !ok {
 		return false, fmt.Errorf("unknown comparison type")
 	}
 	return cmpFunc(a, b), nil
 }
+
+// Order compares two raw values for sorting, nulls go before all other values
+func (ct ColumnType[T]) Order(aRaw, bRaw string) (int, error) {
+	a, aNull, err := ct.parseNullable(aRaw)
+	if err != nil {
+		return 0, err
+	}
+	b, bNull, err := ct.parseNullable(bRaw)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case aNull && bNull:
+		return 0, nil
+	case aNull:
+		return -1, nil
+	case bNull:
+		return 1, nil
+	}
+	return cmp.Compare(a, b), nil
+}
+
+// Check reports an error for a value the type can't parse, empty values are nulls
+func (ct ColumnType[T]) Check(s string) error {
+	_, _, err := ct.parseNullable(s)
+	return err
+}
+
+// parseNullable parses the value and reports an empty value the type can't parse as null
+func (ct ColumnType[T]) parseNullable(s string) (T, bool, error) {
+	v, err := ct.ParseTyped(s)
+	if err != nil && s == "" {
+		return v, true, nil
+	}
+	return v, false, err
+}
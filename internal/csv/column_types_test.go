@@ -0,0 +1,89 @@
+package csv
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseNullable(t *testing.T) {
+	tests := []struct {
+		columnType ColumnTypeInterface
+		value      string
+		null, bad  bool
+	}{
+		{TypeInt, "", true, false},
+		{TypeInt, "0", false, false},
+		{TypeInt, " ", false, true}, // only empty values are nulls
+		{TypeInt, "1.5", false, true},
+		{TypeFloat, "", true, false},
+		{TypeFloat, "-0.5", false, false},
+		{TypeDecimal, "", true, false},
+		{TypeString, "", false, false}, // strings can be empty
+	}
+	for _, test := range tests {
+		var null bool
+		var err error
+		switch columnType := test.columnType.(type) {
+		case *ColumnType[int]:
+			_, null, err = columnType.parseNullable(test.value)
+		case *ColumnType[float64]:
+			_, null, err = columnType.parseNullable(test.value)
+		case *ColumnType[string]:
+			_, null, err = columnType.parseNullable(test.value)
+		case *DecimalType:
+			_, null, err = columnType.parseNullable(test.value)
+		}
+		if null != test.null || (err != nil) != test.bad {
+			t.Errorf("%s %q: null %v, error %v", test.columnType.Name(), test.value, null, err)
+		}
+		if checkErr := test.columnType.Check(test.value); (checkErr != nil) != test.bad {
+			t.Errorf("%s %q: Check() = %v", test.columnType.Name(), test.value, checkErr)
+		}
+		var valueErr *ValueError
+		if test.bad && !errors.As(err, &valueErr) {
+			t.Errorf("%s %q: error %v isn't a *ValueError", test.columnType.Name(), test.value, err)
+		}
+	}
+}
+
+func TestCompareNulls(t *testing.T) {
+	// Comparisons with nulls are false, even for not equal
+	for _, columnType := range []ColumnTypeInterface{TypeInt, TypeFloat, TypeDecimal} {
+		for _, cmp := range []comparisonType{Equal, NonEqual, GreaterThan, GreaterOrEqual, LessThan, LessOrEqual} {
+			for _, pair := range [][2]string{{"", "1"}, {"1", ""}, {"", ""}} {
+				if ok, err := columnType.Compare(pair[0], pair[1], cmp); ok || err != nil {
+					t.Errorf("%s: Compare(%q, %q, %d) = %v, %v", columnType.Name(), pair[0], pair[1], cmp, ok, err)
+				}
+			}
+		}
+		if ok, err := columnType.Compare("2", "10", LessThan); !ok || err != nil {
+			t.Errorf("%s: 2 < 10 = %v, %v", columnType.Name(), ok, err)
+		}
+		if _, err := columnType.Compare("a", "1", Equal); err == nil {
+			t.Errorf("%s: bad value compared", columnType.Name())
+		}
+	}
+	// Empty strings are values
+	if ok, _ := TypeString.Compare("", "", Equal); !ok {
+		t.Error(`string "" = "" is false`)
+	}
+}
+
+func TestOrderNullsFirst(t *testing.T) {
+	for _, columnType := range []ColumnTypeInterface{TypeInt, TypeFloat, TypeDecimal} {
+		tests := []struct {
+			a, b string
+			want int
+		}{
+			{"", "-100", -1},
+			{"-100", "", 1},
+			{"", "", 0},
+			{"2", "10", -1},
+		}
+		for _, test := range tests {
+			if got, err := columnType.Order(test.a, test.b); got != test.want || err != nil {
+				t.Errorf("%s: Order(%q, %q) = %d, %v, want %d", columnType.Name(), test.a, test.b, got, err, test.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package csv
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+Decimal is an exact number of any size and precision, for values that don't fit into an int
+or can't be held by a float without losing digits.
+*/
+type Decimal struct {
+	value *big.Rat
+	scale int // digits after the point needed to write the value exactly
+}
+
+/*
+Exponents are limited to four digits, so that a value like "1e999999999"
+can't make the parser allocate a number with a billion digits.
+*/
+var decimalPattern = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d{1,4})?$`)
+
+// ParseDecimal parses a decimal number with an optional exponent, like "-12.50" or "1.5e3"
+func ParseDecimal(s string) (Decimal, error) {
+	if !decimalPattern.MatchString(s) {
+		return Decimal{}, errors.New("invalid decimal syntax")
+	}
+	value, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return Decimal{}, errors.New("invalid decimal syntax")
+	}
+
+	mantissa, exponent := strings.ToLower(s), 0
+	if i := strings.IndexByte(mantissa, 'e'); i >= 0 {
+		// The pattern allows at most four digits
+		exponent, _ = strconv.Atoi(mantissa[i+1:])
+		mantissa = mantissa[:i]
+	}
+	scale := 0
+	if i := strings.IndexByte(mantissa, '.'); i >= 0 {
+		scale = len(mantissa) - i - 1
+	}
+	return Decimal{value: value, scale: max(scale-exponent, 0)}, nil
+}
+
+// Cmp compares two decimals like cmp.Compare
+func (d Decimal) Cmp(other Decimal) int {
+	return d.value.Cmp(other.value)
+}
+
+// Add returns the exact sum of two decimals
+func (d Decimal) Add(other Decimal) Decimal {
+	return Decimal{value: new(big.Rat).Add(d.value, other.value), scale: max(d.scale, other.scale)}
+}
+
+// Float64 returns the nearest float to the decimal
+func (d Decimal) Float64() float64 {
+	f, _ := d.value.Float64()
+	return f
+}
+
+// String writes the decimal without an exponent and without trailing zeros, so that equal decimals have equal texts
+func (d Decimal) String() string {
+	s := d.text()
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	if s == "-0" {
+		return "0"
+	}
+	return s
+}
+
+// text writes the decimal with all digits of its scale, trailing zeros included
+func (d Decimal) text() string {
+	if d.value == nil {
+		return "0"
+	}
+	return d.value.FloatString(d.scale)
+}
+
+// MarshalJSON writes the decimal as a JSON number with all its digits
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// DecimalType is the column type of decimals
+type DecimalType struct{}
+
+// TypeDecimal holds numbers that overflow int or lose digits as float
+var TypeDecimal = &DecimalType{}
+
+func (DecimalType) Name() string {
+	return "decimal"
+}
+
+func (t DecimalType) Parse(s string) (any, error) {
+	return t.ParseTyped(s)
+}
+
+// ParseTyped parses a decimal, failures are *ValueError
+func (t DecimalType) ParseTyped(s string) (Decimal, error) {
+	d, err := ParseDecimal(s)
+	if err != nil {
+		return d, &ValueError{Value: s, Type: t.Name(), Err: err}
+	}
+	return d, nil
+}
+
+// Compare compares two raw values, comparisons with nulls are false
+func (t DecimalType) Compare(aRaw, bRaw string, cmp comparisonType) (bool, error) {
+	a, aNull, err := t.parseNullable(aRaw)
+	if err != nil {
+		return false, err
+	}
+	b, bNull, err := t.parseNullable(bRaw)
+	if err != nil {
+		return false, err
+	}
+	if aNull || bNull {
+		return false, nil
+	}
+	order := a.Cmp(b)
+	switch cmp {
+	case Equal:
+		return order == 0, nil
+	case NonEqual:
+		return order != 0, nil
+	case GreaterThan:
+		return order > 0, nil
+	case GreaterOrEqual:
+		return order >= 0, nil
+	case LessThan:
+		return order < 0, nil
+	case LessOrEqual:
+		return order <= 0, nil
+	}
+	return false, fmt.Errorf("unknown comparison type")
+}
+
+// Order compares two raw values for sorting, nulls go before all other values
+func (t DecimalType) Order(aRaw, bRaw string) (int, error) {
+	a, aNull, err := t.parseNullable(aRaw)
+	if err != nil {
+		return 0, err
+	}
+	b, bNull, err := t.parseNullable(bRaw)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case aNull && bNull:
+		return 0, nil
+	case aNull:
+		return -1, nil
+	case bNull:
+		return 1, nil
+	}
+	return a.Cmp(b), nil
+}
+
+// Check reports an error for a value that isn't a decimal, empty values are nulls
+func (t DecimalType) Check(s string) error {
+	_, _, err := t.parseNullable(s)
+	return err
+}
+
+func (t DecimalType) parseNullable(s string) (Decimal, bool, error) {
+	if s == "" {
+		return Decimal{}, true, nil
+	}
+	d, err := t.ParseTyped(s)
+	return d, false, err
+}
@@ -0,0 +1,327 @@
+package csv
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Number of temporary files the rows are distributed to once the keys don't fit in memory
+const dedupePartitions = 64
+
+type KeepMode string
+
+// KeepMode defines which of the duplicate rows is kept
+const (
+	KeepFirst KeepMode = "first"
+	KeepLast  KeepMode = "last"
+	KeepMax   KeepMode = "max"
+	KeepMin   KeepMode = "min"
+)
+
+// Deduplication describes which rows are duplicates and which of them is kept
+type Deduplication struct {
+	keyIndices []int    // indices of the key columns, all columns if empty
+	keyColumns []string // names of the key columns
+	keep       KeepMode // first, last, max or min
+	keepColumn SortKey  // column compared for max and min
+}
+
+/*
+ParseDeduplication checks the key columns and parses the keep rule:
+"first", "last", "max(column)" or "min(column)".
+Without key columns whole rows are compared.
+*/
+func ParseDeduplication(columns []string, keep string, scheme Scheme) (Deduplication, error) {
+	dedupe := Deduplication{}
+
+	for _, columnName := range columns {
+		column, ok := scheme.Columns[columnName]
+		if !ok {
+			return dedupe, fmt.Errorf("deduplication by non-existent column '%s'", columnName)
+		}
+		dedupe.keyIndices = append(dedupe.keyIndices, column.Index)
+		dedupe.keyColumns = append(dedupe.keyColumns, columnName)
+	}
+
+	re := regexp.MustCompile(`^(?i)(first|last|max|min)(?:\((.+)\))?$`)
+	keepParts := re.FindStringSubmatch(strings.TrimSpace(keep))
+	// [Whole string, mode, column]
+	if keepParts == nil {
+		return dedupe, fmt.Errorf("unknown keep rule '%s'", keep)
+	}
+	dedupe.keep = KeepMode(strings.ToLower(keepParts[1]))
+
+	switch dedupe.keep {
+	case KeepFirst, KeepLast:
+		if keepParts[2] != "" {
+			return dedupe, fmt.Errorf("keep rule '%s' doesn't take a column", dedupe.keep)
+		}
+	case KeepMax, KeepMin:
+		column, ok := scheme.Columns[keepParts[2]]
+		if !ok {
+			return dedupe, fmt.Errorf("keep rule '%s' for non-existent column '%s'", dedupe.keep, keepParts[2])
+		}
+		dedupe.keepColumn = SortKey{
+			column:     keepParts[2],
+			index:      column.Index,
+			columnType: column.ColumnType,
+			descending: dedupe.keep == KeepMax,
+		}
+	}
+
+	return dedupe, nil
+}
+
+// Columns returns the key columns and the column compared by max and min, if any
+func (d Deduplication) Columns() []string {
+	columns := append([]string{}, d.keyColumns...)
+	if d.keepColumn.column != "" {
+		columns = append(columns, d.keepColumn.column)
+	}
+	return columns
+}
+
+func (d Deduplication) key(record []string) string {
+	if len(d.keyIndices) == 0 {
+		return recordKey(record)
+	}
+	values := make([]string, len(d.keyIndices))
+	for i, index := range d.keyIndices {
+		values[i] = record[index]
+	}
+	return recordKey(values)
+}
+
+// replaces reports whether the candidate row replaces the kept one; ties keep the earlier row
+func (d Deduplication) replaces(kept, candidate []string) (bool, error) {
+	switch d.keep {
+	case KeepLast:
+		return true, nil
+	case KeepMax, KeepMin:
+		order, err := compareByKeys(candidate, kept, []SortKey{d.keepColumn})
+		return order < 0, err
+	}
+	return false, nil
+}
+
+// recordKey joins values into a map key that can't be produced by other values
+func recordKey(values []string) string {
+	var b strings.Builder
+	for _, v := range values {
+		b.WriteString(strconv.Itoa(len(v)))
+		b.WriteByte(':')
+		b.WriteString(v)
+	}
+	return b.String()
+}
+
+/*
+Deduper drops duplicate rows and emits the kept rows in the order of their position in the input.
+Once more than maxKeys unique keys are collected, the rows are spilled into hash partitions
+on disk, and every partition is deduplicated separately.
+*/
+type Deduper struct {
+	dedupe     Deduplication
+	maxKeys    int    // 0 means the keys are never spilled
+	tempDir    string // directory for the partitions, the system default if empty
+	kept       map[string]dedupeEntry
+	seq        int // number of added rows
+	dropped    int
+	partitions []*os.File
+	writers    []*csv.Writer
+}
+
+type dedupeEntry struct {
+	record []string
+	seq    int // position of the row in the input
+}
+
+func NewDeduper(dedupe Deduplication, maxKeys int, tempDir string) *Deduper {
+	return &Deduper{
+		dedupe:  dedupe,
+		maxKeys: maxKeys,
+		tempDir: tempDir,
+		kept:    make(map[string]dedupeEntry),
+	}
+}
+
+// Dropped returns the number of duplicate rows dropped so far
+func (d *Deduper) Dropped() int {
+	return d.dropped
+}
+
+func (d *Deduper) Add(record []string) error {
+	seq := d.seq
+	d.seq++
+
+	if d.partitions != nil {
+		return d.writePartition(dedupeEntry{record: record, seq: seq})
+	}
+
+	if err := d.keep(d.kept, dedupeEntry{record: record, seq: seq}); err != nil {
+		return err
+	}
+	if d.maxKeys > 0 && len(d.kept) > d.maxKeys {
+		return d.spill()
+	}
+	return nil
+}
+
+// Each passes the kept rows to emit
+func (d *Deduper) Each(emit func([]string) error) error {
+	err := d.each(emit)
+	if errors.Is(err, ErrStop) {
+		return nil
+	}
+	return err
+}
+
+// Close removes the partitions
+func (d *Deduper) Close() error {
+	var errs []error
+	for _, f := range d.partitions {
+		f.Close()
+		if err := os.Remove(f.Name()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	d.partitions = nil
+	d.writers = nil
+	return errors.Join(errs...)
+}
+
+func (d *Deduper) keep(kept map[string]dedupeEntry, entry dedupeEntry) error {
+	key := d.dedupe.key(entry.record)
+	current, ok := kept[key]
+	if !ok {
+		kept[key] = entry
+		return nil
+	}
+
+	d.dropped++
+	replace, err := d.dedupe.replaces(current.record, entry.record)
+	if err != nil {
+		return err
+	}
+	if replace {
+		kept[key] = entry
+	}
+	return nil
+}
+
+func (d *Deduper) each(emit func([]string) error) error {
+	if d.partitions == nil {
+		for _, entry := range sortedEntries(d.kept) {
+			if err := emit(entry.record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	/*
+		Every partition is deduplicated in memory and written back sorted by position,
+		then the partitions are merged by position to restore the input order
+	*/
+	seqKey := []SortKey{{column: "position", index: 0, columnType: TypeInt}}
+	var runs []string
+	defer func() {
+		for _, path := range runs {
+			os.Remove(path)
+		}
+	}()
+
+	for i, f := range d.partitions {
+		d.writers[i].Flush()
+		if err := d.writers[i].Error(); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		kept := make(map[string]dedupeEntry)
+		reader := csv.NewReader(f)
+		reader.FieldsPerRecord = -1
+		for {
+			row, err := reader.Read()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return err
+			}
+			seq, err := strconv.Atoi(row[0])
+			if err != nil {
+				return err
+			}
+			if err := d.keep(kept, dedupeEntry{record: row[1:], seq: seq}); err != nil {
+				return err
+			}
+		}
+
+		path, err := writeRun(d.tempDir, func(w *csv.Writer) error {
+			for _, entry := range sortedEntries(kept) {
+				if err := w.Write(append([]string{strconv.Itoa(entry.seq)}, entry.record...)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		runs = append(runs, path)
+	}
+
+	return mergeRuns(runs, seqKey, func(row []string) error {
+		return emit(row[1:])
+	})
+}
+
+// spill moves the kept rows into the partitions, all further rows go there directly
+func (d *Deduper) spill() error {
+	for range dedupePartitions {
+		f, err := os.CreateTemp(d.tempDir, "go-data-tool-dedupe-*.csv")
+		if err != nil {
+			return err
+		}
+		d.partitions = append(d.partitions, f)
+		d.writers = append(d.writers, csv.NewWriter(f))
+	}
+
+	// Rows already counted as dropped are gone, the rest is deduplicated again per partition
+	for _, entry := range sortedEntries(d.kept) {
+		if err := d.writePartition(entry); err != nil {
+			return err
+		}
+	}
+	d.kept = nil
+	return nil
+}
+
+func (d *Deduper) writePartition(entry dedupeEntry) error {
+	h := fnv.New32a()
+	h.Write([]byte(d.dedupe.key(entry.record)))
+	partition := h.Sum32() % uint32(len(d.writers))
+	return d.writers[partition].Write(append([]string{strconv.Itoa(entry.seq)}, entry.record...))
+}
+
+func sortedEntries(kept map[string]dedupeEntry) []dedupeEntry {
+	entries := make([]dedupeEntry, 0, len(kept))
+	for _, entry := range kept {
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(a, b dedupeEntry) int {
+		return a.seq - b.seq
+	})
+	return entries
+}
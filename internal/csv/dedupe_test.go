@@ -0,0 +1,174 @@
+package csv
+
+import (
+	"math/rand"
+	"os"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+var dedupeScheme = Scheme{
+	Headers: []string{"id", "amount", "seq"},
+	Columns: map[string]ColumnInfo{
+		"id":     {Index: 0, ColumnType: TypeString},
+		"amount": {Index: 1, ColumnType: TypeInt},
+		"seq":    {Index: 2, ColumnType: TypeInt},
+	},
+}
+
+// dedupeRecords returns rows with a few repeated ids and amounts
+func dedupeRecords(n int) [][]string {
+	random := rand.New(rand.NewSource(1))
+	records := make([][]string, n)
+	for i := range records {
+		records[i] = []string{
+			"id" + strconv.Itoa(random.Intn(20)),
+			strconv.Itoa(random.Intn(10) - 5),
+			strconv.Itoa(i),
+		}
+	}
+	return records
+}
+
+/*
+dedupeReference keeps the rows by the rule the simple way: the first or last row of an id,
+or the row with the greatest or smallest amount, the earliest of them on ties.
+The kept rows are ordered by their position.
+*/
+func dedupeReference(records [][]string, keep KeepMode) [][]string {
+	kept := make(map[string]int)
+	for i, record := range records {
+		j, ok := kept[record[0]]
+		if !ok {
+			kept[record[0]] = i
+			continue
+		}
+		candidate, _ := strconv.Atoi(record[1])
+		current, _ := strconv.Atoi(records[j][1])
+		if keep == KeepLast || keep == KeepMax && candidate > current || keep == KeepMin && candidate < current {
+			kept[record[0]] = i
+		}
+	}
+	var positions []int
+	for _, i := range kept {
+		positions = append(positions, i)
+	}
+	slices.Sort(positions)
+	var rows [][]string
+	for _, i := range positions {
+		rows = append(rows, records[i])
+	}
+	return rows
+}
+
+func TestDeduperKeepRules(t *testing.T) {
+	records := dedupeRecords(200)
+	tests := []struct {
+		keep string
+		mode KeepMode
+	}{
+		{"first", KeepFirst},
+		{"LAST", KeepLast},
+		{"max(amount)", KeepMax},
+		{"min(amount)", KeepMin},
+	}
+	for _, test := range tests {
+		dedupe, err := ParseDeduplication([]string{"id"}, test.keep, dedupeScheme)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := dedupeReference(records, test.mode)
+
+		// Five keys are too many, so the rows are spilled into the partitions early
+		for _, maxKeys := range []int{0, 5} {
+			tempDir := t.TempDir()
+			deduper := NewDeduper(dedupe, maxKeys, tempDir)
+			for _, record := range records {
+				if err := deduper.Add(record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if spilled := deduper.partitions != nil; spilled != (maxKeys != 0) {
+				t.Errorf("%s, %d keys: spilled %t", test.keep, maxKeys, spilled)
+			}
+			var got [][]string
+			if err := deduper.Each(func(record []string) error {
+				got = append(got, record)
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.EqualFunc(got, want, slices.Equal) {
+				t.Errorf("%s, %d keys: rows\n%q\nwant\n%q", test.keep, maxKeys, got, want)
+			}
+			if dropped := len(records) - len(want); deduper.Dropped() != dropped {
+				t.Errorf("%s, %d keys: %d rows dropped, want %d", test.keep, maxKeys, deduper.Dropped(), dropped)
+			}
+
+			if err := deduper.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+				t.Errorf("%s, %d keys: %d files left after Close", test.keep, maxKeys, len(entries))
+			}
+		}
+	}
+}
+
+func TestDeduperWholeRows(t *testing.T) {
+	records := [][]string{{"a", "1", "x"}, {"a", "1", "y"}, {"a", "1", "x"}, {"a", "bc", ""}, {"ab", "c", ""}}
+	dedupe, err := ParseDeduplication(nil, "first", dedupeScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, maxKeys := range []int{0, 1} {
+		deduper := NewDeduper(dedupe, maxKeys, t.TempDir())
+		defer deduper.Close()
+		for _, record := range records {
+			deduper.Add(record)
+		}
+		var got [][]string
+		deduper.Each(func(record []string) error {
+			got = append(got, record)
+			return nil
+		})
+		want := [][]string{{"a", "1", "x"}, {"a", "1", "y"}, {"a", "bc", ""}, {"ab", "c", ""}}
+		if !slices.EqualFunc(got, want, slices.Equal) || deduper.Dropped() != 1 {
+			t.Errorf("%d keys: rows %q with %d dropped, want %q and 1", maxKeys, got, deduper.Dropped(), want)
+		}
+	}
+}
+
+func TestRecordKey(t *testing.T) {
+	tests := [][2][]string{
+		{{"a", "bc"}, {"ab", "c"}},
+		{{"a_b", "c"}, {"a", "b_c"}},
+		{{"1:a", ""}, {"1", "a"}},
+		{{""}, {}},
+		{{"", ""}, {""}},
+	}
+	for _, test := range tests {
+		if recordKey(test[0]) == recordKey(test[1]) {
+			t.Errorf("%q and %q have the same key %q", test[0], test[1], recordKey(test[0]))
+		}
+	}
+}
+
+func TestParseDeduplicationErrors(t *testing.T) {
+	tests := []struct {
+		columns []string
+		keep    string
+	}{
+		{[]string{"name"}, "first"},
+		{[]string{"id"}, "newest"},
+		{[]string{"id"}, "first(amount)"},
+		{[]string{"id"}, "max"},
+		{[]string{"id"}, "max(price)"},
+	}
+	for _, test := range tests {
+		if _, err := ParseDeduplication(test.columns, test.keep, dedupeScheme); err == nil {
+			t.Errorf("%v keeping %s accepted", test.columns, test.keep)
+		}
+	}
+}
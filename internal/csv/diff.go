@@ -0,0 +1,450 @@
+package csv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"math"
+	"slices"
+	"strings"
+)
+
+type ChangeKind string
+
+// ChangeKind defines how a row differs between two snapshots
+const (
+	RowAdded   ChangeKind = "added"
+	RowRemoved ChangeKind = "removed"
+	RowChanged ChangeKind = "changed"
+)
+
+// Difference of a single row between the old and the new snapshot
+type RowDiff struct {
+	Kind    ChangeKind
+	Key     []string       // values of the key columns
+	Old     []string       // row of the old snapshot, nil for added rows
+	New     []string       // row of the new snapshot, nil for removed rows
+	Changes []ColumnChange // changed columns of changed rows
+}
+
+type ColumnChange struct {
+	Column string
+	Old    string
+	New    string
+}
+
+// Diff describes how two snapshots of a CSV file are matched and compared
+type Diff struct {
+	keyNames       []string
+	oldKeys        []SortKey
+	newKeys        []SortKey
+	keyTypes       []ColumnTypeInterface
+	columns        []diffColumn // compared columns present in both snapshots
+	addedColumns   []string     // columns present only in the new snapshot
+	removedColumns []string     // columns present only in the old snapshot
+	absTolerance   float64
+	relTolerance   float64
+}
+
+type diffColumn struct {
+	name       string
+	oldIndex   int
+	newIndex   int
+	columnType ColumnTypeInterface
+}
+
+/*
+ParseDiff matches the key columns and the compared columns of the two snapshots.
+Columns are matched by name; ignored columns and columns present in only one snapshot are not compared.
+Numeric values are considered equal when they differ by at most absTolerance
+or by at most relTolerance relative to the greater absolute value.
+*/
+func ParseDiff(keyColumns []string, ignore []string, oldScheme, newScheme Scheme, absTolerance, relTolerance float64) (Diff, error) {
+	diff := Diff{absTolerance: absTolerance, relTolerance: relTolerance}
+
+	if len(keyColumns) == 0 {
+		return diff, errors.New("no key columns")
+	}
+	if absTolerance < 0 || relTolerance < 0 {
+		return diff, errors.New("tolerance must not be negative")
+	}
+
+	isKey := make(map[string]bool)
+	for _, name := range keyColumns {
+		oldColumn, ok := oldScheme.Columns[name]
+		if !ok {
+			return diff, fmt.Errorf("key column '%s' doesn't exist in the old file", name)
+		}
+		newColumn, ok := newScheme.Columns[name]
+		if !ok {
+			return diff, fmt.Errorf("key column '%s' doesn't exist in the new file", name)
+		}
+		keyType, err := commonKeyType(oldColumn.ColumnType, newColumn.ColumnType)
+		if err != nil {
+			return diff, fmt.Errorf("key column '%s': %w", name, err)
+		}
+
+		diff.keyNames = append(diff.keyNames, name)
+		diff.keyTypes = append(diff.keyTypes, keyType)
+		diff.oldKeys = append(diff.oldKeys, SortKey{column: name, index: oldColumn.Index, columnType: keyType})
+		diff.newKeys = append(diff.newKeys, SortKey{column: name, index: newColumn.Index, columnType: keyType})
+		isKey[name] = true
+	}
+
+	for _, name := range ignore {
+		if _, ok := oldScheme.Columns[name]; !ok {
+			if _, ok := newScheme.Columns[name]; !ok {
+				return diff, fmt.Errorf("ignored column '%s' doesn't exist", name)
+			}
+		}
+	}
+
+	for _, name := range oldScheme.Headers {
+		newColumn, ok := newScheme.Columns[name]
+		if !ok {
+			diff.removedColumns = append(diff.removedColumns, name)
+			continue
+		}
+		if isKey[name] || slices.Contains(ignore, name) {
+			continue
+		}
+		oldColumn := oldScheme.Columns[name]
+		diff.columns = append(diff.columns, diffColumn{
+			name:       name,
+			oldIndex:   oldColumn.Index,
+			newIndex:   newColumn.Index,
+			columnType: unifyTypes(oldColumn.ColumnType, newColumn.ColumnType),
+		})
+	}
+	for _, name := range newScheme.Headers {
+		if _, ok := oldScheme.Columns[name]; !ok {
+			diff.addedColumns = append(diff.addedColumns, name)
+		}
+	}
+
+	return diff, nil
+}
+
+// KeyColumns returns the names of the key columns
+func (d Diff) KeyColumns() []string {
+	return d.keyNames
+}
+
+// Columns returns the names of the compared columns
+func (d Diff) Columns() []string {
+	names := make([]string, len(d.columns))
+	for i, column := range d.columns {
+		names[i] = column.name
+	}
+	return names
+}
+
+// AddedColumns returns the columns present only in the new snapshot
+func (d Diff) AddedColumns() []string {
+	return d.addedColumns
+}
+
+// RemovedColumns returns the columns present only in the old snapshot
+func (d Diff) RemovedColumns() []string {
+	return d.removedColumns
+}
+
+/*
+Run sorts both snapshots by the key with the external Sorter and walks them side by side,
+passing every difference to emit in key order.
+Rows with repeated keys are paired in the order they appear in the files.
+*/
+func (d Diff) Run(oldPath string, oldScheme Scheme, newPath string, newScheme Scheme, bufferSize int, tempDir string, emit func(RowDiff) error) error {
+	oldSorter := NewSorter(d.oldKeys, bufferSize, tempDir)
+	defer oldSorter.Close()
+	if err := ReadCSV(oldPath, oldScheme, nil, oldSorter.Add); err != nil {
+		return err
+	}
+	newSorter := NewSorter(d.newKeys, bufferSize, tempDir)
+	defer newSorter.Close()
+	if err := ReadCSV(newPath, newScheme, nil, newSorter.Add); err != nil {
+		return err
+	}
+
+	var oldErr, newErr error
+	nextOld, stopOld := iter.Pull(sorted(oldSorter, &oldErr))
+	defer stopOld()
+	nextNew, stopNew := iter.Pull(sorted(newSorter, &newErr))
+	defer stopNew()
+
+	oldRow, hasOld := nextOld()
+	newRow, hasNew := nextNew()
+	for hasOld || hasNew {
+		order := 0
+		switch {
+		case !hasNew:
+			order = -1
+		case !hasOld:
+			order = 1
+		default:
+			var err error
+			order, err = d.compareKeys(oldRow, newRow)
+			if err != nil {
+				return err
+			}
+		}
+
+		var err error
+		switch {
+		case order < 0:
+			err = emit(RowDiff{Kind: RowRemoved, Key: d.key(oldRow, d.oldKeys), Old: oldRow})
+			oldRow, hasOld = nextOld()
+		case order > 0:
+			err = emit(RowDiff{Kind: RowAdded, Key: d.key(newRow, d.newKeys), New: newRow})
+			newRow, hasNew = nextNew()
+		default:
+			var changes []ColumnChange
+			changes, err = d.compareRows(oldRow, newRow)
+			if err == nil && len(changes) != 0 {
+				err = emit(RowDiff{Kind: RowChanged, Key: d.key(newRow, d.newKeys), Old: oldRow, New: newRow, Changes: changes})
+			}
+			oldRow, hasOld = nextOld()
+			newRow, hasNew = nextNew()
+		}
+		if errors.Is(err, ErrStop) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return errors.Join(oldErr, newErr)
+}
+
+func (d Diff) key(record []string, keys []SortKey) []string {
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = record[key.index]
+	}
+	return values
+}
+
+func (d Diff) compareKeys(oldRow, newRow []string) (int, error) {
+	for i, keyType := range d.keyTypes {
+		order, err := keyType.Order(oldRow[d.oldKeys[i].index], newRow[d.newKeys[i].index])
+		if err != nil {
+			return 0, fmt.Errorf("key column '%s': %w", d.keyNames[i], err)
+		}
+		if order != 0 {
+			return order, nil
+		}
+	}
+	return 0, nil
+}
+
+func (d Diff) compareRows(oldRow, newRow []string) ([]ColumnChange, error) {
+	var changes []ColumnChange
+	for _, column := range d.columns {
+		oldValue, newValue := oldRow[column.oldIndex], newRow[column.newIndex]
+		equal, err := d.equalValues(column.columnType, oldValue, newValue)
+		if err != nil {
+			return nil, fmt.Errorf("column '%s': %w", column.name, err)
+		}
+		if !equal {
+			changes = append(changes, ColumnChange{Column: column.name, Old: oldValue, New: newValue})
+		}
+	}
+	return changes, nil
+}
+
+// equalValues compares values by their type, numbers within the tolerance are equal
+func (d Diff) equalValues(columnType ColumnTypeInterface, oldValue, newValue string) (bool, error) {
+	if oldValue == newValue {
+		return true, nil
+	}
+	if columnType == TypeString || oldValue == "" || newValue == "" {
+		return false, nil
+	}
+	if d.absTolerance == 0 && d.relTolerance == 0 {
+		return columnType.Compare(oldValue, newValue, Equal)
+	}
+
+	a, err := TypeFloat.ParseTyped(oldValue)
+	if err != nil {
+		return false, err
+	}
+	b, err := TypeFloat.ParseTyped(newValue)
+	if err != nil {
+		return false, err
+	}
+	delta := math.Abs(a - b)
+	return delta <= d.absTolerance || delta <= d.relTolerance*math.Max(math.Abs(a), math.Abs(b)), nil
+}
+
+// DiffSummary counts the differences between two snapshots
+type DiffSummary struct {
+	Added         int
+	Removed       int
+	Changed       int
+	ColumnChanges map[string]int // number of changed rows per column
+}
+
+func NewDiffSummary() *DiffSummary {
+	return &DiffSummary{ColumnChanges: make(map[string]int)}
+}
+
+func (s *DiffSummary) Add(row RowDiff) error {
+	switch row.Kind {
+	case RowAdded:
+		s.Added++
+	case RowRemoved:
+		s.Removed++
+	case RowChanged:
+		s.Changed++
+		for _, change := range row.Changes {
+			s.ColumnChanges[change.Column]++
+		}
+	}
+	return nil
+}
+
+// Empty reports whether no differences were found
+func (s *DiffSummary) Empty() bool {
+	return s.Added == 0 && s.Removed == 0 && s.Changed == 0
+}
+
+// Write prints the summary in a human readable form
+func (s *DiffSummary) Write(w io.Writer, diff Diff) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Added rows:   %d\n", s.Added)
+	fmt.Fprintf(&b, "Removed rows: %d\n", s.Removed)
+	fmt.Fprintf(&b, "Changed rows: %d\n", s.Changed)
+	for _, column := range diff.Columns() {
+		if count := s.ColumnChanges[column]; count != 0 {
+			fmt.Fprintf(&b, "  %s: %d\n", column, count)
+		}
+	}
+	if len(diff.AddedColumns()) != 0 {
+		fmt.Fprintf(&b, "Added columns: %s\n", strings.Join(diff.AddedColumns(), ", "))
+	}
+	if len(diff.RemovedColumns()) != 0 {
+		fmt.Fprintf(&b, "Removed columns: %s\n", strings.Join(diff.RemovedColumns(), ", "))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+/*
+DiffCSVHeaders returns the headers of the CSV form of the differences:
+the change, the key columns, the column, its old and its new value.
+*/
+func DiffCSVHeaders(diff Diff) []string {
+	return append(append([]string{"change"}, diff.KeyColumns()...), "column", "old_value", "new_value")
+}
+
+/*
+DiffCSVRecords turns a difference into CSV records, one per column.
+Added and removed rows list all their compared columns.
+*/
+func DiffCSVRecords(diff Diff, row RowDiff) [][]string {
+	newRecord := func(column, oldValue, newValue string) []string {
+		record := append([]string{string(row.Kind)}, row.Key...)
+		return append(record, column, oldValue, newValue)
+	}
+
+	var records [][]string
+	switch row.Kind {
+	case RowChanged:
+		for _, change := range row.Changes {
+			records = append(records, newRecord(change.Column, change.Old, change.New))
+		}
+	case RowAdded:
+		for _, column := range diff.columns {
+			records = append(records, newRecord(column.name, "", row.New[column.newIndex]))
+		}
+	case RowRemoved:
+		for _, column := range diff.columns {
+			records = append(records, newRecord(column.name, row.Old[column.oldIndex], ""))
+		}
+	}
+	return records
+}
+
+/*
+JSONPatchWriter writes the differences as a JSON Patch (RFC 6902) document.
+Rows are addressed as "/key" (key values of composite keys are joined with commas),
+columns as "/key/column"; every replaced value is preceded by a "test" operation with the old value.
+*/
+type JSONPatchWriter struct {
+	w     io.Writer
+	diff  Diff
+	count int
+}
+
+type jsonPatchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value,omitempty"`
+}
+
+func NewJSONPatchWriter(w io.Writer, diff Diff) (*JSONPatchWriter, error) {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return nil, err
+	}
+	return &JSONPatchWriter{w: w, diff: diff}, nil
+}
+
+func (p *JSONPatchWriter) Add(row RowDiff) error {
+	rowPath := "/" + jsonPointerEscape(strings.Join(row.Key, ","))
+
+	var operations []jsonPatchOperation
+	switch row.Kind {
+	case RowAdded:
+		value := make(map[string]string)
+		for _, column := range p.diff.columns {
+			value[column.name] = row.New[column.newIndex]
+		}
+		for i, name := range p.diff.keyNames {
+			value[name] = row.Key[i]
+		}
+		operations = append(operations, jsonPatchOperation{Op: "add", Path: rowPath, Value: value})
+	case RowRemoved:
+		operations = append(operations, jsonPatchOperation{Op: "remove", Path: rowPath})
+	case RowChanged:
+		for _, change := range row.Changes {
+			columnPath := rowPath + "/" + jsonPointerEscape(change.Column)
+			operations = append(operations,
+				jsonPatchOperation{Op: "test", Path: columnPath, Value: change.Old},
+				jsonPatchOperation{Op: "replace", Path: columnPath, Value: change.New},
+			)
+		}
+	}
+
+	for _, operation := range operations {
+		data, err := json.Marshal(operation)
+		if err != nil {
+			return err
+		}
+		separator := ",\n  "
+		if p.count == 0 {
+			separator = "\n  "
+		}
+		if _, err := io.WriteString(p.w, separator+string(data)); err != nil {
+			return err
+		}
+		p.count++
+	}
+	return nil
+}
+
+// Close finishes the JSON document
+func (p *JSONPatchWriter) Close() error {
+	end := "\n]\n"
+	if p.count == 0 {
+		end = "]\n"
+	}
+	_, err := io.WriteString(p.w, end)
+	return err
+}
+
+func jsonPointerEscape(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
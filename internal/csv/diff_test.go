@@ -0,0 +1,242 @@
+package csv
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Prices are ints in the old snapshot and floats in the new one, "20" and "20.0" are equal
+var (
+	diffOldLines = []string{"id,name,price,updated", "3,Cid,30,a", "1,Ann,10,a", "2,Bob,20,a", "4,Dan,40,a"}
+	diffNewLines = []string{"id,name,price,updated", "2,Bob,20.0,b", "5,Eve,50,b", "1,Ann,11,a", "3,Cid,30,a"}
+)
+
+// parseDiff infers the schemes of the snapshots and matches them by the id column
+func parseDiff(t *testing.T, oldPath, newPath string, ignore []string, absTolerance, relTolerance float64) (Diff, Scheme, Scheme) {
+	t.Helper()
+	oldScheme, err := ParseCSVStructure(oldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newScheme, err := ParseCSVStructure(newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff, err := ParseDiff([]string{"id"}, ignore, oldScheme, newScheme, absTolerance, relTolerance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return diff, oldScheme, newScheme
+}
+
+// describe writes a difference like "changed 1 price:10>11"
+func describe(row RowDiff) string {
+	text := string(row.Kind) + " " + strings.Join(row.Key, ",")
+	for _, change := range row.Changes {
+		text += fmt.Sprintf(" %s:%s>%s", change.Column, change.Old, change.New)
+	}
+	return text
+}
+
+func TestDiffRun(t *testing.T) {
+	sortedLines := func(lines []string) []string {
+		rows := slices.Clone(lines[1:])
+		slices.Sort(rows)
+		return append([]string{lines[0]}, rows...)
+	}
+	tests := []struct {
+		name       string
+		old, new   []string
+		ignore     []string
+		bufferSize int
+		want       []string
+	}{
+		{"unsorted", diffOldLines, diffNewLines, nil, 0, []string{
+			"changed 1 price:10>11", "changed 2 updated:a>b", "removed 4", "added 5",
+		}},
+		{"sorted", sortedLines(diffOldLines), sortedLines(diffNewLines), nil, 0, []string{
+			"changed 1 price:10>11", "changed 2 updated:a>b", "removed 4", "added 5",
+		}},
+		{"spilled", diffOldLines, diffNewLines, nil, 2, []string{
+			"changed 1 price:10>11", "changed 2 updated:a>b", "removed 4", "added 5",
+		}},
+		{"ignored column", diffOldLines, diffNewLines, []string{"updated"}, 2, []string{
+			"changed 1 price:10>11", "removed 4", "added 5",
+		}},
+	}
+	for _, test := range tests {
+		oldPath, newPath := writeCSV(t, "old.csv", test.old...), writeCSV(t, "new.csv", test.new...)
+		diff, oldScheme, newScheme := parseDiff(t, oldPath, newPath, test.ignore, 0, 0)
+
+		tempDir := t.TempDir()
+		runs := 0
+		var got []string
+		err := diff.Run(oldPath, oldScheme, newPath, newScheme, test.bufferSize, tempDir, func(row RowDiff) error {
+			entries, _ := os.ReadDir(tempDir)
+			runs = max(runs, len(entries))
+			got = append(got, describe(row))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s: differences %q, want %q", test.name, got, test.want)
+		}
+		if spilled := runs != 0; spilled != (test.bufferSize != 0) {
+			t.Errorf("%s: %d runs on disk", test.name, runs)
+		}
+		if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+			t.Errorf("%s: %d runs left on disk", test.name, len(entries))
+		}
+	}
+}
+
+func TestDiffTolerance(t *testing.T) {
+	tests := []struct {
+		abs, rel float64
+		old, new string
+		equal    bool
+	}{
+		{0, 0, "1", "1.0", true},
+		{0, 0, "1", "1.5", false},
+		{0.5, 0, "1", "1.5", true},
+		{0.5, 0, "1.5", "1", true},
+		{0.5, 0, "1", "1.5000001", false},
+		{0, 0.5, "1", "2", true}, // relative to the greater value
+		{0, 0.5, "-1", "-2", true},
+		{0, 0.5, "1", "2.0000001", false},
+		{0.5, 0.5, "10", "20", true},
+		{0.5, 0.5, "10", "21", false},
+		// Nulls are only equal to nulls
+		{1, 1, "", "0", false},
+		{1, 1, "", "", true},
+	}
+	for _, test := range tests {
+		diff := Diff{absTolerance: test.abs, relTolerance: test.rel}
+		equal, err := diff.equalValues(TypeFloat, test.old, test.new)
+		if err != nil || equal != test.equal {
+			t.Errorf("abs %g, rel %g: %s and %s equal %t, %v, want %t", test.abs, test.rel, test.old, test.new, equal, err, test.equal)
+		}
+	}
+
+	// Strings are never equal within a tolerance
+	if equal, _ := (Diff{absTolerance: 1}).equalValues(TypeString, "1", "1.5"); equal {
+		t.Error("strings 1 and 1.5 equal")
+	}
+}
+
+func TestParseDiffErrors(t *testing.T) {
+	oldPath, newPath := writeCSV(t, "old.csv", diffOldLines...), writeCSV(t, "new.csv", "id,title", "1,a")
+	oldScheme, _ := ParseCSVStructure(oldPath)
+	newScheme, _ := ParseCSVStructure(newPath)
+
+	diff, err := ParseDiff([]string{"id"}, []string{"title"}, oldScheme, newScheme, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Columns()) != 0 || !slices.Equal(diff.AddedColumns(), []string{"title"}) || !slices.Equal(diff.RemovedColumns(), []string{"name", "price", "updated"}) {
+		t.Errorf("columns %v, added %v, removed %v", diff.Columns(), diff.AddedColumns(), diff.RemovedColumns())
+	}
+
+	tests := []struct {
+		key, ignore []string
+		abs         float64
+		want        string
+	}{
+		{nil, nil, 0, "no key columns"},
+		{[]string{"name"}, nil, 0, "doesn't exist in the new file"},
+		{[]string{"title"}, nil, 0, "doesn't exist in the old file"},
+		{[]string{"id"}, []string{"amount"}, 0, "ignored column 'amount' doesn't exist"},
+		{[]string{"id"}, nil, -1, "tolerance must not be negative"},
+	}
+	for _, test := range tests {
+		_, err := ParseDiff(test.key, test.ignore, oldScheme, newScheme, test.abs, 0)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("key %v, ignore %v: error %v, want %q", test.key, test.ignore, err, test.want)
+		}
+	}
+}
+
+func TestDiffCSVRecords(t *testing.T) {
+	oldPath, newPath := writeCSV(t, "old.csv", diffOldLines...), writeCSV(t, "new.csv", diffNewLines...)
+	diff, oldScheme, newScheme := parseDiff(t, oldPath, newPath, []string{"updated"}, 0, 0)
+
+	if want := []string{"change", "id", "column", "old_value", "new_value"}; !slices.Equal(DiffCSVHeaders(diff), want) {
+		t.Errorf("headers %v, want %v", DiffCSVHeaders(diff), want)
+	}
+	var got []string
+	err := diff.Run(oldPath, oldScheme, newPath, newScheme, 0, "", func(row RowDiff) error {
+		for _, record := range DiffCSVRecords(diff, row) {
+			got = append(got, strings.Join(record, ","))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Added and removed rows list every compared column
+	want := []string{
+		"changed,1,price,10,11",
+		"removed,4,name,Dan,", "removed,4,price,40,",
+		"added,5,name,,Eve", "added,5,price,,50",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("records %q, want %q", got, want)
+	}
+}
+
+func TestJSONPatchWriter(t *testing.T) {
+	// Values changed to an empty string and to 0 are still written
+	oldPath := writeCSV(t, "old.csv", "id,name,price", "1,Ann,10", "2,Bob,20", "a/b,Cid,30")
+	newPath := writeCSV(t, "new.csv", "id,name,price", "1,,0", "3,Eve,", "a/b~c,Dan,40")
+	diff, oldScheme, newScheme := parseDiff(t, oldPath, newPath, nil, 0, 0)
+
+	var b bytes.Buffer
+	patch, err := NewJSONPatchWriter(&b, diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := diff.Run(oldPath, oldScheme, newPath, newScheme, 0, "", patch.Add); err != nil {
+		t.Fatal(err)
+	}
+	if err := patch.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var operations []map[string]any
+	if err := json.Unmarshal(b.Bytes(), &operations); err != nil {
+		t.Fatalf("invalid JSON %s: %v", b.String(), err)
+	}
+	want := []map[string]any{
+		{"op": "test", "path": "/1/name", "value": "Ann"},
+		{"op": "replace", "path": "/1/name", "value": ""},
+		{"op": "test", "path": "/1/price", "value": "10"},
+		{"op": "replace", "path": "/1/price", "value": "0"},
+		{"op": "remove", "path": "/2"},
+		{"op": "add", "path": "/3", "value": map[string]any{"id": "3", "name": "Eve", "price": ""}},
+		{"op": "remove", "path": "/a~1b"},
+		{"op": "add", "path": "/a~1b~0c", "value": map[string]any{"id": "a/b~c", "name": "Dan", "price": "40"}},
+	}
+	if got, want := fmt.Sprint(operations), fmt.Sprint(want); got != want {
+		t.Errorf("operations\n%s\nwant\n%s", got, want)
+	}
+	for _, operation := range operations {
+		if _, ok := operation["value"]; ok == (operation["op"] == "remove") {
+			t.Errorf("operation %v", operation)
+		}
+	}
+
+	// No differences make an empty document
+	b.Reset()
+	patch, _ = NewJSONPatchWriter(&b, diff)
+	patch.Close()
+	if b.String() != "[]\n" {
+		t.Errorf("empty patch %q", b.String())
+	}
+}
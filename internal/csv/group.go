@@ -0,0 +1,113 @@
+package csv
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+Grouper groups rows by the grouping columns and aggregates every group.
+Groups are emitted in the order of their first appearance, so the output is stable between runs.
+Without grouping columns all rows are aggregated into a single record.
+*/
+type Grouper struct {
+	scheme       Scheme
+	aggregations []Aggregator
+	groups       []string
+	// Map for the unique aggregation columns
+	aggragatedColumns map[string]bool
+	/*
+		Map for grouping aggregated values
+		{
+			"groupKey": {
+				"columnName": ["1", "2", "3"...]
+			}
+		}
+	*/
+	groupingMeasuresMap map[string]map[string][]string
+	/*
+		Map for storing group fields
+		{
+			"groupKey": ["1", "2", "3"...]
+		}
+	*/
+	groupingAttributesMap map[string][]string
+	// Group keys in order of first appearance
+	groupOrder []string
+}
+
+func NewGrouper(scheme Scheme, aggregations []Aggregator, groups []string) *Grouper {
+	aggragatedColumns := make(map[string]bool)
+	for _, v := range aggregations {
+		aggragatedColumns[v.Column()] = true
+	}
+	return &Grouper{
+		scheme:                scheme,
+		aggregations:          aggregations,
+		groups:                groups,
+		aggragatedColumns:     aggragatedColumns,
+		groupingMeasuresMap:   make(map[string]map[string][]string),
+		groupingAttributesMap: make(map[string][]string),
+	}
+}
+
+func (g *Grouper) Add(record []string) error {
+	// Key for grouping, stays empty when only aggregations are specified
+	groupKey := ""
+	if len(g.groups) != 0 {
+		groupKey = generateGroupKey(record, g.groups, g.scheme)
+	}
+	// Generate group attributes on the first appearance of the group
+	if _, ok := g.groupingAttributesMap[groupKey]; !ok {
+		groupAttributes := make([]string, len(g.groups))
+		for i, v := range g.groups {
+			groupAttributes[i] = record[g.scheme.Columns[v].Index]
+		}
+		g.groupingAttributesMap[groupKey] = groupAttributes
+		g.groupOrder = append(g.groupOrder, groupKey)
+	}
+	/*
+		If aggregations are specified,
+		save data for grouping
+	*/
+	if len(g.aggregations) != 0 {
+		groupingColumns, ok := g.groupingMeasuresMap[groupKey]
+		if !ok {
+			groupingColumns = make(map[string][]string)
+		}
+		for columnName := range g.aggragatedColumns {
+			groupingValues := groupingColumns[columnName]
+			groupingValues = append(groupingValues, record[g.scheme.Columns[columnName].Index])
+			groupingColumns[columnName] = groupingValues
+		}
+		g.groupingMeasuresMap[groupKey] = groupingColumns
+	}
+	return nil
+}
+
+// Groups returns the number of groups found so far
+func (g *Grouper) Groups() int {
+	return len(g.groupOrder)
+}
+
+// Each passes one aggregated record per group
+func (g *Grouper) Each(emit func([]string) error) error {
+	for _, key := range g.groupOrder {
+		currentRecord := make([]string, len(g.aggregations)+len(g.groups))
+		copy(currentRecord, g.groupingAttributesMap[key])
+		for i, aggregation := range g.aggregations {
+			aggregationResult, err := aggregation.Aggregate(g.groupingMeasuresMap[key][aggregation.Column()])
+			if err != nil {
+				return fmt.Errorf("%s(%s): %w", aggregation.AggregationType(), aggregation.Column(), err)
+			}
+			currentRecord[i+len(g.groups)] = aggregationResult
+		}
+		if err := emit(currentRecord); err != nil {
+			if errors.Is(err, ErrStop) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
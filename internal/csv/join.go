@@ -0,0 +1,531 @@
+package csv
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"os"
+	"strings"
+)
+
+type JoinType string
+
+// JoinType defines which rows of the two files make it into the result
+const (
+	JoinInner JoinType = "inner"
+	JoinLeft  JoinType = "left"
+	JoinRight JoinType = "right"
+	JoinFull  JoinType = "full"
+	JoinSemi  JoinType = "semi" // left rows with at least one match
+	JoinAnti  JoinType = "anti" // left rows without matches
+)
+
+// Join describes how the rows of two CSV files are matched and combined
+type Join struct {
+	joinType     JoinType
+	leftKeys     []int                 // indices of the key columns in the left file
+	rightKeys    []int                 // indices of the key columns in the right file
+	keyTypes     []ColumnTypeInterface // common types used to compare the keys
+	leftWidth    int
+	rightColumns []int // indices of the right columns added to the result
+	scheme       Scheme
+}
+
+/*
+ParseJoin matches the key columns of both files and builds the scheme of the result.
+A key is a column name present in both files or a pair "left_column=right_column".
+Numeric keys are compared as numbers, so an int key can be joined with a float key,
+but numeric keys can't be joined with string keys.
+Columns present in both files get the prefixes, the right key columns are not repeated.
+*/
+func ParseJoin(on []string, joinType string, left, right Scheme, leftPrefix, rightPrefix string) (Join, error) {
+	join := Join{joinType: JoinType(strings.ToLower(joinType)), leftWidth: len(left.Headers)}
+
+	switch join.joinType {
+	case JoinInner, JoinLeft, JoinRight, JoinFull, JoinSemi, JoinAnti:
+	default:
+		return join, fmt.Errorf("unknown join type '%s'", joinType)
+	}
+
+	if len(on) == 0 {
+		return join, errors.New("no key columns")
+	}
+	rightKeyColumns := make(map[int]bool)
+	for _, key := range on {
+		leftName, rightName, found := strings.Cut(key, "=")
+		if !found {
+			rightName = leftName
+		}
+		leftName, rightName = strings.TrimSpace(leftName), strings.TrimSpace(rightName)
+
+		leftColumn, ok := left.Columns[leftName]
+		if !ok {
+			return join, fmt.Errorf("key column '%s' doesn't exist in the left file", leftName)
+		}
+		rightColumn, ok := right.Columns[rightName]
+		if !ok {
+			return join, fmt.Errorf("key column '%s' doesn't exist in the right file", rightName)
+		}
+		keyType, err := commonKeyType(leftColumn.ColumnType, rightColumn.ColumnType)
+		if err != nil {
+			return join, fmt.Errorf("key columns '%s' and '%s': %w", leftName, rightName, err)
+		}
+
+		join.leftKeys = append(join.leftKeys, leftColumn.Index)
+		join.rightKeys = append(join.rightKeys, rightColumn.Index)
+		join.keyTypes = append(join.keyTypes, keyType)
+		rightKeyColumns[rightColumn.Index] = true
+	}
+
+	// Semi and anti joins only filter the left file
+	if join.joinType == JoinSemi || join.joinType == JoinAnti {
+		join.scheme = left
+		return join, nil
+	}
+
+	for i := range right.Headers {
+		if !rightKeyColumns[i] {
+			join.rightColumns = append(join.rightColumns, i)
+		}
+	}
+
+	// Names that occur on both sides of the result get prefixes
+	conflicts := make(map[string]bool)
+	for _, index := range join.rightColumns {
+		if _, ok := left.Columns[right.Headers[index]]; ok {
+			conflicts[right.Headers[index]] = true
+		}
+	}
+
+	join.scheme = Scheme{Columns: make(map[string]ColumnInfo)}
+	addColumn := func(name string, columnType ColumnTypeInterface) error {
+		if _, ok := join.scheme.Columns[name]; ok {
+			return fmt.Errorf("duplicate result column '%s'", name)
+		}
+		join.scheme.Columns[name] = ColumnInfo{Index: len(join.scheme.Headers), ColumnType: columnType}
+		join.scheme.Headers = append(join.scheme.Headers, name)
+		return nil
+	}
+	for _, header := range left.Headers {
+		name := header
+		if conflicts[header] {
+			name = leftPrefix + header
+		}
+		if err := addColumn(name, left.Columns[header].ColumnType); err != nil {
+			return join, err
+		}
+	}
+	for _, index := range join.rightColumns {
+		header := right.Headers[index]
+		name := header
+		if conflicts[header] {
+			name = rightPrefix + header
+		}
+		if err := addColumn(name, right.Columns[header].ColumnType); err != nil {
+			return join, err
+		}
+	}
+
+	return join, nil
+}
+
+// Scheme describes the records produced by the join
+func (j Join) Scheme() Scheme {
+	return j.scheme
+}
+
+func commonKeyType(a, b ColumnTypeInterface) (ColumnTypeInterface, error) {
+	switch {
+	case a == b:
+		return a, nil
+	case a != TypeString && b != TypeString:
+		return unifyTypes(a, b), nil
+	}
+	return nil, fmt.Errorf("incompatible types '%s' and '%s'", a.Name(), b.Name())
+}
+
+/*
+key normalizes the key values of a row through the key types, so that "01" matches "1" in numeric keys.
+Rows with an empty or unparsable key value have no key and never match.
+*/
+func (j Join) key(record []string, indices []int) (string, bool) {
+	values := make([]string, len(indices))
+	for i, index := range indices {
+		if record[index] == "" {
+			return "", false
+		}
+		value, err := j.keyTypes[i].Parse(record[index])
+		if err != nil {
+			return "", false
+		}
+		values[i] = fmt.Sprint(value)
+	}
+	return recordKey(values), true
+}
+
+func (j Join) compareKeys(left, right []string) (int, error) {
+	for i := range j.leftKeys {
+		order, err := j.keyTypes[i].Order(left[j.leftKeys[i]], right[j.rightKeys[i]])
+		if err != nil || order != 0 {
+			return order, err
+		}
+	}
+	return 0, nil
+}
+
+// combine builds a result record; either side may be missing
+func (j Join) combine(left, right []string) []string {
+	if j.joinType == JoinSemi || j.joinType == JoinAnti {
+		return left
+	}
+
+	record := make([]string, 0, len(j.scheme.Headers))
+	if left != nil {
+		record = append(record, left...)
+	} else {
+		record = append(record, make([]string, j.leftWidth)...)
+		// Unmatched right rows still show their key in the left key columns
+		for i, index := range j.leftKeys {
+			record[index] = right[j.rightKeys[i]]
+		}
+	}
+	for _, index := range j.rightColumns {
+		if right != nil {
+			record = append(record, right[index])
+		} else {
+			record = append(record, "")
+		}
+	}
+	return record
+}
+
+// emitsUnmatchedLeft reports whether left rows without a match are part of the result
+func (j Join) emitsUnmatchedLeft() bool {
+	return j.joinType == JoinLeft || j.joinType == JoinFull || j.joinType == JoinAnti
+}
+
+// emitsUnmatchedRight reports whether right rows without a match are part of the result
+func (j Join) emitsUnmatchedRight() bool {
+	return j.joinType == JoinRight || j.joinType == JoinFull
+}
+
+// emitsPairs reports whether matched rows are combined into result records
+func (j Join) emitsPairs() bool {
+	return j.joinType != JoinSemi && j.joinType != JoinAnti
+}
+
+/*
+HashJoin loads the smaller file into memory and streams the other one past it.
+Use HashJoinFits to check that the smaller file is small enough.
+*/
+func HashJoin(join Join, leftPath string, leftScheme Scheme, rightPath string, rightScheme Scheme, emit func([]string) error) error {
+	leftInfo, err := os.Stat(leftPath)
+	if err != nil {
+		return err
+	}
+	rightInfo, err := os.Stat(rightPath)
+	if err != nil {
+		return err
+	}
+
+	var joinErr error
+	if leftInfo.Size() <= rightInfo.Size() {
+		joinErr = hashJoinBuildLeft(join, leftPath, leftScheme, rightPath, rightScheme, emit)
+	} else {
+		joinErr = hashJoinBuildRight(join, leftPath, leftScheme, rightPath, rightScheme, emit)
+	}
+	if errors.Is(joinErr, ErrStop) {
+		return nil
+	}
+	return joinErr
+}
+
+// HashJoinFits reports whether the smaller of the two files is at most maxBytes large
+func HashJoinFits(leftPath, rightPath string, maxBytes int64) (bool, error) {
+	leftInfo, err := os.Stat(leftPath)
+	if err != nil {
+		return false, err
+	}
+	rightInfo, err := os.Stat(rightPath)
+	if err != nil {
+		return false, err
+	}
+	return min(leftInfo.Size(), rightInfo.Size()) <= maxBytes, nil
+}
+
+type hashJoinRow struct {
+	record  []string
+	matched bool
+}
+
+// loadHashTable reads a file into a map from the key to the rows, rows without key are returned separately
+func loadHashTable(join Join, path string, scheme Scheme, keys []int) (map[string][]*hashJoinRow, []*hashJoinRow, error) {
+	table := make(map[string][]*hashJoinRow)
+	var rows []*hashJoinRow
+	err := ReadCSV(path, scheme, nil, func(record []string) error {
+		row := &hashJoinRow{record: record}
+		rows = append(rows, row)
+		if key, ok := join.key(record, keys); ok {
+			table[key] = append(table[key], row)
+		}
+		return nil
+	})
+	return table, rows, err
+}
+
+func hashJoinBuildRight(join Join, leftPath string, leftScheme Scheme, rightPath string, rightScheme Scheme, emit func([]string) error) error {
+	table, rightRows, err := loadHashTable(join, rightPath, rightScheme, join.rightKeys)
+	if err != nil {
+		return err
+	}
+
+	err = ReadCSV(leftPath, leftScheme, nil, func(left []string) error {
+		var matches []*hashJoinRow
+		if key, ok := join.key(left, join.leftKeys); ok {
+			matches = table[key]
+		}
+		if len(matches) == 0 {
+			if join.emitsUnmatchedLeft() {
+				return emit(join.combine(left, nil))
+			}
+			return nil
+		}
+
+		for _, right := range matches {
+			right.matched = true
+		}
+		if !join.emitsPairs() {
+			if join.joinType == JoinSemi {
+				return emit(left)
+			}
+			return nil
+		}
+		for _, right := range matches {
+			if err := emit(join.combine(left, right.record)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if join.emitsUnmatchedRight() {
+		for _, right := range rightRows {
+			if !right.matched {
+				if err := emit(join.combine(nil, right.record)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func hashJoinBuildLeft(join Join, leftPath string, leftScheme Scheme, rightPath string, rightScheme Scheme, emit func([]string) error) error {
+	table, leftRows, err := loadHashTable(join, leftPath, leftScheme, join.leftKeys)
+	if err != nil {
+		return err
+	}
+
+	err = ReadCSV(rightPath, rightScheme, nil, func(right []string) error {
+		var matches []*hashJoinRow
+		if key, ok := join.key(right, join.rightKeys); ok {
+			matches = table[key]
+		}
+		if len(matches) == 0 {
+			if join.emitsUnmatchedRight() {
+				return emit(join.combine(nil, right))
+			}
+			return nil
+		}
+
+		for _, left := range matches {
+			left.matched = true
+			if join.emitsPairs() {
+				if err := emit(join.combine(left.record, right)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Rows of the left file that depend on whether they were matched
+	for _, left := range leftRows {
+		emitRow := (left.matched && join.joinType == JoinSemi) || (!left.matched && join.emitsUnmatchedLeft())
+		if emitRow {
+			if err := emit(join.combine(left.record, nil)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/*
+SortMergeJoin sorts both files by the key columns with the external Sorter and merges them.
+Only the rows of one right key have to fit in memory at once.
+Rows without key are passed on before the merged rows.
+*/
+func SortMergeJoin(join Join, leftPath string, leftScheme Scheme, rightPath string, rightScheme Scheme, bufferSize int, tempDir string, emit func([]string) error) error {
+	err := sortMergeJoin(join, leftPath, leftScheme, rightPath, rightScheme, bufferSize, tempDir, emit)
+	if errors.Is(err, ErrStop) {
+		return nil
+	}
+	return err
+}
+
+func sortMergeJoin(join Join, leftPath string, leftScheme Scheme, rightPath string, rightScheme Scheme, bufferSize int, tempDir string, emit func([]string) error) error {
+	var leftSortKeys, rightSortKeys []SortKey
+	for i := range join.keyTypes {
+		leftSortKeys = append(leftSortKeys, SortKey{column: leftScheme.Headers[join.leftKeys[i]], index: join.leftKeys[i], columnType: join.keyTypes[i]})
+		rightSortKeys = append(rightSortKeys, SortKey{column: rightScheme.Headers[join.rightKeys[i]], index: join.rightKeys[i], columnType: join.keyTypes[i]})
+	}
+
+	leftSorter := NewSorter(leftSortKeys, bufferSize, tempDir)
+	defer leftSorter.Close()
+	err := ReadCSV(leftPath, leftScheme, nil, func(left []string) error {
+		if _, ok := join.key(left, join.leftKeys); ok {
+			return leftSorter.Add(left)
+		}
+		if join.emitsUnmatchedLeft() {
+			return emit(join.combine(left, nil))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	rightSorter := NewSorter(rightSortKeys, bufferSize, tempDir)
+	defer rightSorter.Close()
+	err = ReadCSV(rightPath, rightScheme, nil, func(right []string) error {
+		if _, ok := join.key(right, join.rightKeys); ok {
+			return rightSorter.Add(right)
+		}
+		if join.emitsUnmatchedRight() {
+			return emit(join.combine(nil, right))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var leftErr, rightErr error
+	nextLeft, stopLeft := iter.Pull(sorted(leftSorter, &leftErr))
+	defer stopLeft()
+	nextRight, stopRight := iter.Pull(sorted(rightSorter, &rightErr))
+	defer stopRight()
+
+	// Right rows with equal keys are collected into a group
+	right, hasRight := nextRight()
+	nextGroup := func() ([][]string, error) {
+		if !hasRight {
+			return nil, nil
+		}
+		rows := [][]string{right}
+		for {
+			right, hasRight = nextRight()
+			if !hasRight {
+				return rows, nil
+			}
+			order, err := compareByKeys(rows[0], right, rightSortKeys)
+			if err != nil {
+				return nil, err
+			}
+			if order != 0 {
+				return rows, nil
+			}
+			rows = append(rows, right)
+		}
+	}
+
+	rightGroup, err := nextGroup()
+	if err != nil {
+		return err
+	}
+	groupMatched := false
+	flushGroup := func() error {
+		if !groupMatched && join.emitsUnmatchedRight() {
+			for _, row := range rightGroup {
+				if err := emit(join.combine(nil, row)); err != nil {
+					return err
+				}
+			}
+		}
+		rows, err := nextGroup()
+		rightGroup, groupMatched = rows, false
+		return err
+	}
+
+	left, hasLeft := nextLeft()
+	for hasLeft {
+		if rightGroup == nil {
+			if join.emitsUnmatchedLeft() {
+				if err := emit(join.combine(left, nil)); err != nil {
+					return err
+				}
+			}
+			left, hasLeft = nextLeft()
+			continue
+		}
+
+		order, err := join.compareKeys(left, rightGroup[0])
+		if err != nil {
+			return err
+		}
+		switch {
+		case order < 0:
+			if join.emitsUnmatchedLeft() {
+				if err := emit(join.combine(left, nil)); err != nil {
+					return err
+				}
+			}
+			left, hasLeft = nextLeft()
+		case order > 0:
+			if err := flushGroup(); err != nil {
+				return err
+			}
+		default:
+			groupMatched = true
+			switch {
+			case join.emitsPairs():
+				for _, row := range rightGroup {
+					if err := emit(join.combine(left, row)); err != nil {
+						return err
+					}
+				}
+			case join.joinType == JoinSemi:
+				if err := emit(left); err != nil {
+					return err
+				}
+			}
+			left, hasLeft = nextLeft()
+		}
+	}
+	for rightGroup != nil {
+		if err := flushGroup(); err != nil {
+			return err
+		}
+	}
+
+	return errors.Join(leftErr, rightErr)
+}
+
+// sorted turns the output of a Sorter into a sequence, the error of the sorter is stored in errp
+func sorted(sorter *Sorter, errp *error) iter.Seq[[]string] {
+	return func(yield func([]string) bool) {
+		*errp = sorter.Each(func(record []string) error {
+			if !yield(record) {
+				return ErrStop
+			}
+			return nil
+		})
+	}
+}
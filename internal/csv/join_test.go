@@ -0,0 +1,192 @@
+package csv
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Left ids are ints and right ids floats, "2" is on both sides twice and the empty ids never match
+var (
+	joinLeftLines  = []string{"id,name,city", "1,Ann,Oslo", "2,Bob,Rome", "2,Bea,Rome", "4,Dan,Kyiv", ",Eve,Lima"}
+	joinRightLines = []string{"uid,city,amount", "1.0,Paris,10.5", "2,Berlin,20", "2,Bonn,21", "3,Madrid,30", ",Nowhere,40"}
+)
+
+var (
+	joinedPairs = []string{
+		"1,Ann,Oslo,Paris,10.5",
+		"2,Bob,Rome,Berlin,20", "2,Bob,Rome,Bonn,21",
+		"2,Bea,Rome,Berlin,20", "2,Bea,Rome,Bonn,21",
+	}
+	unmatchedLeft  = []string{"4,Dan,Kyiv,,", ",Eve,Lima,,"}
+	unmatchedRight = []string{"3,,,Madrid,30", ",,,Nowhere,40"}
+)
+
+type joinFixture struct {
+	leftPath, rightPath     string
+	leftScheme, rightScheme Scheme
+}
+
+func newJoinFixture(t *testing.T) joinFixture {
+	t.Helper()
+	f := joinFixture{leftPath: writeCSV(t, "left.csv", joinLeftLines...), rightPath: writeCSV(t, "right.csv", joinRightLines...)}
+	var err error
+	if f.leftScheme, err = ParseCSVStructure(f.leftPath); err != nil {
+		t.Fatal(err)
+	}
+	if f.rightScheme, err = ParseCSVStructure(f.rightPath); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// run joins the files with every algorithm and returns the sorted rows of each
+func (f joinFixture) run(t *testing.T, join Join) map[string][]string {
+	t.Helper()
+	results := make(map[string][]string)
+	collect := func(name string) func([]string) error {
+		return func(record []string) error {
+			results[name] = append(results[name], strings.Join(record, ","))
+			return nil
+		}
+	}
+	if err := hashJoinBuildLeft(join, f.leftPath, f.leftScheme, f.rightPath, f.rightScheme, collect("hash build left")); err != nil {
+		t.Fatal(err)
+	}
+	if err := hashJoinBuildRight(join, f.leftPath, f.leftScheme, f.rightPath, f.rightScheme, collect("hash build right")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two rows per run make both files spill, the runs exist while the merged rows are emitted
+	tempDir := t.TempDir()
+	spilled := 0
+	emit := collect("sort merge")
+	err := SortMergeJoin(join, f.leftPath, f.leftScheme, f.rightPath, f.rightScheme, 2, tempDir, func(record []string) error {
+		entries, _ := os.ReadDir(tempDir)
+		spilled = max(spilled, len(entries))
+		return emit(record)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spilled < 2 {
+		t.Errorf("sort merge: %d runs on disk, want the files spilled", spilled)
+	}
+	if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+		t.Errorf("sort merge: %d runs left on disk", len(entries))
+	}
+
+	for _, rows := range results {
+		slices.Sort(rows)
+	}
+	return results
+}
+
+func TestJoinTypes(t *testing.T) {
+	f := newJoinFixture(t)
+	union := func(parts ...[]string) []string {
+		return slices.Concat(parts...)
+	}
+	tests := []struct {
+		joinType JoinType
+		want     []string
+	}{
+		{JoinInner, joinedPairs},
+		{JoinLeft, union(joinedPairs, unmatchedLeft)},
+		{JoinRight, union(joinedPairs, unmatchedRight)},
+		{JoinFull, union(joinedPairs, unmatchedLeft, unmatchedRight)},
+		{JoinSemi, []string{"1,Ann,Oslo", "2,Bob,Rome", "2,Bea,Rome"}},
+		{JoinAnti, []string{"4,Dan,Kyiv", ",Eve,Lima"}},
+	}
+	for _, test := range tests {
+		join, err := ParseJoin([]string{"id=uid"}, string(test.joinType), f.leftScheme, f.rightScheme, "left_", "right_")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := slices.Sorted(slices.Values(test.want))
+		results := f.run(t, join)
+		for _, algorithm := range []string{"hash build left", "hash build right", "sort merge"} {
+			if got := results[algorithm]; !slices.Equal(got, want) {
+				t.Errorf("%s join, %s: rows %q, want %q", test.joinType, algorithm, got, want)
+			}
+		}
+	}
+}
+
+func TestParseJoinScheme(t *testing.T) {
+	f := newJoinFixture(t)
+
+	// The conflicting city columns get the prefixes, the right key isn't repeated
+	join, err := ParseJoin([]string{" id = uid "}, "FULL", f.leftScheme, f.rightScheme, "l.", "r.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"id", "name", "l.city", "r.city", "amount"}; !slices.Equal(join.Scheme().Headers, want) {
+		t.Errorf("headers %v, want %v", join.Scheme().Headers, want)
+	}
+	if join.keyTypes[0] != TypeFloat {
+		t.Errorf("key type %s, want float", join.keyTypes[0].Name())
+	}
+
+	// Semi joins keep the left columns
+	join, err = ParseJoin([]string{"id=uid"}, "semi", f.leftScheme, f.rightScheme, "l.", "r.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"id", "name", "city"}; !slices.Equal(join.Scheme().Headers, want) {
+		t.Errorf("semi join headers %v, want %v", join.Scheme().Headers, want)
+	}
+
+	tests := []struct {
+		on       []string
+		joinType string
+		want     string
+	}{
+		{[]string{"id=uid"}, "cross", "unknown join type"},
+		{nil, "inner", "no key columns"},
+		{[]string{"id"}, "inner", "doesn't exist in the right file"},
+		{[]string{"uid"}, "inner", "doesn't exist in the left file"},
+		{[]string{"id=city"}, "inner", "incompatible types"},
+		// A key column on both sides is taken once, so it needs no prefix
+		{[]string{"id=uid", "city"}, "inner", ""},
+	}
+	for _, test := range tests {
+		_, err := ParseJoin(test.on, test.joinType, f.leftScheme, f.rightScheme, "left_", "right_")
+		if test.want == "" {
+			if err != nil {
+				t.Errorf("%v %s: %v", test.on, test.joinType, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%v %s: error %v, want %q", test.on, test.joinType, err, test.want)
+		}
+	}
+	// Without prefixes the city columns collide
+	if _, err := ParseJoin([]string{"id=uid"}, "inner", f.leftScheme, f.rightScheme, "", ""); err == nil {
+		t.Error("duplicate result columns accepted")
+	}
+}
+
+func TestJoinKey(t *testing.T) {
+	join := Join{keyTypes: []ColumnTypeInterface{TypeFloat, TypeString}}
+	tests := []struct {
+		record []string
+		key    string
+		ok     bool
+	}{
+		{[]string{"1", "a"}, "1:11:a", true},
+		{[]string{"1.0", "a"}, "1:11:a", true},
+		{[]string{"01", "b"}, "1:11:b", true},
+		{[]string{"1", ""}, "", false}, // empty strings never match either
+		{[]string{"", "a"}, "", false},
+		{[]string{"x", "a"}, "", false},
+	}
+	for _, test := range tests {
+		key, ok := join.key(test.record, []int{0, 1})
+		if key != test.key || ok != test.ok {
+			t.Errorf("key(%q) = %q, %t, want %q, %t", test.record, key, ok, test.key, test.ok)
+		}
+	}
+}
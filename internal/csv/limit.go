@@ -0,0 +1,163 @@
+package csv
+
+import (
+	"container/heap"
+	"errors"
+	"slices"
+)
+
+// ErrStop is returned by a record handler when no more records are needed
+var ErrStop = errors.New("no more records needed")
+
+// Limiter skips the first offset records and passes at most limit records further
+type Limiter struct {
+	offset int
+	limit  int // 0 means no limit
+	seen   int
+	next   func([]string) error
+}
+
+func NewLimiter(offset, limit int, next func([]string) error) *Limiter {
+	return &Limiter{offset: offset, limit: limit, next: next}
+}
+
+// Add returns ErrStop once the limit is reached
+func (l *Limiter) Add(record []string) error {
+	l.seen++
+	if l.seen <= l.offset {
+		return nil
+	}
+	if err := l.next(record); err != nil {
+		return err
+	}
+	if l.limit > 0 && l.seen >= l.offset+l.limit {
+		return ErrStop
+	}
+	return nil
+}
+
+/*
+TopN keeps the n best ranked records of every group.
+Each group holds a bounded heap, so memory depends on n and the number of groups, not on the input size.
+Without grouping columns all records form a single group.
+*/
+type TopN struct {
+	n      int
+	keys   []SortKey
+	per    []string
+	scheme Scheme
+	groups map[string]*topHeap
+	order  []string // group keys in order of first appearance
+	seq    int      // number of added records, used to keep ties in input order
+}
+
+func NewTopN(n int, keys []SortKey, per []string, scheme Scheme) *TopN {
+	return &TopN{
+		n:      n,
+		keys:   keys,
+		per:    per,
+		scheme: scheme,
+		groups: make(map[string]*topHeap),
+	}
+}
+
+func (t *TopN) Add(record []string) error {
+	if t.n <= 0 {
+		return nil
+	}
+
+	// The values are length prefixed, so values containing the separator don't merge groups
+	values := make([]string, len(t.per))
+	for i, column := range t.per {
+		values[i] = record[t.scheme.Columns[column].Index]
+	}
+	groupKey := recordKey(values)
+	h, ok := t.groups[groupKey]
+	if !ok {
+		h = &topHeap{keys: t.keys}
+		t.groups[groupKey] = h
+		t.order = append(t.order, groupKey)
+	}
+
+	item := topItem{record: record, seq: t.seq}
+	t.seq++
+
+	if h.Len() < t.n {
+		heap.Push(h, item)
+		return h.err
+	}
+	// The root of the heap is the worst of the kept records
+	if h.worse(h.items[0], item) {
+		h.items[0] = item
+		heap.Fix(h, 0)
+	}
+	return h.err
+}
+
+// Each passes the kept records group by group, best ranked first
+func (t *TopN) Each(emit func([]string) error) error {
+	for _, groupKey := range t.order {
+		h := t.groups[groupKey]
+		items := slices.Clone(h.items)
+		slices.SortFunc(items, func(a, b topItem) int {
+			switch {
+			case h.worse(b, a):
+				return -1
+			case h.worse(a, b):
+				return 1
+			}
+			return 0
+		})
+		if h.err != nil {
+			return h.err
+		}
+
+		for _, item := range items {
+			if err := emit(item.record); err != nil {
+				if errors.Is(err, ErrStop) {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type topItem struct {
+	record []string
+	seq    int
+}
+
+// topHeap is a heap with the worst ranked record at the root
+type topHeap struct {
+	items []topItem
+	keys  []SortKey
+	err   error // first comparison error
+}
+
+// worse reports whether a is ranked after b
+func (h *topHeap) worse(a, b topItem) bool {
+	order, err := compareByKeys(a.record, b.record, h.keys)
+	if err != nil && h.err == nil {
+		h.err = err
+	}
+	if order == 0 {
+		return a.seq > b.seq
+	}
+	return order > 0
+}
+
+func (h *topHeap) Len() int { return len(h.items) }
+
+func (h *topHeap) Less(i, j int) bool { return h.worse(h.items[i], h.items[j]) }
+
+func (h *topHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *topHeap) Push(x any) { h.items = append(h.items, x.(topItem)) }
+
+func (h *topHeap) Pop() any {
+	item := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return item
+}
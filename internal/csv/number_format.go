@@ -0,0 +1,237 @@
+package csv
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+NumberFormat describes numbers written by the conventions of a locale, like "1 234,56" or "1.234,56 €".
+Numbers may have a unit before or after them: a currency symbol like "€", a currency code like "EUR",
+or a percent sign. Percentages are fractions, so "12,5 %" is read as 0.125.
+*/
+type NumberFormat struct {
+	Locale   string
+	Decimal  rune   // decimal separator
+	Grouping string // characters separating groups of thousands, the first one is used for writing
+	// UnitFirst writes currencies before the number and units without a space, like "$1,234.56" and "12.5%"
+	UnitFirst bool
+}
+
+// numberFormats are the known locales by name
+var numberFormats = map[string]NumberFormat{
+	"en":    {Decimal: '.', Grouping: ",", UnitFirst: true},
+	"de":    {Decimal: ',', Grouping: ". \u00a0\u202f"},
+	"de-CH": {Decimal: '.', Grouping: "'’"},
+	"es":    {Decimal: ',', Grouping: ". \u00a0\u202f"},
+	"fr":    {Decimal: ',', Grouping: " \u00a0\u202f"},
+	"it":    {Decimal: ',', Grouping: ". \u00a0\u202f"},
+	"nl":    {Decimal: ',', Grouping: ". \u00a0\u202f"},
+	"pl":    {Decimal: ',', Grouping: " \u00a0\u202f"},
+	"pt":    {Decimal: ',', Grouping: ". \u00a0\u202f"},
+	"ru":    {Decimal: ',', Grouping: " \u00a0\u202f"},
+	"uk":    {Decimal: ',', Grouping: " \u00a0\u202f"},
+}
+
+// LookupNumberFormat returns the number format of a locale like "de" or "ru"
+func LookupNumberFormat(locale string) (*NumberFormat, error) {
+	format, ok := numberFormats[locale]
+	if !ok {
+		return nil, fmt.Errorf("unknown locale '%s', expected one of %s", locale, strings.Join(NumberLocales(), ", "))
+	}
+	format.Locale = locale
+	return &format, nil
+}
+
+// NumberLocales returns the names of the known locales
+func NumberLocales() []string {
+	locales := make([]string, 0, len(numberFormats))
+	for locale := range numberFormats {
+		locales = append(locales, locale)
+	}
+	slices.Sort(locales)
+	return locales
+}
+
+var errNumberSyntax = errors.New("invalid number syntax")
+
+/*
+Parse reads a number written in the format and returns it as a plain number like "-1234.56",
+along with its unit, which is empty for numbers without one.
+Plain numbers are accepted as well when the format uses a point for decimals.
+*/
+func (f NumberFormat) Parse(s string) (string, string, error) {
+	s = strings.TrimSpace(s)
+	if f.Decimal == '.' && decimalPattern.MatchString(s) {
+		return s, "", nil
+	}
+
+	// The sign may go before or after a currency: "-1 234 €", "-$1,234" or "$-1,234"
+	negative, s := cutSign(s)
+	unit, s := cutUnit(s)
+	if !negative {
+		negative, s = cutSign(s)
+	}
+
+	integer, fraction, hasFraction := strings.Cut(s, string(f.Decimal))
+	if integer == "" && fraction == "" || hasFraction && !isDigits(fraction) {
+		return "", "", errNumberSyntax
+	}
+	integer, err := f.ungroup(integer)
+	if err != nil {
+		return "", "", err
+	}
+
+	number := integer
+	if integer == "" {
+		number = "0"
+	}
+	if hasFraction && fraction != "" {
+		number += "." + fraction
+	}
+	if negative {
+		number = "-" + number
+	}
+	if unit == "%" {
+		d, err := ParseDecimal(number + "e-2")
+		if err != nil {
+			return "", "", err
+		}
+		// Trailing zeros are kept like for other numbers, "12,50 %" is "0.1250"
+		number = d.text()
+	}
+	return number, unit, nil
+}
+
+// ungroup removes the separators from the integer part, every group after the first must have three digits
+func (f NumberFormat) ungroup(integer string) (string, error) {
+	i := strings.IndexFunc(integer, func(r rune) bool {
+		return strings.ContainsRune(f.Grouping, r)
+	})
+	if i < 0 {
+		if integer != "" && !isDigits(integer) {
+			return "", errNumberSyntax
+		}
+		return integer, nil
+	}
+	separator, _ := utf8.DecodeRuneInString(integer[i:])
+	groups := strings.Split(integer, string(separator))
+	for j, group := range groups {
+		if !isDigits(group) || j == 0 && len(group) > 3 || j != 0 && len(group) != 3 {
+			return "", errNumberSyntax
+		}
+	}
+	return strings.Join(groups, ""), nil
+}
+
+/*
+Format writes a plain number in the format with the unit, the inverse of Parse.
+Values that aren't numbers, like nulls, are returned as they are.
+*/
+func (f NumberFormat) Format(number, unit string) string {
+	d, err := ParseDecimal(number)
+	if err != nil {
+		return number
+	}
+	if unit == "%" {
+		d = Decimal{value: new(big.Rat).Mul(d.value, big.NewRat(100, 1)), scale: max(d.scale-2, 0)}
+	}
+
+	// Trailing zeros are kept, like in "99,90 €"
+	text := d.text()
+	sign := ""
+	if strings.HasPrefix(text, "-") {
+		sign, text = "-", text[1:]
+	}
+	integer, fraction, hasFraction := strings.Cut(text, ".")
+	separator, _ := utf8.DecodeRuneInString(f.Grouping)
+	var b strings.Builder
+	for i, digit := range integer {
+		if i != 0 && (len(integer)-i)%3 == 0 {
+			b.WriteRune(separator)
+		}
+		b.WriteRune(digit)
+	}
+	if hasFraction {
+		b.WriteRune(f.Decimal)
+		b.WriteString(fraction)
+	}
+	text = sign + b.String()
+
+	switch {
+	case unit == "":
+		return text
+	case unit == "%" && f.UnitFirst:
+		return text + unit
+	case unit == "%":
+		return text + " " + unit
+	case f.UnitFirst && !isCurrencyCode(unit):
+		return sign + unit + b.String()
+	case f.UnitFirst:
+		return unit + " " + text
+	}
+	return text + " " + unit
+}
+
+// cutSign removes a leading sign, the minus sign "−" included
+func cutSign(s string) (bool, string) {
+	for _, sign := range []string{"-", "−"} {
+		if rest, ok := strings.CutPrefix(s, sign); ok {
+			return true, strings.TrimSpace(rest)
+		}
+	}
+	if rest, ok := strings.CutPrefix(s, "+"); ok {
+		return false, strings.TrimSpace(rest)
+	}
+	return false, s
+}
+
+// cutUnit removes a percent sign, a currency symbol or a three letter currency code from either end
+func cutUnit(s string) (string, string) {
+	if rest, ok := strings.CutSuffix(s, "%"); ok {
+		return "%", strings.TrimSpace(rest)
+	}
+	if r, size := utf8.DecodeRuneInString(s); unicode.Is(unicode.Sc, r) {
+		return s[:size], strings.TrimSpace(s[size:])
+	}
+	if r, size := utf8.DecodeLastRuneInString(s); unicode.Is(unicode.Sc, r) {
+		return s[len(s)-size:], strings.TrimSpace(s[:len(s)-size])
+	}
+	if code, rest, ok := strings.Cut(s, " "); ok && isCurrencyCode(code) {
+		return code, strings.TrimSpace(rest)
+	}
+	if i := strings.LastIndexByte(s, ' '); i >= 0 && isCurrencyCode(s[i+1:]) {
+		return s[i+1:], strings.TrimSpace(s[:i])
+	}
+	return "", s
+}
+
+// isCurrencyCode reports whether s looks like an ISO 4217 code like "EUR"
+func isCurrencyCode(s string) bool {
+	if len(s) != 3 {
+		return false
+	}
+	for _, r := range s {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
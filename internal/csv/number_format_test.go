@@ -0,0 +1,119 @@
+package csv
+
+import "testing"
+
+func numberFormat(t *testing.T, locale string) NumberFormat {
+	t.Helper()
+	format, err := LookupNumberFormat(locale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *format
+}
+
+func TestNumberFormatParse(t *testing.T) {
+	tests := []struct {
+		locale, text string
+		number, unit string
+	}{
+		{"ru", "1 234,56", "1234.56", ""},
+		{"ru", "1 234,56", "1234.56", ""},
+		{"ru", "-1 234 ₽", "-1234", "₽"},
+		{"de", "1.234,56 €", "1234.56", "€"},
+		{"de", "EUR 1.234,56", "1234.56", "EUR"},
+		{"de", ",5", "0.5", ""},
+		{"de-CH", "1'234.50", "1234.50", ""},
+		{"de-CH", "1’234.50 CHF", "1234.50", "CHF"},
+		// Percentages are fractions
+		{"fr", "1 234,5 %", "12.345", "%"},
+		{"fr", "12,5 %", "0.125", "%"},
+		{"fr", "12,50 %", "0.1250", "%"},
+		// The sign goes before or after the currency
+		{"en", "$-1,234", "-1234", "$"},
+		{"en", "-$1,234.5", "-1234.5", "$"},
+		{"en", "−1,234", "-1234", ""},
+		{"en", "+1,234", "1234", ""},
+		// Plain numbers are taken as they are when the decimal separator is a point
+		{"en", "1234.56", "1234.56", ""},
+		{"en", "-1.5e3", "-1.5e3", ""},
+		{"de-CH", "1234.5", "1234.5", ""},
+	}
+	for _, test := range tests {
+		number, unit, err := numberFormat(t, test.locale).Parse(test.text)
+		if err != nil || number != test.number || unit != test.unit {
+			t.Errorf("%s: Parse(%q) = %q, %q, %v, want %q, %q", test.locale, test.text, number, unit, err, test.number, test.unit)
+		}
+	}
+}
+
+func TestNumberFormatParseErrors(t *testing.T) {
+	tests := []struct {
+		locale, text string
+	}{
+		// Every group after the first has three digits
+		{"en", "1,23,4"},
+		{"en", "12,34"},
+		{"en", "1234,567"},
+		{"ru", "1 23"},
+		// The plain number fallback is only for a point as the decimal separator
+		{"de", "1234.56"},
+		{"ru", "1.5"},
+		{"de", "1,5e3"},
+		{"de", "1.234,5x"},
+		{"de", ""},
+		{"de", "€"},
+		{"en", "abc"},
+	}
+	for _, test := range tests {
+		if number, unit, err := numberFormat(t, test.locale).Parse(test.text); err == nil {
+			t.Errorf("%s: Parse(%q) = %q, %q, want an error", test.locale, test.text, number, unit)
+		}
+	}
+}
+
+func TestNumberFormatRoundTrip(t *testing.T) {
+	tests := []struct {
+		locale, text string
+	}{
+		{"ru", "1 234,56"},
+		{"ru", "-1 234 567"},
+		{"de", "1.234,50 €"},
+		{"de", "99,90 EUR"},
+		{"de-CH", "1'234.50"},
+		{"fr", "12,5 %"},
+		{"fr", "1 234,50 %"},
+		// Units go first without a space, currency codes with one
+		{"en", "$1,234.50"},
+		{"en", "-$1,234.5"},
+		{"en", "USD 1,234.00"},
+		{"en", "12.50%"},
+		{"en", "123"},
+	}
+	for _, test := range tests {
+		format := numberFormat(t, test.locale)
+		number, unit, err := format.Parse(test.text)
+		if err != nil {
+			t.Errorf("%s: Parse(%q): %v", test.locale, test.text, err)
+			continue
+		}
+		if got := format.Format(number, unit); got != test.text {
+			t.Errorf("%s: Format(%q, %q) = %q, want %q", test.locale, number, unit, got, test.text)
+		}
+	}
+
+	// Nulls and other text aren't numbers
+	for _, value := range []string{"", "n/a"} {
+		if got := numberFormat(t, "de").Format(value, "€"); got != value {
+			t.Errorf("Format(%q) = %q", value, got)
+		}
+	}
+}
+
+func TestLookupNumberFormat(t *testing.T) {
+	if _, err := LookupNumberFormat("xx"); err == nil {
+		t.Error("unknown locale accepted")
+	}
+	if format := numberFormat(t, "de-CH"); format.Locale != "de-CH" || format.Decimal != '.' {
+		t.Errorf("de-CH = %+v", format)
+	}
+}
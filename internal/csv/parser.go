@@ -8,17 +8,212 @@ import (
 	"math"
 	"os"
 	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 )
 
-func ParseCSVStructure(filepath string) (Scheme, error) {
-	// Declare scheme
-	scheme := Scheme{}
+/*
+ParseCSVStructure infers the scheme of one or more CSV files read as a single table,
+reading every row of the files and tolerating no values that don't match a numeric type.
+*/
+func ParseCSVStructure(filepaths ...string) (Scheme, error) {
+	return ParseCSVStructureWith(filepaths, InferOptions{})
+}
+
+// InferOptions are optional settings of ParseCSVStructureWith
+type InferOptions struct {
+	Rows int // data rows sampled from the start of each file, 0 for all rows
+	/*
+		Tolerance is the share of the values of a column that may be outliers, values that aren't numbers,
+		with the column still inferred as numeric. The outliers are bad values when the files are read.
+	*/
+	Tolerance float64
+	// Formats of the numbers of the columns, and of all other columns when Locale is set
+	Formats map[string]*NumberFormat
+	Locale  *NumberFormat
+}
+
+// format is the number format of a column, nil for plain numbers
+func (o InferOptions) format(header string) *NumberFormat {
+	if format, ok := o.Formats[header]; ok {
+		return format
+	}
+	return o.Locale
+}
+
+/*
+ParseCSVStructureWith infers the scheme of one or more CSV files read as a single table.
+Columns are matched by header name in order of their first appearance, and the values of a column
+in all files decide its type. Empty values are nulls and don't affect the type. Numeric columns are
+promoted as their values require: int → float → decimal. Ints become decimals rather than floats
+when they don't fit into an int or have more digits than a float can hold, and so do floats that a float
+can't hold without losing digits. A column with more outliers than tolerated, or without values, is string.
+Values of columns with a number format are read in the format, and the format is kept by numeric columns.
+*/
+func ParseCSVStructureWith(filepaths []string, options InferOptions) (Scheme, error) {
+	if options.Rows < 0 {
+		return Scheme{}, errors.New("number of sampled rows must not be negative")
+	}
+	if options.Tolerance < 0 || options.Tolerance >= 1 {
+		return Scheme{}, errors.New("tolerated share of outliers must be at least 0 and less than 1")
+	}
+
+	scheme := Scheme{Columns: make(map[string]ColumnInfo)}
+	profiles := make(map[string]*columnProfile)
+	for _, filepath := range filepaths {
+		headers, err := profileFile(filepath, options, profiles)
+		if err != nil {
+			return Scheme{}, fmt.Errorf("%s: %w", filepath, err)
+		}
+		for _, header := range headers {
+			if _, ok := scheme.Columns[header]; !ok {
+				scheme.Columns[header] = ColumnInfo{Index: len(scheme.Headers)}
+				scheme.Headers = append(scheme.Headers, header)
+			}
+		}
+	}
+	for header := range options.Formats {
+		if _, ok := scheme.Columns[header]; !ok {
+			return Scheme{}, fmt.Errorf("number format for non-existent column '%s'", header)
+		}
+	}
+	for header, column := range scheme.Columns {
+		profile := profiles[header]
+		column.ColumnType = profile.columnType(options.Tolerance)
+		if column.ColumnType != TypeString {
+			column.Format = options.format(header)
+		}
+		if column.Format != nil && !profile.mixedUnits {
+			column.Unit = profile.unit
+		}
+		scheme.Columns[header] = column
+	}
+	return scheme, nil
+}
+
+// columnProfile counts the kinds of values found in a column
+type columnProfile struct {
+	values   int  // non-empty values
+	outliers int  // values that aren't numbers
+	wideInt  bool // an int with more digits than a float can hold
+	float    bool
+	decimal  bool // a number that neither an int nor a float can hold
+	// Currency or percent sign of the numbers, when all numbers have the same one
+	unit       string
+	mixedUnits bool
+}
+
+func (p *columnProfile) add(value string, format *NumberFormat) {
+	// Nulls don't tell the type
+	if value == "" {
+		return
+	}
+	p.values++
+	if format != nil {
+		number, unit, err := format.Parse(value)
+		if err != nil {
+			p.outliers++
+			return
+		}
+		if p.values-p.outliers == 1 {
+			p.unit = unit
+		}
+		p.mixedUnits = p.mixedUnits || unit != p.unit
+		value = number
+	}
+	// Numbers are checked by the syntax of decimals too, so that every number can be promoted to a decimal
+	if !decimalPattern.MatchString(value) {
+		p.outliers++
+		return
+	}
+	if _, err := TypeInt.ParseTyped(value); err == nil {
+		p.wideInt = p.wideInt || !fitsFloat(value)
+		return
+	}
+	if _, err := TypeFloat.ParseTyped(value); err == nil && fitsFloat(value) {
+		p.float = true
+		return
+	}
+	p.decimal = true
+}
+
+// columnType is the type of the column, outliers above the tolerated share make it a string
+func (p *columnProfile) columnType(tolerance float64) ColumnTypeInterface {
+	switch {
+	case p == nil || p.values == 0 || p.outliers == p.values:
+		return TypeString
+	case float64(p.outliers) > tolerance*float64(p.values):
+		return TypeString
+	case p.decimal || p.float && p.wideInt:
+		return TypeDecimal
+	case p.float:
+		return TypeFloat
+	}
+	return TypeInt
+}
+
+// fitsFloat reports whether a float holds all significant digits of the number
+func fitsFloat(number string) bool {
+	f, err := strconv.ParseFloat(number, 64)
+	if err != nil || math.IsInf(f, 0) {
+		return false
+	}
+	return significantDigits(number) == significantDigits(strconv.FormatFloat(f, 'e', -1, 64))
+}
+
+// significantDigits returns the digits of the mantissa without leading and trailing zeros
+func significantDigits(number string) string {
+	mantissa, _, _ := strings.Cut(strings.ToLower(number), "e")
+	digits := strings.Map(func(r rune) rune {
+		if r < '0' || r > '9' {
+			return -1
+		}
+		return r
+	}, mantissa)
+	return strings.Trim(digits, "0")
+}
+
+/*
+WithSourceColumn adds the SourceColumn to the scheme.
+The column is filled with the address of the file each row is read from.
+*/
+func WithSourceColumn(scheme Scheme) (Scheme, error) {
+	if _, ok := scheme.Columns[SourceColumn]; ok {
+		return scheme, fmt.Errorf("column '%s' already exists", SourceColumn)
+	}
+
+	result := Scheme{
+		Headers:      append(append([]string{}, scheme.Headers...), SourceColumn),
+		Columns:      make(map[string]ColumnInfo),
+		SourceColumn: true,
+	}
+	for header, column := range scheme.Columns {
+		result.Columns[header] = column
+	}
+	result.Columns[SourceColumn] = ColumnInfo{Index: len(scheme.Headers), ColumnType: TypeString}
+	return result, nil
+}
+
+// unifyTypes is the common type of two column types, numeric types are promoted and any other mix is string
+func unifyTypes(a, b ColumnTypeInterface) ColumnTypeInterface {
+	switch {
+	case a == b:
+		return a
+	case a == TypeString || b == TypeString:
+		return TypeString
+	case a == TypeDecimal || b == TypeDecimal:
+		return TypeDecimal
+	}
+	return TypeFloat
+}
 
+// profileFile adds the values of the sampled rows of a file to the profiles of its columns and returns its headers
+func profileFile(filepath string, options InferOptions, profiles map[string]*columnProfile) ([]string, error) {
 	// Open the CSV file and check that the file exists
 	f, err := os.Open(filepath)
 	if err != nil {
-		return scheme, err
+		return nil, err
 	}
 	defer f.Close()
 
@@ -28,263 +223,392 @@ func ParseCSVStructure(filepath string) (Scheme, error) {
 	// Read the headers separately
 	headers, err := csvReader.Read()
 	if err != nil {
-		return scheme, err
+		return nil, err
+	}
+	columns := make([]*columnProfile, len(headers))
+	formats := make([]*NumberFormat, len(headers))
+	for i, header := range headers {
+		if profiles[header] == nil {
+			profiles[header] = &columnProfile{}
+		}
+		columns[i] = profiles[header]
+		formats[i] = options.format(header)
 	}
 
-	/*
-		Create a map for storing columns info
-		{
-			"columnName": {
-				Index: int,
-				ColumnType: ColumnType
-			}
+	for rows := 0; options.Rows == 0 || rows < options.Rows; rows++ {
+		record, err := readWellFormed(csvReader)
+		if errors.Is(err, io.EOF) {
+			break
 		}
-	*/
-	columns := make(map[string]ColumnInfo)
+		if err != nil {
+			return nil, err
+		}
+		for i, value := range record {
+			columns[i].add(value, formats[i])
+		}
+	}
+	return headers, nil
+}
 
-	// All are set to type string in case there are no data rows in the file
-	for i, header := range headers {
-		columns[header] = ColumnInfo{
-			Index:      i,
-			ColumnType: TypeString,
+/*
+readWellFormed reads the next row that can be split into the right number of fields.
+Malformed rows don't tell the column types, they are left to the reading of the data.
+*/
+func readWellFormed(reader *csv.Reader) ([]string, error) {
+	for {
+		record, err := reader.Read()
+		var parseErr *csv.ParseError
+		if !errors.As(err, &parseErr) {
+			return record, err
 		}
 	}
-	scheme.Headers = headers
+}
 
-	// Lists of indexes of columns with numeric values
-	var intColumnIndices, floatColumnIndices []int
+func ParseCSV(filepath string, scheme Scheme, filters []Filter, aggregations []Aggregator, groups []string) ([][]string, error) {
+	// Final data, starting with the headers of the result
+	records := [][]string{ResultScheme(scheme, aggregations, groups).Headers}
 
-	/*
-		Read the first line of data separately to
-		check the types of each value and find the numeric types
-	*/
-	firstRow, err := csvReader.Read()
+	err := ProcessCSV(filepath, scheme, filters, aggregations, groups, func(record []string) error {
+		records = append(records, record)
+		return nil
+	})
 	if err != nil {
-		// The error may mean that there is no more data to read
-		if errors.Is(err, io.EOF) {
-			scheme.Columns = columns
-			return scheme, nil
-		}
-		return scheme, err
+		return nil, err
 	}
+	return records, nil
+}
 
-	for i, column := range firstRow {
-		columnType := getTypeByValue(column)
+/*
+ProcessCSV reads the CSV file and passes every resulting record to emit.
+The headers are not emitted, they are described by ResultScheme.
+Grouped records are emitted in the order in which their groups first appear in the file.
+If emit returns ErrStop, reading stops without an error.
+*/
+func ProcessCSV(filepath string, scheme Scheme, filters []Filter, aggregations []Aggregator, groups []string, emit func([]string) error) error {
+	if len(aggregations) == 0 && len(groups) == 0 {
+		return ReadCSV(filepath, scheme, filters, emit)
+	}
 
-		switch columnType.Name() {
-		case TypeInt.TypeName:
-			intColumnIndices = append(intColumnIndices, i)
-		case TypeFloat.TypeName:
-			floatColumnIndices = append(floatColumnIndices, i)
-		}
+	grouper := NewGrouper(scheme, aggregations, groups)
+	if err := ReadCSV(filepath, scheme, filters, grouper.Add); err != nil {
+		return err
 	}
+	return grouper.Each(emit)
+}
 
+/*
+ReadCSV reads the CSV file and passes the rows matching all filters to emit.
+If emit returns ErrStop, reading stops without an error.
+*/
+func ReadCSV(filepath string, scheme Scheme, filters []Filter, emit func([]string) error) error {
+	_, err := ReadCSVFiles([]string{filepath}, scheme, filters, emit)
+	return err
+}
+
+// ReadStats counts the rows read by ReadCSVFiles
+type ReadStats struct {
+	Read     int   // rows read from the files
+	Filtered int   // rows dropped by the filters
+	Bytes    int64 // bytes read from the files
+	Rejected int   // rows passed to ReadOptions.OnError
+	Nulled   int   // values read as nulls by ReadOptions.NullMismatches
+	// Rows passing every filter, filters are checked in order and only for rows that passed the previous ones
+	Passed []int
+}
+
+// ReadOptions are optional settings of ReadCSVFilesWith
+type ReadOptions struct {
+	// Progress is called after every row with the bytes and rows read from all files so far
+	Progress func(bytes int64, rows int)
 	/*
-		If numeric columns are found, the file is read to the end to
-		ensure that the column contains only numeric values
+		OnError is called for every row that can't be read or has a value not matching the type of its column.
+		The row is left out unless OnError returns an error, which stops reading.
+		Without OnError the first such row stops reading with its *RowError.
 	*/
-	for len(intColumnIndices) != 0 || len(floatColumnIndices) != 0 {
-		record, err := csvReader.Read()
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
-			return scheme, err
-		}
+	OnError func(*RowError) error
+	// Maximum number of rows passed to OnError, reading stops with an error after one more (0 for no limit)
+	MaxErrors int
+	// NullMismatches reads values not matching the type of their column as nulls instead of passing their rows to OnError
+	NullMismatches bool
+	/*
+		Checked are the columns whose values are checked against their types, nil for all typed columns.
+		The columns of the filters are always checked. Values of other columns are passed on unchecked.
+	*/
+	Checked []string
+}
 
-		var newIntIndices []int
-		for _, columnIndex := range intColumnIndices {
-			if getTypeByValue(record[columnIndex]).Name() == TypeInt.TypeName {
-				newIntIndices = append(newIntIndices, columnIndex)
-			}
-		}
-		intColumnIndices = newIntIndices
+// RowError is a row that can't be read or has a value not matching the type of its column
+type RowError struct {
+	File   string
+	Line   int    // line the row starts on
+	Column string // column of the wrong value, empty when the row can't be read
+	// Fields of the row in the order of the scheme, or as far as they could be read when the row is malformed
+	Record []string
+	Err    error
+}
 
-		var newFloatIndices []int
-		for _, columnIndex := range floatColumnIndices {
-			if getTypeByValue(record[columnIndex]).Name() == TypeFloat.TypeName {
-				newFloatIndices = append(newFloatIndices, columnIndex)
-			}
-		}
-		floatColumnIndices = newFloatIndices
+func (e *RowError) Error() string {
+	if e.Column != "" {
+		return fmt.Sprintf("%s, line %d, column '%s': %s", e.File, e.Line, e.Column, e.Err)
 	}
+	return fmt.Sprintf("%s, line %d: %s", e.File, e.Line, e.Err)
+}
 
-	for _, columnIndex := range intColumnIndices {
-		header := headers[columnIndex]
-		colInfo := columns[header]
-		colInfo.ColumnType = TypeInt
-		columns[header] = colInfo
-	}
-	for _, columnIndex := range floatColumnIndices {
-		header := headers[columnIndex]
-		colInfo := columns[header]
-		colInfo.ColumnType = TypeFloat
-		columns[header] = colInfo
-	}
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
 
-	scheme.Columns = columns
-	return scheme, nil
+/*
+ReadCSVFiles reads the CSV files one after another as a single table described by the scheme.
+The columns of every file are aligned with the scheme by header name,
+columns missing from a file are left empty.
+*/
+func ReadCSVFiles(filepaths []string, scheme Scheme, filters []Filter, emit func([]string) error) (ReadStats, error) {
+	return ReadCSVFilesWith(filepaths, scheme, filters, emit, ReadOptions{})
 }
 
-func ParseCSV(filepath string, scheme Scheme, filters []Filter, aggregations []Aggregator, groups []string) ([][]string, error) {
+// ReadCSVFilesWith reads the CSV files like ReadCSVFiles with the given options
+func ReadCSVFilesWith(filepaths []string, scheme Scheme, filters []Filter, emit func([]string) error, options ReadOptions) (ReadStats, error) {
+	stats := ReadStats{Passed: make([]int, len(filters))}
+	for _, filepath := range filepaths {
+		err := readCSV(filepath, scheme, filters, emit, options, &stats)
+		if errors.Is(err, ErrStop) {
+			return stats, nil
+		}
+		// Row errors name the file themselves
+		var rowErr *RowError
+		if errors.As(err, &rowErr) {
+			return stats, err
+		}
+		if err != nil {
+			return stats, fmt.Errorf("%s: %w", filepath, err)
+		}
+	}
+	return stats, nil
+}
+
+func readCSV(filepath string, scheme Scheme, filters []Filter, emit func([]string) error, options ReadOptions, stats *ReadStats) error {
 	// Open the CSV file and check that the file exists
 	f, err := os.Open(filepath)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer f.Close()
 
-	// Final data
-	var records [][]string
 	// Creating a reader
 	csvReader := csv.NewReader(f)
-	// Array of column names
-	var headers []string
-
-	// Aggregation indication flag
-	hasAggregations := len(aggregations) != 0
-	// Grouping indication flag
-	hasGroups := len(groups) != 0
-	// Map for the unique aggregation columns
-	aggragatedColumns := make(map[string]bool)
-	/*
-		Map for grouping aggregated values
-		{
-			"groupKey": {
-				"columnName": ["1", "2", "3"...]
-			}
+
+	// Read the headers to align the columns of the file with the scheme
+	headers, err := csvReader.Read()
+	if err != nil {
+		return err
+	}
+	positions := make([]int, len(headers))
+	aligned := len(headers) == len(scheme.Headers) && !scheme.SourceColumn
+	for i, header := range headers {
+		column, ok := scheme.Columns[header]
+		if !ok {
+			return fmt.Errorf("column '%s' is missing from the scheme", header)
 		}
-	*/
-	groupingMeasuresMap := make(map[string]map[string][]string)
-	/*
-		Map for storing group fields
-		{
-			"groupKey": ["1", "2", "3"...]
+		positions[i] = column.Index
+		aligned = aligned && column.Index == i
+	}
+
+	// Column Information Map
+	columns := scheme.Columns
+	// Bytes of the previous files
+	base := stats.Bytes
+
+	// Columns whose values are checked against their types, and the ones with numbers in a format
+	checked := make(map[string]bool)
+	for _, header := range options.Checked {
+		checked[header] = true
+	}
+	for _, filter := range filters {
+		checked[filter.column] = true
+	}
+	var typed, formatted []string
+	for _, header := range scheme.Headers {
+		if columns[header].ColumnType != TypeString && (options.Checked == nil || checked[header]) {
+			typed = append(typed, header)
+		}
+		if columns[header].Format != nil {
+			formatted = append(formatted, header)
 		}
-	*/
-	groupingAttributesMap := make(map[string][]string)
-	// If columns are specified for grouping, add grouping columns to the header
-	if hasGroups {
-		headers = append(headers, groups...)
 	}
-	/*
-		If aggregations are specified,
-		only the aggregation columns are added to the final headers
-	*/
-	if hasAggregations {
-		for _, v := range aggregations {
-			headers = append(headers, v.Name())
-			aggragatedColumns[v.Column()] = true
+	// Positions of the columns of the scheme in the file, -1 for columns missing from the file
+	fields := make([]int, len(scheme.Headers))
+	for i := range fields {
+		fields[i] = -1
+	}
+	for i, position := range positions {
+		fields[position] = i
+	}
+	// valueError locates an error in the value of a column
+	valueError := func(record []string, header string, err error) *RowError {
+		line, _ := csvReader.FieldPos(0)
+		if field := fields[columns[header].Index]; field >= 0 {
+			line, _ = csvReader.FieldPos(field)
 		}
+		return &RowError{File: filepath, Line: line, Column: header, Record: record, Err: err}
 	}
 
-	if !hasAggregations && !hasGroups {
-		// If neither aggregation nor grouping is specified, add headers from the file
-		headers, err = csvReader.Read()
-		if err != nil {
-			return nil, err
+	// rowError passes a bad row to OnError, without it the row stops reading
+	rowError := func(rowErr *RowError) error {
+		if options.OnError == nil {
+			return rowErr
 		}
-	} else {
-		// Otherwise, we deliberately skip the line with the headers from the file
-		_, err = csvReader.Read()
-		if err != nil {
-			return nil, err
+		stats.Rejected++
+		if options.MaxErrors > 0 && stats.Rejected > options.MaxErrors {
+			return fmt.Errorf("more than %d rows with errors: %w", options.MaxErrors, rowErr)
 		}
+		return options.OnError(rowErr)
 	}
-	records = append(records, headers)
-
-	// Column Information Map
-	columns := scheme.Columns
 
 	for {
 		// Reading lines from a file
-		record, err := csvReader.Read()
-		if err != nil {
-			if errors.Is(err, io.EOF) {
+		fileRecord, err := csvReader.Read()
+		stats.Bytes = base + csvReader.InputOffset()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var parseErr *csv.ParseError
+		if err != nil && !errors.As(err, &parseErr) {
+			return err
+		}
+		stats.Read++
+		if options.Progress != nil {
+			options.Progress(stats.Bytes, stats.Read)
+		}
+		if parseErr != nil {
+			err := rowError(&RowError{File: filepath, Line: parseErr.StartLine, Record: fileRecord, Err: parseErr.Err})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		record := fileRecord
+		if !aligned {
+			record = make([]string, len(scheme.Headers))
+			for i, value := range fileRecord {
+				record[positions[i]] = value
+			}
+			if scheme.SourceColumn {
+				record[columns[SourceColumn].Index] = filepath
+			}
+		}
+
+		// Numbers in a format are read as plain numbers, values that aren't are left to the type check
+		for _, header := range formatted {
+			column := columns[header]
+			if number, _, err := column.Format.Parse(record[column.Index]); err == nil {
+				record[column.Index] = number
+			}
+		}
+
+		// Values that don't match their column types would fail filters, aggregations or sorting
+		var badValue *RowError
+		for _, header := range typed {
+			column := columns[header]
+			if err := column.ColumnType.Check(record[column.Index]); err != nil {
+				if options.NullMismatches {
+					record[column.Index] = ""
+					stats.Nulled++
+					continue
+				}
+				badValue = valueError(record, header, err)
 				break
 			}
-			return nil, err
+		}
+		if badValue != nil {
+			if err := rowError(badValue); err != nil {
+				return err
+			}
+			continue
 		}
 
 		// Filtering result
 		totalComparisonResult := true
 		// Checking a row against all filters
-		for _, filter := range filters {
+		for i, filter := range filters {
 			column := columns[filter.column]
 			columnValue := record[column.Index]
 
 			comparisonResult, err := column.ColumnType.Compare(columnValue, filter.comparisonValue, filter.comparisonType)
 			if err != nil {
-				return nil, err
+				badValue = valueError(record, filter.column, err)
+				break
 			}
 			if !comparisonResult {
 				totalComparisonResult = false
 				break
 			}
+			stats.Passed[i]++
+		}
+		if badValue != nil {
+			if err := rowError(badValue); err != nil {
+				return err
+			}
+			continue
 		}
 
 		if !totalComparisonResult {
+			stats.Filtered++
 			continue
 		}
 
-		// Key for grouping
-		groupKey := ""
-		/*
-			If grouping columns are specified,
-			generate key and insert data
-		*/
-		if hasGroups {
-			groupKey = generateGroupKey(record, groups, scheme)
-			if _, ok := groupingAttributesMap[groupKey]; !ok {
-				groupAttributes := make([]string, len(groups))
-				for i, v := range groups {
-					groupAttributes[i] = record[scheme.Columns[v].Index]
-				}
-				groupingAttributesMap[groupKey] = groupAttributes
-			}
-		}
-		/*
-			If aggregations are specified,
-			save data for grouping
-		*/
-		if hasAggregations {
-			if !hasGroups {
-				groupKey = ""
-			}
-			groupingColumns, ok := groupingMeasuresMap[groupKey]
-			if !ok {
-				groupingColumns = make(map[string][]string)
-			}
-			for columnName := range aggragatedColumns {
-				groupingValues := groupingColumns[columnName]
-				groupingValues = append(groupingValues, record[scheme.Columns[columnName].Index])
-				groupingColumns[columnName] = groupingValues
-			}
-			groupingMeasuresMap[groupKey] = groupingColumns
+		if err := emit(record); err != nil {
+			return err
 		}
+	}
 
-		if !hasAggregations && !hasGroups {
-			// Otherwise, save all data
-			records = append(records, record)
+	return nil
+}
+
+// FormatNumbers returns the record with the numbers of the columns with a number format written in their format
+func FormatNumbers(record []string, scheme Scheme) []string {
+	formatted := slices.Clone(record)
+	for i, header := range scheme.Headers {
+		if column := scheme.Columns[header]; column.Format != nil {
+			formatted[i] = column.Format.Format(record[i], column.Unit)
 		}
 	}
+	return formatted
+}
 
-	if hasAggregations || hasGroups {
-		for key, attributes := range groupingAttributesMap {
-			currentRecord := make([]string, len(aggregations)+len(groups))
-			copy(currentRecord, attributes)
-			for i, aggregation := range aggregations {
-				aggregationResult, err := aggregation.Aggregate(groupingMeasuresMap[key][aggregation.Column()])
-				if err != nil {
-					return nil, err
-				}
-				currentRecord[i+len(groups)] = aggregationResult
-			}
-			records = append(records, currentRecord)
-		}
+// ResultScheme describes the records produced by ProcessCSV for the given grouping and aggregations
+func ResultScheme(scheme Scheme, aggregations []Aggregator, groups []string) Scheme {
+	if len(aggregations) == 0 && len(groups) == 0 {
+		return scheme
 	}
 
-	return records, nil
+	result := Scheme{Columns: make(map[string]ColumnInfo)}
+	// Grouping columns go first and keep their types
+	for _, group := range groups {
+		column := scheme.Columns[group]
+		result.Columns[group] = ColumnInfo{
+			Index:      len(result.Headers),
+			ColumnType: column.ColumnType,
+			Format:     column.Format,
+			Unit:       column.Unit,
+		}
+		result.Headers = append(result.Headers, group)
+	}
+	// Aggregation columns are typed by the result of the aggregation, counts don't keep the number format
+	for _, aggregation := range aggregations {
+		column := ColumnInfo{
+			Index:      len(result.Headers),
+			ColumnType: aggregation.ResultType(),
+		}
+		if aggregation.AggregationType() != AggCount && aggregation.AggregationType() != AggCountDistinct {
+			column.Format = scheme.Columns[aggregation.Column()].Format
+			column.Unit = scheme.Columns[aggregation.Column()].Unit
+		}
+		result.Columns[aggregation.Name()] = column
+		result.Headers = append(result.Headers, aggregation.Name())
+	}
+	return result
 }
 
 func generateGroupKey(record []string, groups []string, scheme Scheme) string {
@@ -316,7 +640,8 @@ func SaveCSV(records [][]string, filepath string) error {
 func ParseFilter(filter string, scheme Scheme) (Filter, error) {
 	filterObj := Filter{}
 
-	re := regexp.MustCompile(`(?i)([a-z0-9]+)(!=|>=|<=|=|>|<)([a-z0-9]+)`)
+	// The column ends at the first operation, so that names like "address.city" can be filtered
+	re := regexp.MustCompile(`^\s*(.+?)\s*(!=|>=|<=|=|>|<)\s*(.+?)\s*$`)
 	filterParts := re.FindStringSubmatch(filter)
 
 	// [Whole string, column, comparison type, comparison value]
@@ -336,9 +661,8 @@ func ParseFilter(filter string, scheme Scheme) (Filter, error) {
 	}
 
 	// Check if non numeric value pass to the numeric type column
-	valueType := getTypeByValue(filterParts[3])
-	if column.ColumnType != TypeString && valueType != column.ColumnType {
-		return filterObj, fmt.Errorf("value type is '%s', column type is '%s'", valueType.Name(), column.ColumnType.Name())
+	if err := column.ColumnType.Check(filterParts[3]); err != nil {
+		return filterObj, err
 	}
 
 	filterObj.column = filterParts[1]
@@ -367,19 +691,6 @@ func parseOperation(op string) (comparisonType, error) {
 	}
 }
 
-func getTypeByValue(value string) ColumnTypeInterface {
-	if _, err := strconv.Atoi(value); err == nil {
-		return TypeInt
-	}
-
-	f, err := strconv.ParseFloat(value, 64)
-	if err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
-		return TypeFloat
-	}
-
-	return TypeString
-}
-
 func ParseAggregation(aggregationColumn string, aggregationType AggregationType, scheme Scheme) (Aggregator, error) {
 	// Check if column exists
 	columns := scheme.Columns
@@ -395,6 +706,11 @@ func ParseAggregation(aggregationColumn string, aggregationType AggregationType,
 
 	columnType := column.ColumnType
 
+	// Decimals have an aggregator of their own for the numeric aggregations
+	if columnType == TypeDecimal && aggregationType != AggCount && aggregationType != AggCountDistinct {
+		return DecimalAggregator{columnName: aggregationColumn, aggregationType: aggregationType}, nil
+	}
+
 	switch aggregationType {
 	case AggSum:
 		switch columnType {
@@ -0,0 +1,336 @@
+package csv
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var filterScheme = Scheme{
+	Headers: []string{"id", "price", "name", "address.city"},
+	Columns: map[string]ColumnInfo{
+		"id":           {Index: 0, ColumnType: TypeInt},
+		"price":        {Index: 1, ColumnType: TypeFloat},
+		"name":         {Index: 2, ColumnType: TypeString},
+		"address.city": {Index: 3, ColumnType: TypeString},
+	},
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   Filter
+	}{
+		// Syntax without spaces, as before
+		{"id=5", Filter{"id", Equal, "5"}},
+		{"id!=5", Filter{"id", NonEqual, "5"}},
+		{"id>=5", Filter{"id", GreaterOrEqual, "5"}},
+		{"id>5", Filter{"id", GreaterThan, "5"}},
+		{"id<=5", Filter{"id", LessOrEqual, "5"}},
+		{"id<5", Filter{"id", LessThan, "5"}},
+		{"name=bob", Filter{"name", Equal, "bob"}},
+		// Spaces around the operation and the filter
+		{"id >= 5", Filter{"id", GreaterOrEqual, "5"}},
+		{"  id > 5  ", Filter{"id", GreaterThan, "5"}},
+		{"name = New York", Filter{"name", Equal, "New York"}},
+		// Dotted names of flattened JSON
+		{"address.city=Berlin", Filter{"address.city", Equal, "Berlin"}},
+		{"address.city != Berlin", Filter{"address.city", NonEqual, "Berlin"}},
+		// Values the old syntax cut off or refused: decimals, negative numbers, ints of float columns
+		{"price>10.5", Filter{"price", GreaterThan, "10.5"}},
+		{"price > -3", Filter{"price", GreaterThan, "-3"}},
+		{"price<=100", Filter{"price", LessOrEqual, "100"}},
+		{"id>-1", Filter{"id", GreaterThan, "-1"}},
+		// The operation ends the name, so ">=" isn't read as ">" with the value "=5"
+		{"name>=a=b", Filter{"name", GreaterOrEqual, "a=b"}},
+	}
+	for _, test := range tests {
+		got, err := ParseFilter(test.filter, filterScheme)
+		if err != nil {
+			t.Errorf("ParseFilter(%q): %v", test.filter, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseFilter(%q) = %+v, want %+v", test.filter, got, test.want)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []string{
+		"id",         // no operation
+		"id=",        // no value
+		"=5",         // no column
+		"city=Paris", // unknown column, "address.city" is a different one
+		"id>5.5",     // float for an int column
+		"price>cheap",
+		"id==5",
+	}
+	for _, filter := range tests {
+		if got, err := ParseFilter(filter, filterScheme); err == nil {
+			t.Errorf("ParseFilter(%q) = %+v, want an error", filter, got)
+		}
+	}
+}
+
+// writeCSV writes the lines to a file in a temporary directory and returns its path
+func writeCSV(t *testing.T, name string, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func columnTypes(scheme Scheme) map[string]string {
+	types := make(map[string]string)
+	for name, column := range scheme.Columns {
+		types[name] = column.ColumnType.Name()
+	}
+	return types
+}
+
+func TestInferTypePromotion(t *testing.T) {
+	path := writeCSV(t, "numbers.csv",
+		"int,float,exponent,wide,long_fraction,wide_with_float,text,nulls",
+		"1,1,1,1,0.5,1,a,",
+		"-2,2.5,1e3,99999999999999999999,0.1234567890123456789,2.5,1,",
+		",,,,,,,",
+		"3,3,3,3,3,9007199254740993,2,",
+	)
+	scheme, err := ParseCSVStructureWith([]string{path}, InferOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"int":             "int",
+		"float":           "float",
+		"exponent":        "float",
+		"wide":            "decimal", // overflows int64
+		"long_fraction":   "decimal", // more digits than a float holds
+		"wide_with_float": "decimal", // an int too long for a float among floats
+		"text":            "string",
+		"nulls":           "string",
+	}
+	for column, typ := range columnTypes(scheme) {
+		if typ != want[column] {
+			t.Errorf("column %s is %s, want %s", column, typ, want[column])
+		}
+	}
+}
+
+func TestInferTypesAcrossFiles(t *testing.T) {
+	first := writeCSV(t, "a.csv", "a,b,c", "1,1,1")
+	second := writeCSV(t, "b.csv", "a,b,c", "2,2.5,99999999999999999999")
+	third := writeCSV(t, "c.csv", "c,a", "1.5,x")
+	scheme, err := ParseCSVStructureWith([]string{first, second, third}, InferOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := columnTypes(scheme)
+	if types["a"] != "string" || types["b"] != "float" || types["c"] != "decimal" {
+		t.Errorf("types %v, want a string, b float, c decimal", types)
+	}
+}
+
+func TestInferRowsAndTolerance(t *testing.T) {
+	lines := []string{"id,amount"}
+	for i := 1; i <= 100; i++ {
+		amount := "10"
+		if i%25 == 0 {
+			amount = "n/a"
+		}
+		lines = append(lines, strings.Repeat("1", i%5+1)+","+amount)
+	}
+	lines = append(lines, "x,5.5")
+	path := writeCSV(t, "sample.csv", lines...)
+
+	tests := []struct {
+		options    InferOptions
+		id, amount string
+	}{
+		// All rows: "x" is 1 outlier of 101 ids, 4 of 101 amounts are outliers
+		{InferOptions{}, "string", "string"},
+		{InferOptions{Tolerance: 0.05}, "int", "float"},
+		{InferOptions{Tolerance: 0.03}, "int", "string"},
+		// The first 20 rows have no outliers and no fraction
+		{InferOptions{Rows: 20}, "int", "int"},
+		// The first 50 rows have 2 outliers among 50 amounts
+		{InferOptions{Rows: 50}, "int", "string"},
+		{InferOptions{Rows: 50, Tolerance: 0.04}, "int", "int"},
+	}
+	for _, test := range tests {
+		scheme, err := ParseCSVStructureWith([]string{path}, test.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		types := columnTypes(scheme)
+		if types["id"] != test.id || types["amount"] != test.amount {
+			t.Errorf("%+v: id %s, amount %s, want %s and %s", test.options, types["id"], types["amount"], test.id, test.amount)
+		}
+	}
+
+	for _, options := range []InferOptions{{Rows: -1}, {Tolerance: -0.1}, {Tolerance: 1}} {
+		if _, err := ParseCSVStructureWith([]string{path}, options); err == nil {
+			t.Errorf("%+v accepted", options)
+		}
+	}
+}
+
+func TestReadToleratedOutliers(t *testing.T) {
+	path := writeCSV(t, "amounts.csv", "id,amount", "1,10", "2,n/a", "3,30", "4,40")
+	scheme, err := ParseCSVStructureWith([]string{path}, InferOptions{Tolerance: 0.25})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Outliers are nulls when asked for, the sum skips them
+	var amounts []string
+	stats, err := ReadCSVFilesWith([]string{path}, scheme, nil, func(record []string) error {
+		amounts = append(amounts, record[1])
+		return nil
+	}, ReadOptions{NullMismatches: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(amounts, ",") != "10,,30,40" || stats.Nulled != 1 {
+		t.Errorf("amounts %q with %d nulls, want 10,,30,40 and 1", amounts, stats.Nulled)
+	}
+	sum, err := ParseAggregation("amount", AggSum, scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := sum.Aggregate(amounts); got != "80" {
+		t.Errorf("sum %s, want 80", got)
+	}
+
+	// Otherwise they are bad values
+	_, err = ReadCSVFilesWith([]string{path}, scheme, nil, func([]string) error { return nil }, ReadOptions{})
+	if err == nil || !strings.Contains(err.Error(), `"n/a"`) {
+		t.Errorf("error %v, want the bad value", err)
+	}
+}
+
+func TestReadCheckedColumns(t *testing.T) {
+	path := writeCSV(t, "mixed.csv", "id,amount,note", "1,10,a", "2,oops,b", "x,30,c")
+	scheme := Scheme{
+		Headers: []string{"id", "amount", "note"},
+		Columns: map[string]ColumnInfo{
+			"id":     {Index: 0, ColumnType: TypeInt},
+			"amount": {Index: 1, ColumnType: TypeInt},
+			"note":   {Index: 2, ColumnType: TypeString},
+		},
+	}
+	amountFilter, err := ParseFilter("amount > 0", scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		filters []Filter
+		checked []string
+		rows    string // ids of the emitted rows
+		bad     string // columns of the rows passed to OnError
+	}{
+		{"all typed columns", nil, nil, "1", "amount,id"},
+		{"no columns", nil, []string{}, "1,2,x", ""},
+		{"one column", nil, []string{"id"}, "1,2", "id"},
+		{"filtered column", []Filter{amountFilter}, []string{}, "1,x", "amount"},
+	}
+	for _, test := range tests {
+		var rows, bad []string
+		_, err := ReadCSVFilesWith([]string{path}, scheme, test.filters, func(record []string) error {
+			rows = append(rows, record[0])
+			return nil
+		}, ReadOptions{Checked: test.checked, OnError: func(rowErr *RowError) error {
+			bad = append(bad, rowErr.Column)
+			return nil
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(rows, ",") != test.rows || strings.Join(bad, ",") != test.bad {
+			t.Errorf("%s: rows %v and bad values in %v, want %s and %s", test.name, rows, bad, test.rows, test.bad)
+		}
+	}
+}
+
+func TestReadMaxErrors(t *testing.T) {
+	path := writeCSV(t, "bad.csv", "id", "1", "a", "2", "b", "c", "3")
+	scheme := Scheme{Headers: []string{"id"}, Columns: map[string]ColumnInfo{"id": {Index: 0, ColumnType: TypeInt}}}
+	for _, test := range []struct {
+		maxErrors int
+		fails     bool
+	}{{0, false}, {3, false}, {2, true}} {
+		stats, err := ReadCSVFilesWith([]string{path}, scheme, nil, func([]string) error { return nil }, ReadOptions{
+			MaxErrors: test.maxErrors,
+			OnError:   func(*RowError) error { return nil },
+		})
+		if fails := err != nil; fails != test.fails {
+			t.Errorf("max errors %d: error %v", test.maxErrors, err)
+		}
+		if !test.fails && stats.Rejected != 3 {
+			t.Errorf("max errors %d: %d rows rejected, want 3", test.maxErrors, stats.Rejected)
+		}
+	}
+}
+
+func TestRowErrorLocatesBadValue(t *testing.T) {
+	first := writeCSV(t, "first.csv", "id,amount,note", "1,10,a", "2,20,b")
+	// The columns of the second file are in another order, its 3rd data row is on line 4
+	second := writeCSV(t, "second.csv", "note,id,amount", "c,3,30", "d,4,40", "e,5,4O", "f,6,60")
+	scheme := Scheme{
+		Headers: []string{"id", "amount", "note"},
+		Columns: map[string]ColumnInfo{
+			"id":     {Index: 0, ColumnType: TypeInt},
+			"amount": {Index: 1, ColumnType: TypeInt},
+			"note":   {Index: 2, ColumnType: TypeString},
+		},
+	}
+
+	check := func(name string, err error) {
+		t.Helper()
+		var rowErr *RowError
+		if !errors.As(err, &rowErr) {
+			t.Fatalf("%s: error %v, want a *RowError", name, err)
+		}
+		if rowErr.File != second || rowErr.Line != 4 || rowErr.Column != "amount" || !slices.Equal(rowErr.Record, []string{"5", "4O", "e"}) {
+			t.Errorf("%s: file %s, line %d, column %s, record %q", name, rowErr.File, rowErr.Line, rowErr.Column, rowErr.Record)
+		}
+		if want := second + `, line 4, column 'amount': value "4O" is not a valid int`; rowErr.Error() != want {
+			t.Errorf("%s: message %q, want %q", name, rowErr.Error(), want)
+		}
+		var valueErr *ValueError
+		if !errors.As(err, &valueErr) || valueErr.Value != "4O" || valueErr.Type != "int" {
+			t.Errorf("%s: value error %+v", name, valueErr)
+		}
+	}
+
+	var ids []string
+	_, err := ReadCSVFilesWith([]string{first, second}, scheme, nil, func(record []string) error {
+		ids = append(ids, record[0])
+		return nil
+	}, ReadOptions{})
+	check("failing", err)
+	if strings.Join(ids, ",") != "1,2,3,4" {
+		t.Errorf("rows %v before the bad one", ids)
+	}
+
+	// OnError gets the same error and reading goes on
+	ids = nil
+	_, err = ReadCSVFilesWith([]string{first, second}, scheme, nil, func(record []string) error {
+		ids = append(ids, record[0])
+		return nil
+	}, ReadOptions{OnError: func(rowErr *RowError) error {
+		check("skipped", rowErr)
+		return nil
+	}})
+	if err != nil || strings.Join(ids, ",") != "1,2,3,4,6" {
+		t.Errorf("rows %v, %v", ids, err)
+	}
+}
@@ -0,0 +1,329 @@
+package csv
+
+import (
+	"container/heap"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Maximum number of sorted runs merged at once
+const maxMergeFanIn = 64
+
+// Column of the result used for ordering
+type SortKey struct {
+	column     string
+	index      int
+	columnType ColumnTypeInterface
+	descending bool
+}
+
+// Column returns the name of the column
+func (k SortKey) Column() string {
+	return k.column
+}
+
+/*
+ParseSort parses an ordering specification like "region asc, revenue desc".
+The direction is optional and ascending by default.
+*/
+func ParseSort(spec string, scheme Scheme) ([]SortKey, error) {
+	return parseSort(spec, scheme, false)
+}
+
+/*
+ParseTopOrder parses the ranking of a top-N selection in the same format as ParseSort.
+The direction is descending by default, so that the greatest values come first.
+*/
+func ParseTopOrder(spec string, scheme Scheme) ([]SortKey, error) {
+	return parseSort(spec, scheme, true)
+}
+
+func parseSort(spec string, scheme Scheme, defaultDescending bool) ([]SortKey, error) {
+	var keys []SortKey
+
+	for _, part := range strings.Split(spec, ",") {
+		words := strings.Fields(part)
+		if len(words) == 0 {
+			return nil, errors.New("empty sort column")
+		}
+
+		descending := defaultDescending
+		switch strings.ToLower(words[len(words)-1]) {
+		case "asc":
+			descending = false
+			words = words[:len(words)-1]
+		case "desc":
+			descending = true
+			words = words[:len(words)-1]
+		}
+		if len(words) == 0 {
+			return nil, errors.New("sort direction without column")
+		}
+
+		// Check if column exists in the result
+		columnName := strings.Join(words, " ")
+		column, ok := scheme.Columns[columnName]
+		if !ok {
+			return nil, fmt.Errorf("sort by non-existent column '%s'", columnName)
+		}
+
+		keys = append(keys, SortKey{
+			column:     columnName,
+			index:      column.Index,
+			columnType: column.ColumnType,
+			descending: descending,
+		})
+	}
+
+	return keys, nil
+}
+
+// compareByKeys compares two records column by column using the column types
+func compareByKeys(a, b []string, keys []SortKey) (int, error) {
+	for _, key := range keys {
+		order, err := key.columnType.Order(a[key.index], b[key.index])
+		if err != nil {
+			return 0, fmt.Errorf("sorting by '%s': %w", key.column, err)
+		}
+		if key.descending {
+			order = -order
+		}
+		if order != 0 {
+			return order, nil
+		}
+	}
+	return 0, nil
+}
+
+/*
+Sorter is a stable external merge sort.
+Records are accumulated in memory; once bufferSize records are collected they are
+sorted and spilled to a temporary file, and the spilled runs are merged at the end.
+*/
+type Sorter struct {
+	keys       []SortKey
+	bufferSize int    // 0 means the records are never spilled
+	tempDir    string // directory for the spilled runs, the system default if empty
+	buffer     [][]string
+	runs       []string // paths of sorted runs in order of creation
+}
+
+func NewSorter(keys []SortKey, bufferSize int, tempDir string) *Sorter {
+	return &Sorter{keys: keys, bufferSize: bufferSize, tempDir: tempDir}
+}
+
+func (s *Sorter) Add(record []string) error {
+	s.buffer = append(s.buffer, record)
+	if s.bufferSize > 0 && len(s.buffer) >= s.bufferSize {
+		return s.spill()
+	}
+	return nil
+}
+
+// Each passes all added records to emit in sorted order
+func (s *Sorter) Each(emit func([]string) error) error {
+	err := s.each(emit)
+	if errors.Is(err, ErrStop) {
+		return nil
+	}
+	return err
+}
+
+func (s *Sorter) each(emit func([]string) error) error {
+	// Everything fits in memory
+	if len(s.runs) == 0 {
+		if err := s.sortBuffer(); err != nil {
+			return err
+		}
+		for _, record := range s.buffer {
+			if err := emit(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(s.buffer) != 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+
+	// Reduce the number of runs so that the final merge doesn't open too many files
+	for len(s.runs) > maxMergeFanIn {
+		var merged []string
+		for start := 0; start < len(s.runs); start += maxMergeFanIn {
+			end := min(start+maxMergeFanIn, len(s.runs))
+			path, err := s.mergeToRun(s.runs[start:end])
+			if err != nil {
+				return err
+			}
+			merged = append(merged, path)
+		}
+		s.removeRuns()
+		s.runs = merged
+	}
+
+	return mergeRuns(s.runs, s.keys, emit)
+}
+
+// Close removes the spilled runs
+func (s *Sorter) Close() error {
+	s.buffer = nil
+	return s.removeRuns()
+}
+
+func (s *Sorter) sortBuffer() error {
+	var sortErr error
+	slices.SortStableFunc(s.buffer, func(a, b []string) int {
+		order, err := compareByKeys(a, b, s.keys)
+		if err != nil && sortErr == nil {
+			sortErr = err
+		}
+		return order
+	})
+	return sortErr
+}
+
+func (s *Sorter) spill() error {
+	if err := s.sortBuffer(); err != nil {
+		return err
+	}
+
+	path, err := writeRun(s.tempDir, func(w *csv.Writer) error {
+		return w.WriteAll(s.buffer)
+	})
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, path)
+	s.buffer = nil
+	return nil
+}
+
+func (s *Sorter) mergeToRun(runs []string) (string, error) {
+	return writeRun(s.tempDir, func(w *csv.Writer) error {
+		return mergeRuns(runs, s.keys, w.Write)
+	})
+}
+
+func (s *Sorter) removeRuns() error {
+	var errs []error
+	for _, path := range s.runs {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	s.runs = nil
+	return errors.Join(errs...)
+}
+
+// writeRun creates a temporary CSV file and fills it with write
+func writeRun(tempDir string, write func(*csv.Writer) error) (string, error) {
+	file, err := os.CreateTemp(tempDir, "go-data-tool-sort-*.csv")
+	if err != nil {
+		return "", err
+	}
+	path := file.Name()
+
+	writer := csv.NewWriter(file)
+	err = write(writer)
+	if err == nil {
+		writer.Flush()
+		err = writer.Error()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// mergeRuns merges sorted runs; equal records are taken from earlier runs first to keep the sort stable
+func mergeRuns(runs []string, keys []SortKey, emit func([]string) error) error {
+	h := &mergeHeap{keys: keys}
+	for i, path := range runs {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		reader := csv.NewReader(f)
+		reader.FieldsPerRecord = -1
+		record, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				continue
+			}
+			return err
+		}
+		h.items = append(h.items, mergeItem{record: record, run: i, reader: reader})
+	}
+	heap.Init(h)
+
+	for h.Len() != 0 {
+		if h.err != nil {
+			return h.err
+		}
+		item := h.items[0]
+		if err := emit(item.record); err != nil {
+			return err
+		}
+
+		record, err := item.reader.Read()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				return err
+			}
+			heap.Pop(h)
+			continue
+		}
+		h.items[0].record = record
+		heap.Fix(h, 0)
+	}
+	return h.err
+}
+
+type mergeItem struct {
+	record []string
+	run    int
+	reader *csv.Reader
+}
+
+type mergeHeap struct {
+	items []mergeItem
+	keys  []SortKey
+	err   error // first comparison error
+}
+
+func (h *mergeHeap) Len() int { return len(h.items) }
+
+func (h *mergeHeap) Less(i, j int) bool {
+	order, err := compareByKeys(h.items[i].record, h.items[j].record, h.keys)
+	if err != nil && h.err == nil {
+		h.err = err
+	}
+	if order == 0 {
+		return h.items[i].run < h.items[j].run
+	}
+	return order < 0
+}
+
+func (h *mergeHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *mergeHeap) Push(x any) { h.items = append(h.items, x.(mergeItem)) }
+
+func (h *mergeHeap) Pop() any {
+	item := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return item
+}
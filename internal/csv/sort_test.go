@@ -0,0 +1,184 @@
+package csv
+
+import (
+	"math/rand"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var sortScheme = Scheme{
+	Headers: []string{"region", "count", "price", "seq"},
+	Columns: map[string]ColumnInfo{
+		"region": {Index: 0, ColumnType: TypeString},
+		"count":  {Index: 1, ColumnType: TypeInt},
+		"price":  {Index: 2, ColumnType: TypeFloat},
+		"seq":    {Index: 3, ColumnType: TypeInt},
+	},
+}
+
+// sortRecords returns records with few distinct keys, so that many of them are equal, and nulls among them
+func sortRecords(n int) [][]string {
+	random := rand.New(rand.NewSource(1))
+	regions := []string{"north", "south", "east", ""}
+	counts := []string{"-10", "2", "10", "100", ""}
+	prices := []string{"-0.5", "1.5", "1e2", "9.99", ""}
+	records := make([][]string, n)
+	for i := range records {
+		records[i] = []string{
+			regions[random.Intn(len(regions))],
+			counts[random.Intn(len(counts))],
+			prices[random.Intn(len(prices))],
+			strconv.Itoa(i),
+		}
+	}
+	return records
+}
+
+// sortAll adds the records to a sorter and returns them in the emitted order along with the largest number of runs
+func sortAll(t *testing.T, sorter *Sorter, records [][]string) ([][]string, int) {
+	t.Helper()
+	for _, record := range records {
+		if err := sorter.Add(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runs := len(sorter.runs)
+	var got [][]string
+	if err := sorter.Each(func(record []string) error {
+		got = append(got, record)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return got, runs
+}
+
+func TestSorterSpilledRuns(t *testing.T) {
+	records := sortRecords(50)
+	specs := []string{
+		"region",
+		"count desc",
+		"price asc, region desc",
+		"region, count desc, price",
+		"price desc, count",
+	}
+	for _, spec := range specs {
+		keys, err := ParseSort(spec, sortScheme)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Stable in-memory sort for reference, equal keys keep the order of seq
+		want := slices.Clone(records)
+		slices.SortStableFunc(want, func(a, b []string) int {
+			order, _ := compareByKeys(a, b, keys)
+			return order
+		})
+
+		for _, bufferSize := range []int{2, 3, 0} {
+			tempDir := t.TempDir()
+			sorter := NewSorter(keys, bufferSize, tempDir)
+			got, runs := sortAll(t, sorter, records)
+			if bufferSize != 0 && runs < 2 {
+				t.Errorf("%s, buffer %d: %d runs, want spilled runs", spec, bufferSize, runs)
+			}
+			if !slices.EqualFunc(got, want, slices.Equal) {
+				t.Errorf("%s, buffer %d: rows\n%q\nwant\n%q", spec, bufferSize, got, want)
+			}
+
+			if err := sorter.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+				t.Errorf("%s, buffer %d: %d files left after Close", spec, bufferSize, len(entries))
+			}
+		}
+	}
+}
+
+func TestSorterManyRuns(t *testing.T) {
+	// More runs than are merged at once are merged in rounds
+	records := sortRecords(3*maxMergeFanIn + 1)
+	keys, err := ParseSort("count, region desc", sortScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := slices.Clone(records)
+	slices.SortStableFunc(want, func(a, b []string) int {
+		order, _ := compareByKeys(a, b, keys)
+		return order
+	})
+
+	tempDir := t.TempDir()
+	sorter := NewSorter(keys, 2, tempDir)
+	defer sorter.Close()
+	got, runs := sortAll(t, sorter, records)
+	if runs <= maxMergeFanIn {
+		t.Errorf("%d runs, want more than %d", runs, maxMergeFanIn)
+	}
+	if !slices.EqualFunc(got, want, slices.Equal) {
+		t.Error("rows merged in rounds differ from the stable sort")
+	}
+	sorter.Close()
+	if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+		t.Errorf("%d files left after Close", len(entries))
+	}
+}
+
+func TestSorterStopsEarly(t *testing.T) {
+	keys, _ := ParseSort("seq desc", sortScheme)
+	sorter := NewSorter(keys, 2, t.TempDir())
+	defer sorter.Close()
+	for _, record := range sortRecords(9) {
+		sorter.Add(record)
+	}
+	var seqs []string
+	err := sorter.Each(func(record []string) error {
+		seqs = append(seqs, record[3])
+		if len(seqs) == 3 {
+			return ErrStop
+		}
+		return nil
+	})
+	if err != nil || strings.Join(seqs, ",") != "8,7,6" {
+		t.Errorf("rows %v, %v, want 8,7,6", seqs, err)
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	keys, err := ParseSort(" region DESC ,count, price asc", sortScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, key := range keys {
+		got = append(got, key.column+":"+strconv.FormatBool(key.descending))
+	}
+	if want := "region:true,count:false,price:false"; strings.Join(got, ",") != want {
+		t.Errorf("keys %v, want %s", got, want)
+	}
+
+	// Top rankings are descending by default
+	keys, err = ParseTopOrder("count, price asc", sortScheme)
+	if err != nil || !keys[0].descending || keys[1].descending {
+		t.Errorf("top order %+v, %v", keys, err)
+	}
+
+	tests := []struct {
+		spec, want string
+	}{
+		{"city", "non-existent column 'city'"},
+		{"count downwards", "non-existent column 'count downwards'"},
+		{"desc", "sort direction without column"},
+		{"count,", "empty sort column"},
+		{"", "empty sort column"},
+	}
+	for _, test := range tests {
+		_, err := ParseSort(test.spec, sortScheme)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("ParseSort(%q): error %v, want %q", test.spec, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package csv
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+)
+
+// Field is a named value of a record whose columns aren't known in advance
+type Field struct {
+	Name  string
+	Value string
+}
+
+/*
+RecordTable collects records whose columns are discovered along the way, e.g. flattened JSON objects.
+Records are spooled to a temporary file with the columns known so far,
+Save writes them to a CSV file with all columns, filling the missing ones with empty values.
+Columns are ordered by first appearance.
+*/
+type RecordTable struct {
+	file    *os.File
+	writer  *csv.Writer
+	index   map[string]int
+	headers []string
+	rows    int
+}
+
+func NewRecordTable(tempDir string) (*RecordTable, error) {
+	file, err := os.CreateTemp(tempDir, "table-*.csv")
+	if err != nil {
+		return nil, err
+	}
+	return &RecordTable{file: file, writer: csv.NewWriter(file), index: make(map[string]int)}, nil
+}
+
+func (t *RecordTable) Add(fields []Field) error {
+	for _, field := range fields {
+		if _, ok := t.index[field.Name]; !ok {
+			t.index[field.Name] = len(t.headers)
+			t.headers = append(t.headers, field.Name)
+		}
+	}
+	record := make([]string, len(t.headers))
+	for _, field := range fields {
+		record[t.index[field.Name]] = field.Value
+	}
+	t.rows++
+	return t.writer.Write(record)
+}
+
+func (t *RecordTable) Headers() []string {
+	return t.headers
+}
+
+func (t *RecordTable) Rows() int {
+	return t.rows
+}
+
+// Save writes the headers and all records to a CSV file
+func (t *RecordTable) Save(filepath string) error {
+	t.writer.Flush()
+	if err := t.writer.Error(); err != nil {
+		return err
+	}
+	if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	writer, err := NewWriter(filepath)
+	if err != nil {
+		return err
+	}
+	if err := writer.Write(t.headers); err != nil {
+		writer.Close()
+		return err
+	}
+
+	reader := csv.NewReader(t.file)
+	reader.FieldsPerRecord = -1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writer.Close()
+			return err
+		}
+		// Records written before a column appeared are shorter
+		for len(record) < len(t.headers) {
+			record = append(record, "")
+		}
+		if err := writer.Write(record); err != nil {
+			writer.Close()
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+// Close removes the temporary file
+func (t *RecordTable) Close() error {
+	t.file.Close()
+	return os.Remove(t.file.Name())
+}
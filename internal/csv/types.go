@@ -8,15 +8,23 @@ type Numeric interface {
 	~int | ~float64
 }
 
+// Name of the column holding the address of the file a row comes from
+const SourceColumn = "_source_file"
+
 // CSV file schema
 type Scheme struct {
-	Headers []string              // For the order of columns
-	Columns map[string]ColumnInfo // For storing index and column type
+	Headers      []string              // For the order of columns
+	Columns      map[string]ColumnInfo // For storing index and column type
+	SourceColumn bool                  // Whether the SourceColumn is filled while reading
 }
 
 type ColumnInfo struct {
 	Index      int
 	ColumnType ColumnTypeInterface
+	// Format of the numbers of the column in the files, nil for plain numbers.
+	// Values are read as plain numbers, FormatNumbers writes them back in the format
+	Format *NumberFormat
+	Unit   string // currency or percent sign all numbers of the column are written with
 }
 
 type Filter struct {
@@ -24,3 +32,9 @@ type Filter struct {
 	comparisonType  comparisonType // Type of comparison between the value in the column and the control value
 	comparisonValue string         // Control value for comparison
 }
+
+// Stage buffers records and releases them once all records are added
+type Stage interface {
+	Add([]string) error
+	Each(func([]string) error) error
+}
@@ -0,0 +1,64 @@
+package csv
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+)
+
+// Writer writes records to a CSV file one by one
+type Writer struct {
+	file   *os.File // nil when writing to a stream owned by the caller
+	writer *csv.Writer
+}
+
+func NewWriter(filepath string) (*Writer, error) {
+	file, err := os.Create(filepath)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{file: file, writer: csv.NewWriter(file)}, nil
+}
+
+// NewAppendWriter appends records to a file and reports whether the file was empty or didn't exist
+func NewAppendWriter(filepath string) (*Writer, bool, error) {
+	file, err := os.OpenFile(filepath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, false, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, false, err
+	}
+	return &Writer{file: file, writer: csv.NewWriter(file)}, info.Size() == 0, nil
+}
+
+// NewStreamWriter writes records to w, which is not closed by Close
+func NewStreamWriter(w io.Writer) *Writer {
+	return &Writer{writer: csv.NewWriter(w)}
+}
+
+func (w *Writer) Write(record []string) error {
+	return w.writer.Write(record)
+}
+
+// Flush writes the buffered records to the file
+func (w *Writer) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// Close flushes the buffered records and closes the file
+func (w *Writer) Close() error {
+	w.writer.Flush()
+	err := w.writer.Error()
+	if w.file == nil {
+		return err
+	}
+	if err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
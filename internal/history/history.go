@@ -0,0 +1,216 @@
+package history
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// File is an input or output of a run, the checksum is empty for directories and missing files
+type File struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+}
+
+// Record describes a single run of a command
+type Record struct {
+	ID       string              `json:"id"`
+	Command  string              `json:"command"` // like "parse" or "backup prune"
+	Args     []string            `json:"args,omitempty"`
+	Flags    map[string][]string `json:"flags,omitempty"` // flags set on the command line
+	Started  time.Time           `json:"started"`
+	Duration time.Duration       `json:"duration"`
+	Inputs   []File              `json:"inputs,omitempty"`
+	Outputs  []File              `json:"outputs,omitempty"`
+	Counts   map[string]int64    `json:"counts,omitempty"` // rows read, written and so on
+	Status   int                 `json:"status"`           // exit status
+	Error    string              `json:"error,omitempty"`
+}
+
+// NewRecord starts the record of a run with a random ID
+func NewRecord(command string, started time.Time) *Record {
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return &Record{ID: hex.EncodeToString(id), Command: command, Started: started}
+}
+
+// AddInputs adds files read by the run, hashing them right away
+func (r *Record) AddInputs(paths ...string) {
+	for _, path := range paths {
+		r.Inputs = append(r.Inputs, Describe(path))
+	}
+}
+
+// AddOutputs adds files written by the run, they are hashed by Finish
+func (r *Record) AddOutputs(paths ...string) {
+	for _, path := range paths {
+		r.Outputs = append(r.Outputs, File{Path: absolute(path)})
+	}
+}
+
+// Count sets a counter of the run
+func (r *Record) Count(name string, n int64) {
+	if r.Counts == nil {
+		r.Counts = make(map[string]int64)
+	}
+	r.Counts[name] = n
+}
+
+// Finish completes the record with the exit status and hashes the outputs
+func (r *Record) Finish(status int, err error, now time.Time) {
+	r.Duration = now.Sub(r.Started)
+	r.Status = status
+	if err != nil {
+		r.Error = err.Error()
+	}
+	for i, output := range r.Outputs {
+		r.Outputs[i] = Describe(output.Path)
+	}
+}
+
+// Produced reports whether the run wrote the file, by its checksum or, for files without one, by its path
+func (r *Record) Produced(file File) bool {
+	for _, output := range r.Outputs {
+		if file.SHA256 != "" && output.SHA256 == file.SHA256 {
+			return true
+		}
+		if file.SHA256 == "" && output.Path == file.Path {
+			return true
+		}
+	}
+	return false
+}
+
+// Describe returns the absolute path of a file with its size and SHA-256
+func Describe(path string) File {
+	file := File{Path: absolute(path)}
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return file
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return file
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return file
+	}
+	file.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	file.Size = info.Size()
+	return file
+}
+
+func absolute(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// Store keeps the records in a file with one JSON record per line
+type Store struct {
+	Path string
+}
+
+// DefaultPath is the history file in the user configuration directory
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "go-data-tool", "history.jsonl"), nil
+}
+
+/*
+Append adds a record to the end of the file. The record is written with a single write
+to a file opened for appending, so that records of concurrent runs don't interleave.
+*/
+func (s Store) Append(record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+/*
+Records reads all records from the oldest to the newest and counts the corrupt lines, which are skipped.
+A missing file holds no records. A write interrupted midway leaves a line without a newline,
+so the next record is glued to it; that record is still read, since every record starts with its ID.
+*/
+func (s Store) Records() ([]Record, int, error) {
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var records []Record
+	corrupt := 0
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			corrupt++
+			i := bytes.LastIndex(line, []byte(`{"id":`))
+			if i <= 0 || json.Unmarshal(line[i:], &record) != nil {
+				continue
+			}
+		}
+		records = append(records, record)
+	}
+	return records, corrupt, nil
+}
+
+// Find returns the record with the ID or a unique prefix of it
+func (s Store) Find(id string) (Record, error) {
+	records, _, err := s.Records()
+	if err != nil {
+		return Record{}, err
+	}
+	var found []Record
+	for _, record := range records {
+		if record.ID == id {
+			return record, nil
+		}
+		if id != "" && strings.HasPrefix(record.ID, id) {
+			found = append(found, record)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return Record{}, fmt.Errorf("run '%s' not found", id)
+	case 1:
+		return found[0], nil
+	}
+	return Record{}, fmt.Errorf("run ID '%s' is ambiguous, %d runs start with it", id, len(found))
+}
@@ -0,0 +1,81 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func recordIDs(records []Record) []string {
+	var ids []string
+	for _, record := range records {
+		ids = append(ids, record.ID)
+	}
+	return ids
+}
+
+func TestRecordsAfterTornAppend(t *testing.T) {
+	store := Store{Path: filepath.Join(t.TempDir(), "history.jsonl")}
+	started := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, id := range []string{"a1", "b2"} {
+		if err := store.Append(Record{ID: id, Command: "parse", Started: started}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A run killed while writing leaves half a record without a newline
+	file, err := os.OpenFile(store.Path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"id":"c3","command":"pa`)
+	file.Close()
+
+	records, corrupt, err := store.Records()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := recordIDs(records); !slices.Equal(ids, []string{"a1", "b2"}) || corrupt != 1 {
+		t.Errorf("records %v with %d corrupt lines, want [a1 b2] and 1", ids, corrupt)
+	}
+
+	// The next records are still read, the first of them is glued to the torn line
+	for _, id := range []string{"d4", "e5"} {
+		if err := store.Append(Record{ID: id, Command: "send", Started: started}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	records, corrupt, err = store.Records()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := recordIDs(records); !slices.Equal(ids, []string{"a1", "b2", "d4", "e5"}) || corrupt != 1 {
+		t.Errorf("records %v with %d corrupt lines, want [a1 b2 d4 e5] and 1", ids, corrupt)
+	}
+	if record, err := store.Find("d"); err != nil || record.Command != "send" {
+		t.Errorf("Find(d) = %+v, %v", record, err)
+	}
+}
+
+func TestRecordsSkipsGarbage(t *testing.T) {
+	store := Store{Path: filepath.Join(t.TempDir(), "history.jsonl")}
+	if err := os.WriteFile(store.Path, []byte("not json\n{\"id\":\"a1\",\"command\":\"diff\"}\n\n[1,2]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	records, corrupt, err := store.Records()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := recordIDs(records); !slices.Equal(ids, []string{"a1"}) || corrupt != 2 {
+		t.Errorf("records %v with %d corrupt lines, want [a1] and 2", ids, corrupt)
+	}
+}
+
+func TestRecordsMissingFile(t *testing.T) {
+	records, corrupt, err := Store{Path: filepath.Join(t.TempDir(), "none.jsonl")}.Records()
+	if records != nil || corrupt != 0 || err != nil {
+		t.Errorf("Records() = %v, %d, %v", records, corrupt, err)
+	}
+}
@@ -0,0 +1,37 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Format of the log records
+type Format string
+
+const (
+	TextFormat Format = "text" // key=value pairs
+	JSONFormat Format = "json" // one JSON object per line
+)
+
+// ParseLevel parses a level name: debug, info, warn or error
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.ToUpper(s))); err != nil {
+		return 0, fmt.Errorf("unknown log level '%s', expected debug, info, warn or error", s)
+	}
+	return level, nil
+}
+
+// NewLogger creates a logger writing records of the level and above to w
+func NewLogger(w io.Writer, format Format, level slog.Level) (*slog.Logger, error) {
+	options := &slog.HandlerOptions{Level: level}
+	switch format {
+	case TextFormat:
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	case JSONFormat:
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	}
+	return nil, fmt.Errorf("unknown log format '%s', expected text or json", format)
+}
@@ -0,0 +1,59 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := map[string]slog.Level{
+		"debug": slog.LevelDebug,
+		"INFO":  slog.LevelInfo,
+		"Warn":  slog.LevelWarn,
+		"error": slog.LevelError,
+	}
+	for text, want := range tests {
+		if level, err := ParseLevel(text); err != nil || level != want {
+			t.Errorf("ParseLevel(%s) = %v, %v, want %v", text, level, err, want)
+		}
+	}
+	for _, text := range []string{"verbose", "", "fatal"} {
+		if _, err := ParseLevel(text); err == nil || !strings.Contains(err.Error(), "unknown log level") {
+			t.Errorf("ParseLevel(%q): error %v", text, err)
+		}
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	var b bytes.Buffer
+	logger, err := NewLogger(&b, JSONFormat, slog.LevelWarn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("Skipped")
+	logger.Warn("Row rejected", "line", 4)
+	var record map[string]any
+	if err := json.Unmarshal(b.Bytes(), &record); err != nil {
+		t.Fatalf("JSON record %q: %v", b.String(), err)
+	}
+	if record["level"] != "WARN" || record["msg"] != "Row rejected" || record["line"] != float64(4) {
+		t.Errorf("JSON record %v", record)
+	}
+
+	b.Reset()
+	logger, err = NewLogger(&b, TextFormat, slog.LevelDebug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("Row rejected", "line", 4)
+	if text := b.String(); !strings.Contains(text, `level=DEBUG msg="Row rejected" line=4`) || strings.HasPrefix(text, "{") {
+		t.Errorf("text record %q", text)
+	}
+
+	if _, err := NewLogger(&b, "xml", slog.LevelInfo); err == nil {
+		t.Error("xml format accepted")
+	}
+}
@@ -0,0 +1,116 @@
+package logging
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// logFiles returns the contents of the log file and of its rotated files, the current one first
+func logFiles(t *testing.T, path string) []string {
+	t.Helper()
+	names, err := filepath.Glob(path + "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := make([]string, len(names))
+	for i := range contents {
+		name := path
+		if i > 0 {
+			name = rotatedName(path, i)
+		}
+		// Rotated files are numbered without gaps
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("log files %v: %v", names, err)
+		}
+		contents[i] = string(data)
+	}
+	return contents
+}
+
+func writeLines(t *testing.T, file *RotatingFile, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if n, err := file.Write([]byte(line)); err != nil || n != len(line) {
+			t.Fatalf("writing %q: %d, %v", line, n, err)
+		}
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tool.log")
+	file, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// Records that fit stay in the file
+	writeLines(t, file, "aaaa\n", "bbbb\n")
+	if got := logFiles(t, path); strings.Join(got, "|") != "aaaa\nbbbb\n" {
+		t.Errorf("files %q before the rotation", got)
+	}
+	writeLines(t, file, "cccc\n")
+	if got := logFiles(t, path); strings.Join(got, "|") != "cccc\n|aaaa\nbbbb\n" {
+		t.Errorf("files %q after the first rotation", got)
+	}
+	writeLines(t, file, "dddd\n", "eeee\n")
+	if got := logFiles(t, path); strings.Join(got, "|") != "eeee\n|cccc\ndddd\n|aaaa\nbbbb\n" {
+		t.Errorf("files %q after the second rotation", got)
+	}
+	// The oldest file is dropped beyond the maximum number of files
+	writeLines(t, file, "ffff\n", "gggg\n")
+	if got := logFiles(t, path); strings.Join(got, "|") != "gggg\n|eeee\nffff\n|cccc\ndddd\n" {
+		t.Errorf("files %q after the third rotation", got)
+	}
+
+	// A record larger than the maximum size gets a file of its own
+	large := strings.Repeat("x", 24) + "\n"
+	writeLines(t, file, large, "h\n")
+	if got := logFiles(t, path); strings.Join(got, "|") != "h\n|"+large+"|gggg\n" {
+		t.Errorf("files %q after a large record", got)
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("late\n")); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("write after close: error %v", err)
+	}
+}
+
+func TestRotatingFileReopened(t *testing.T) {
+	// The size of an existing file counts
+	path := filepath.Join(t.TempDir(), "tool.log")
+	if err := os.WriteFile(path, []byte("old entry\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := OpenRotatingFile(path, 12, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLines(t, file, "new\n")
+	file.Close()
+	if got := logFiles(t, path); strings.Join(got, "|") != "new\n|old entry\n" {
+		t.Errorf("files %q", got)
+	}
+
+	// Without rotated files the file starts over
+	file, err = OpenRotatingFile(path, 12, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLines(t, file, "newer entry\n")
+	file.Close()
+	if got := logFiles(t, path); strings.Join(got, "|") != "newer entry\n|old entry\n" {
+		t.Errorf("files %q without rotated files", got)
+	}
+
+	if _, err := OpenRotatingFile(path, -1, 1); err == nil {
+		t.Error("negative maximum size accepted")
+	}
+}
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Type of a metric
+type Type string
+
+const (
+	Gauge   Type = "gauge"
+	Counter Type = "counter"
+)
+
+// Sample is a value of a metric with its labels
+type Sample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// Metric is a named family of samples
+type Metric struct {
+	Name    string
+	Help    string
+	Type    Type
+	Samples []Sample
+}
+
+var namePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ValidLabel reports whether a label name is allowed by the Prometheus text format
+func ValidLabel(name string) bool {
+	return namePattern.MatchString(name) && !strings.HasPrefix(name, "__")
+}
+
+// Format renders the metrics in the Prometheus text exposition format
+func Format(metrics []Metric) ([]byte, error) {
+	var b bytes.Buffer
+	for _, metric := range metrics {
+		if !namePattern.MatchString(metric.Name) {
+			return nil, fmt.Errorf("invalid metric name '%s'", metric.Name)
+		}
+		fmt.Fprintf(&b, "# HELP %s %s\n", metric.Name, escapeHelp(metric.Help))
+		fmt.Fprintf(&b, "# TYPE %s %s\n", metric.Name, metric.Type)
+		for _, sample := range metric.Samples {
+			b.WriteString(metric.Name)
+			if len(sample.Labels) != 0 {
+				names := make([]string, 0, len(sample.Labels))
+				for name := range sample.Labels {
+					if !ValidLabel(name) {
+						return nil, fmt.Errorf("invalid label name '%s'", name)
+					}
+					names = append(names, name)
+				}
+				slices.Sort(names)
+				b.WriteByte('{')
+				for i, name := range names {
+					if i != 0 {
+						b.WriteByte(',')
+					}
+					fmt.Fprintf(&b, "%s=\"%s\"", name, escapeLabel(sample.Labels[name]))
+				}
+				b.WriteByte('}')
+			}
+			b.WriteByte(' ')
+			b.WriteString(strconv.FormatFloat(sample.Value, 'g', -1, 64))
+			b.WriteByte('\n')
+		}
+	}
+	return b.Bytes(), nil
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+/*
+WriteTextfile writes the metrics to a file for the textfile collector of node-exporter.
+The file is written under a temporary name and renamed, so that the collector never reads it half-written.
+*/
+func WriteTextfile(path string, metrics []Metric) error {
+	data, err := Format(metrics)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// Temporary files are created with mode 0600, the collector may run as another user
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
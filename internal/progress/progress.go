@@ -0,0 +1,138 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clock returns the current time of the progress, tests replace it
+var clock = time.Now
+
+// IsTerminal reports whether the file is an interactive terminal that can redraw a line
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0 && os.Getenv("TERM") != "dumb"
+}
+
+/*
+Line is a status line at the bottom of a terminal. Everything else written to the terminal
+goes through its Write, which clears the status line first and draws it again afterwards,
+so that log records don't get mixed with it.
+*/
+type Line struct {
+	mu     sync.Mutex
+	out    io.Writer
+	status string
+}
+
+func NewLine(out io.Writer) *Line {
+	return &Line{out: out}
+}
+
+// Write writes p above the status line
+func (l *Line) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.status == "" {
+		return l.out.Write(p)
+	}
+	io.WriteString(l.out, "\r\033[K")
+	n, err := l.out.Write(p)
+	io.WriteString(l.out, l.status)
+	return n, err
+}
+
+// Set replaces the status line, an empty status removes it
+func (l *Line) Set(status string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.status = status
+	io.WriteString(l.out, "\r\033[K"+status)
+}
+
+// Reporter shows the progress of reading files of a known total size on a status line
+type Reporter struct {
+	line     *Line
+	total    int64 // bytes
+	interval time.Duration
+	start    time.Time
+	drawn    time.Time
+}
+
+// NewReporter reports the progress of reading total bytes, redrawing the line at most every interval
+func NewReporter(line *Line, total int64, interval time.Duration) *Reporter {
+	now := clock()
+	return &Reporter{line: line, total: total, interval: interval, start: now, drawn: now}
+}
+
+// Update shows the bytes and rows read so far
+func (r *Reporter) Update(bytes int64, rows int) {
+	now := clock()
+	if now.Sub(r.drawn) < r.interval {
+		return
+	}
+	r.drawn = now
+	r.line.Set(Status(bytes, r.total, rows, now.Sub(r.start)))
+}
+
+// Finish removes the status line
+func (r *Reporter) Finish() {
+	r.line.Set("")
+}
+
+// Status describes the progress: share and amount of bytes read, rows, throughput and the estimated time left
+func Status(bytes, total int64, rows int, elapsed time.Duration) string {
+	var b strings.Builder
+	if total > 0 {
+		fmt.Fprintf(&b, "%5.1f%% ", 100*float64(bytes)/float64(total))
+	}
+	fmt.Fprintf(&b, "%s / %s, %s rows", Bytes(bytes), Bytes(total), count(rows))
+
+	seconds := elapsed.Seconds()
+	if seconds <= 0 || bytes == 0 {
+		return b.String()
+	}
+	rate := float64(bytes) / seconds
+	fmt.Fprintf(&b, ", %s/s, %s rows/s", Bytes(int64(rate)), count(int(float64(rows)/seconds)))
+	if total > bytes {
+		left := time.Duration(float64(total-bytes) / rate * float64(time.Second))
+		fmt.Fprintf(&b, ", ETA %s", left.Round(time.Second))
+	}
+	return b.String()
+}
+
+// Bytes formats a size with a binary unit like "12.3 MiB"
+func Bytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value := float64(n)
+	prefixes := "KMGTPE"
+	i := -1
+	for value >= unit && i < len(prefixes)-1 {
+		value /= unit
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, prefixes[i])
+}
+
+// count formats a number with thousands separators
+func count(n int) string {
+	s := fmt.Sprint(n)
+	var b strings.Builder
+	for i, digit := range s {
+		if i != 0 && (len(s)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(digit)
+	}
+	return b.String()
+}
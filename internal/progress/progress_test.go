@@ -0,0 +1,92 @@
+package progress
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// setClock makes the package see a fixed time that the test moves with the returned function
+func setClock(t *testing.T, start time.Time) func(time.Duration) {
+	current := start
+	clock = func() time.Time { return current }
+	t.Cleanup(func() { clock = time.Now })
+	return func(d time.Duration) { current = current.Add(d) }
+}
+
+func TestIsTerminal(t *testing.T) {
+	// Redirected standard error is a file or a pipe
+	file, err := os.Create(filepath.Join(t.TempDir(), "stderr.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if IsTerminal(file) {
+		t.Error("file is a terminal")
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	if IsTerminal(w) {
+		t.Error("pipe is a terminal")
+	}
+}
+
+func TestReporter(t *testing.T) {
+	advance := setClock(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	var b bytes.Buffer
+	line := NewLine(&b)
+	reporter := NewReporter(line, 4096, time.Second)
+
+	// The line is redrawn at most every interval
+	advance(500 * time.Millisecond)
+	reporter.Update(512, 700)
+	if b.Len() != 0 {
+		t.Errorf("drawn before the interval: %q", b.String())
+	}
+	advance(1500 * time.Millisecond)
+	reporter.Update(1024, 1500)
+	status := " 25.0% 1.0 KiB / 4.0 KiB, 1,500 rows, 512 B/s, 750 rows/s, ETA 6s"
+	if want := "\r\033[K" + status; b.String() != want {
+		t.Errorf("line %q, want %q", b.String(), want)
+	}
+
+	// Log records go above the status line, which is drawn again
+	b.Reset()
+	line.Write([]byte("level=INFO msg=Parsed\n"))
+	if want := "\r\033[Klevel=INFO msg=Parsed\n" + status; b.String() != want {
+		t.Errorf("record written as %q, want %q", b.String(), want)
+	}
+
+	b.Reset()
+	reporter.Finish()
+	line.Write([]byte("done\n"))
+	if want := "\r\033[Kdone\n"; b.String() != want {
+		t.Errorf("finished line %q, want %q", b.String(), want)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		bytes, total int64
+		rows         int
+		elapsed      time.Duration
+		want         string
+	}{
+		{0, 0, 0, 0, "0 B / 0 B, 0 rows"},
+		{0, 2048, 0, time.Second, "  0.0% 0 B / 2.0 KiB, 0 rows"},
+		{3 << 20, 3 << 20, 1234567, 3 * time.Second, "100.0% 3.0 MiB / 3.0 MiB, 1,234,567 rows, 1.0 MiB/s, 411,522 rows/s"},
+		{1 << 30, 5 << 30, 999, 4 * time.Minute, " 20.0% 1.0 GiB / 5.0 GiB, 999 rows, 4.3 MiB/s, 4 rows/s, ETA 16m0s"},
+		{1536, 0, 10, 0, "1.5 KiB / 0 B, 10 rows"},
+	}
+	for _, test := range tests {
+		if got := Status(test.bytes, test.total, test.rows, test.elapsed); got != test.want {
+			t.Errorf("Status(%d, %d, %d, %s) = %q, want %q", test.bytes, test.total, test.rows, test.elapsed, got, test.want)
+		}
+	}
+}